@@ -0,0 +1,246 @@
+package gomail
+
+import (
+	"errors"
+	"mime"
+	stdmail "net/mail"
+	"strings"
+)
+
+// An Address is either a mailbox, such as "Alice <alice@example.com>", or a
+// group of mailboxes, such as "Team: alice@example.com, bob@example.com;" or
+// "undisclosed-recipients:;" (RFC 5322, section 3.4).
+type Address struct {
+	// Name is the display name of the mailbox or of the group.
+	Name string
+	// Address is the email address of a mailbox. It is empty for groups.
+	Address string
+	// Members are the mailboxes of a group.
+	Members []Address
+}
+
+// IsGroup reports whether a is a group.
+func (a Address) IsGroup() bool {
+	return a.Address == ""
+}
+
+// An AddressList is the list of addresses of a header field.
+type AddressList []Address
+
+// Mailboxes returns the mailboxes of the list, including the members of its
+// groups.
+func (l AddressList) Mailboxes() []Address {
+	var list []Address
+	for _, a := range l {
+		if a.IsGroup() {
+			list = append(list, a.Members...)
+		} else {
+			list = append(list, a)
+		}
+	}
+	return list
+}
+
+// ParseAddress parses a single RFC 5322 address, which can be a group.
+func ParseAddress(s string) (Address, error) {
+	list, err := ParseAddressList(s)
+	if err != nil {
+		return Address{}, err
+	}
+	if len(list) != 1 {
+		return Address{}, &InvalidAddress{s, errors.New("expected a single address")}
+	}
+	return list[0], nil
+}
+
+// ParseAddressList parses a comma-separated list of RFC 5322 addresses, such
+// as the value of a To header field. Groups are supported, and the display
+// names encoded with RFC 2047 are decoded.
+func ParseAddressList(s string) (AddressList, error) {
+	tokens, err := splitAddressList(s)
+	if err != nil {
+		return nil, &InvalidAddress{s, err}
+	}
+
+	var list AddressList
+	var group *Address
+	for _, t := range tokens {
+		switch {
+		case t.groupStart:
+			group = &Address{Name: decodePhrase(t.value)}
+		case t.groupEnd:
+			list = append(list, *group)
+			group = nil
+		default:
+			addr, err := stdmail.ParseAddress(t.value)
+			if err != nil {
+				return nil, &InvalidAddress{t.value, err}
+			}
+			a := Address{Name: addr.Name, Address: addr.Address}
+			if group != nil {
+				group.Members = append(group.Members, a)
+			} else {
+				list = append(list, a)
+			}
+		}
+	}
+
+	return list, nil
+}
+
+type addressToken struct {
+	value      string
+	groupStart bool
+	groupEnd   bool
+}
+
+// splitAddressList splits s into mailboxes and group delimiters, ignoring the
+// separators found in quoted strings, comments, angle brackets and domain
+// literals such as "[192.0.2.1]".
+func splitAddressList(s string) ([]addressToken, error) {
+	var tokens []addressToken
+	inGroup, inQuote, inAngle, inLiteral, comment := false, false, false, false, 0
+	start := 0
+	flush := func(end int) {
+		if v := strings.TrimSpace(s[start:end]); v != "" {
+			tokens = append(tokens, addressToken{value: v})
+		}
+		start = end + 1
+	}
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && (inQuote || inLiteral || comment > 0):
+			i++
+		case inQuote:
+			inQuote = c != '"'
+		case inLiteral:
+			inLiteral = c != ']'
+		case c == '(':
+			comment++
+		case c == ')' && comment > 0:
+			comment--
+		case comment > 0:
+		case c == '"':
+			inQuote = true
+		case c == '[':
+			inLiteral = true
+		case c == '<':
+			inAngle = true
+		case c == '>':
+			inAngle = false
+		case inAngle:
+		case c == ',':
+			flush(i)
+		case c == ':':
+			if inGroup {
+				return nil, errors.New("nested group")
+			}
+			name := strings.TrimSpace(s[start:i])
+			if name == "" {
+				return nil, errors.New("group without display name")
+			}
+			tokens = append(tokens, addressToken{value: name, groupStart: true})
+			start = i + 1
+			inGroup = true
+		case c == ';':
+			if !inGroup {
+				return nil, errors.New("unexpected ';'")
+			}
+			flush(i)
+			tokens = append(tokens, addressToken{groupEnd: true})
+			inGroup = false
+		}
+	}
+	if inQuote || inAngle || inLiteral || comment > 0 {
+		return nil, errors.New("unterminated address")
+	}
+	if inGroup {
+		return nil, errors.New("unterminated group")
+	}
+	flush(len(s))
+
+	return tokens, nil
+}
+
+// decodePhrase decodes the display name of a group.
+func decodePhrase(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		var sb strings.Builder
+		for i := 1; i < len(s)-1; i++ {
+			if s[i] == '\\' && i+1 < len(s)-1 {
+				i++
+			}
+			sb.WriteByte(s[i])
+		}
+		return sb.String()
+	}
+
+	dec := new(mime.WordDecoder)
+	if decoded, err := dec.DecodeHeader(s); err == nil {
+		return decoded
+	}
+	return s
+}
+
+// SetAddresses sets the addresses of the given header field.
+func (m *Message) SetAddresses(field string, addresses ...Address) {
+	m.header[field] = make([]string, len(addresses))
+	for i, a := range addresses {
+		m.header[field][i] = m.formatAddress(a)
+	}
+	m.addresses[field] = addresses
+}
+
+// AddAddress adds an address to the given header field.
+func (m *Message) AddAddress(field string, address Address) {
+	list, err := m.GetAddresses(field)
+	if err != nil {
+		// The raw values cannot be parsed: they are kept as they are.
+		m.header[field] = append(m.header[field], m.formatAddress(address))
+		return
+	}
+	m.SetAddresses(field, append(list, address)...)
+}
+
+// GetAddresses returns the addresses of the given header field, whether they
+// were set with SetAddresses or as strings with SetHeader.
+func (m *Message) GetAddresses(field string) (AddressList, error) {
+	if list, ok := m.addresses[field]; ok {
+		return list, nil
+	}
+
+	var list AddressList
+	for _, v := range m.header[field] {
+		l, err := ParseAddressList(v)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, l...)
+	}
+	return list, nil
+}
+
+func (m *Message) formatAddress(a Address) string {
+	if !a.IsGroup() {
+		return m.FormatAddress(a.Address, a.Name)
+	}
+
+	members := make([]string, len(a.Members))
+	for i, member := range a.Members {
+		members[i] = m.FormatAddress(member.Address, member.Name)
+	}
+
+	m.writePhrase(a.Name)
+	m.buf.WriteByte(':')
+	if len(members) > 0 {
+		m.buf.WriteByte(' ')
+		m.buf.WriteString(strings.Join(members, ", "))
+	}
+	m.buf.WriteByte(';')
+
+	group := m.buf.String()
+	m.buf.Reset()
+	return group
+}
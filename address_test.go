@@ -0,0 +1,180 @@
+package gomail
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseAddressList(t *testing.T) {
+	tests := []struct {
+		in   string
+		want AddressList
+	}{
+		{
+			in:   "to@example.com",
+			want: AddressList{{Address: "to@example.com"}},
+		},
+		{
+			in: `"Doe, John" <john@example.com>, jane@example.com (Jane)`,
+			want: AddressList{
+				{Name: "Doe, John", Address: "john@example.com"},
+				{Name: "Jane", Address: "jane@example.com"},
+			},
+		},
+		{
+			in:   "undisclosed-recipients:;",
+			want: AddressList{{Name: "undisclosed-recipients"}},
+		},
+		{
+			in: `=?UTF-8?q?=C3=89quipe?=: a@example.com, "B" <b@example.com>; c@example.com`,
+			want: AddressList{
+				{Name: "Équipe", Members: []Address{
+					{Address: "a@example.com"},
+					{Name: "B", Address: "b@example.com"},
+				}},
+				{Address: "c@example.com"},
+			},
+		},
+		{
+			in:   `"Team: A; B": <team@example.com>;`,
+			want: AddressList{{Name: "Team: A; B", Members: []Address{{Address: "team@example.com"}}}},
+		},
+		{
+			in: "user@[IPv6:2001:db8::1], John <john@[192.0.2.1]>",
+			want: AddressList{
+				{Address: "user@[IPv6:2001:db8::1]"},
+				{Name: "John", Address: "john@[192.0.2.1]"},
+			},
+		},
+		{
+			in:   "Team: user@[IPv6:2001:db8::1];",
+			want: AddressList{{Name: "Team", Members: []Address{{Address: "user@[IPv6:2001:db8::1]"}}}},
+		},
+	}
+
+	for _, test := range tests {
+		got, err := ParseAddressList(test.in)
+		if err != nil {
+			t.Errorf("ParseAddressList(%q): %v", test.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseAddressList(%q), got %#v, want %#v", test.in, got, test.want)
+		}
+	}
+}
+
+func TestParseAddressListInvalid(t *testing.T) {
+	for _, in := range []string{
+		"not an address",
+		"group: a@example.com",
+		"a@example.com;",
+		"outer: inner: a@example.com;;",
+		`"unterminated <a@example.com>`,
+		"a@[192.0.2.1, b@example.com",
+	} {
+		if _, err := ParseAddressList(in); !errors.Is(err, &InvalidAddress{}) {
+			t.Errorf("ParseAddressList(%q), got error %v, want an InvalidAddress", in, err)
+		}
+	}
+}
+
+func TestSplitAddressListDomainLiteral(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{in: "a@[x,y], b@example.com", want: []string{"a@[x,y]", "b@example.com"}},
+		{in: `a@[x"y], b@example.com`, want: []string{`a@[x"y]`, "b@example.com"}},
+		{in: `a@[x\],y], b@example.com`, want: []string{`a@[x\],y]`, "b@example.com"}},
+	}
+
+	for _, test := range tests {
+		tokens, err := splitAddressList(test.in)
+		if err != nil {
+			t.Errorf("splitAddressList(%q): %v", test.in, err)
+			continue
+		}
+		var got []string
+		for _, token := range tokens {
+			got = append(got, token.value)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitAddressList(%q), got %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	a, err := ParseAddress("Team: a@example.com;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !a.IsGroup() || a.Name != "Team" || len(a.Members) != 1 {
+		t.Errorf("invalid group: %#v", a)
+	}
+
+	if _, err := ParseAddress("a@example.com, b@example.com"); err == nil {
+		t.Error("ParseAddress should fail on several addresses")
+	}
+}
+
+func TestSetAddresses(t *testing.T) {
+	m := NewMessage()
+	m.SetAddresses("From", Address{Name: "Señor From", Address: "from@example.com"})
+	m.SetAddresses("To",
+		Address{Name: "Team", Members: []Address{
+			{Name: "A", Address: "a@example.com"},
+			{Address: "b@example.com"},
+		}},
+		Address{Name: "undisclosed-recipients"},
+	)
+	m.AddAddress("To", Address{Address: "c@example.com"})
+	m.SetHeader("Cc", "cc1@example.com, Doe <cc2@example.com>")
+	m.AddAddress("Cc", Address{Address: "a@example.com"})
+	m.SetBody("text/plain", "Test message")
+
+	want := &message{
+		from: "from@example.com",
+		to:   []string{"a@example.com", "b@example.com", "c@example.com", "cc1@example.com", "cc2@example.com"},
+		content: "From: =?UTF-8?q?Se=C3=B1or_From?= <from@example.com>\r\n" +
+			"To: \"Team\": \"A\" <a@example.com>, b@example.com;, \"undisclosed-recipients\":;, c@example.com\r\n" +
+			"Cc: cc1@example.com, \"Doe\" <cc2@example.com>, a@example.com\r\n" +
+			"Content-Type: text/plain; charset=UTF-8\r\n" +
+			"Content-Transfer-Encoding: quoted-printable\r\n" +
+			"\r\n" +
+			"Test message",
+	}
+
+	testMessage(t, m, 0, want)
+
+	list, err := m.GetAddresses("From")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (AddressList{{Name: "Señor From", Address: "from@example.com"}}); !reflect.DeepEqual(list, want) {
+		t.Errorf("invalid From addresses, got %#v, want %#v", list, want)
+	}
+}
+
+func TestUndisclosedRecipients(t *testing.T) {
+	m := NewMessage()
+	m.SetHeader("From", "from@example.com")
+	m.SetHeader("To", "undisclosed-recipients:;")
+	m.SetHeader("Bcc", "bcc@example.com")
+	m.SetBody("text/plain", "Test message")
+
+	want := &message{
+		from: "from@example.com",
+		to:   []string{"bcc@example.com"},
+		content: "From: from@example.com\r\n" +
+			"To: undisclosed-recipients:;\r\n" +
+			"Content-Type: text/plain; charset=UTF-8\r\n" +
+			"Content-Transfer-Encoding: quoted-printable\r\n" +
+			"\r\n" +
+			"Test message",
+	}
+
+	testMessage(t, m, 0, want)
+}
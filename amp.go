@@ -0,0 +1,183 @@
+package gomail
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"sort"
+	"strings"
+)
+
+const (
+	// ampContentType is the media type of AMP for Email parts.
+	ampContentType = "text/x-amp-html"
+	// maxAMPSize is the maximum size of the AMP part accepted by Gmail.
+	maxAMPSize = 200 * 1024
+	// ampRuntime is the AMP runtime script required by AMP for Email.
+	ampRuntime = "https://cdn.ampproject.org/v0.js"
+)
+
+// AddAMP adds an AMP for Email alternative part to the message, with the
+// text/x-amp-html content type. Whatever the order in which the alternatives
+// are added, the AMP part is written after the plain text part and before the
+// HTML part, which clients not supporting AMP display.
+//
+// The AMP part is checked against the basic requirements of AMP for Email
+// before the message is written: the html element must have the ⚡4email or
+// amp4email attribute, the document must declare the UTF-8 charset and load
+// the AMP runtime and boilerplate style, it must not exceed 200 KB and the
+// message must have an HTML alternative. An InvalidAMPError is returned
+// otherwise.
+func (m *Message) AddAMP(body string, settings ...PartSetting) {
+	m.AddAMPWriter(newCopier(body), settings...)
+}
+
+// AddAMPWriter adds an AMP for Email alternative part to the message. See
+// AddAMP. The content is read once, the first time the message is written or
+// its size computed, and kept in memory to be validated and written.
+func (m *Message) AddAMPWriter(f func(io.Writer) error, settings ...PartSetting) {
+	m.AddAlternativeWriter(ampContentType, f, settings...)
+}
+
+// isAMP reports whether p is an AMP for Email part.
+func (p *part) isAMP() bool {
+	return p.mediaType() == ampContentType
+}
+
+func (p *part) mediaType() string {
+	mediaType, _, err := mime.ParseMediaType(p.contentType)
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
+
+// orderedParts returns the parts of m in the order they are written. When
+// the message has an AMP part, the plain text, AMP and HTML parts are sorted
+// in that order, while the other parts keep their position.
+func (m *Message) orderedParts() []*part {
+	hasAMP := false
+	for _, p := range m.parts {
+		hasAMP = hasAMP || p.isAMP()
+	}
+	if !hasAMP {
+		return m.parts
+	}
+
+	var ranked []*part
+	var slots []int
+	for i, p := range m.parts {
+		if p.ampRank() >= 0 {
+			ranked = append(ranked, p)
+			slots = append(slots, i)
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].ampRank() < ranked[j].ampRank()
+	})
+
+	parts := append([]*part(nil), m.parts...)
+	for i, slot := range slots {
+		parts[slot] = ranked[i]
+	}
+	return parts
+}
+
+// ampRank returns the rank of p among the alternatives of an AMP message, or
+// -1 if p is neither a plain text, an AMP nor an HTML part.
+func (p *part) ampRank() int {
+	switch p.mediaType() {
+	case "text/plain":
+		return 0
+	case ampContentType:
+		return 1
+	case "text/html":
+		return 2
+	}
+	return -1
+}
+
+// validateAMP checks the AMP parts of m.
+func (m *Message) validateAMP() error {
+	var amp []*part
+	hasHTML := false
+	for _, p := range m.parts {
+		switch {
+		case p.isAMP():
+			amp = append(amp, p)
+		case p.mediaType() == "text/html":
+			hasHTML = true
+		}
+	}
+	if len(amp) == 0 {
+		return nil
+	}
+	if !hasHTML {
+		return &InvalidAMPError{Reason: "no text/html alternative"}
+	}
+
+	for _, p := range amp {
+		var buf bytes.Buffer
+		if err := p.copier(&buf); err != nil {
+			return err
+		}
+		// The content is kept so that it is not read again to be written.
+		body := buf.String()
+		p.copier = newCopier(body)
+		if err := validateAMPBody(body); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func validateAMPBody(body string) error {
+	if len(body) > maxAMPSize {
+		return &InvalidAMPError{Reason: "the part exceeds 200 KB"}
+	}
+
+	var hasHTML, isAMP, hasCharset, hasRuntime, hasBoilerplate bool
+	attr := func(attrs map[string][2]int, name string) (string, bool) {
+		v, ok := attrs[name]
+		if !ok || v[0] < 0 {
+			return "", ok
+		}
+		return body[v[0]:v[1]], true
+	}
+	_ = scanTags(body, func(name string, attrs map[string][2]int) error {
+		switch name {
+		case "html":
+			if !hasHTML {
+				hasHTML = true
+				_, bolt := attrs["⚡4email"]
+				_, amp4email := attrs["amp4email"]
+				isAMP = bolt || amp4email
+			}
+		case "meta":
+			charset, _ := attr(attrs, "charset")
+			hasCharset = hasCharset || strings.EqualFold(charset, "utf-8")
+		case "script":
+			src, _ := attr(attrs, "src")
+			_, async := attrs["async"]
+			hasRuntime = hasRuntime || (src == ampRuntime && async)
+		case "style":
+			_, ok := attrs["amp4email-boilerplate"]
+			hasBoilerplate = hasBoilerplate || ok
+		}
+		return nil
+	})
+
+	switch {
+	case !isAMP:
+		return &InvalidAMPError{Reason: "the html element has no ⚡4email attribute"}
+	case !hasCharset:
+		return &InvalidAMPError{Reason: `no <meta charset="utf-8"> element`}
+	case !hasRuntime:
+		return &InvalidAMPError{Reason: `no <script async src="` + ampRuntime + `"> element`}
+	case !hasBoilerplate:
+		return &InvalidAMPError{Reason: "no <style amp4email-boilerplate> element"}
+	}
+
+	return nil
+}
@@ -0,0 +1,144 @@
+package gomail
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+const testAMPBody = `<!doctype html><html ⚡4email data-css-strict><head><meta charset="utf-8">` +
+	`<script async src="https://cdn.ampproject.org/v0.js"></script>` +
+	`<style amp4email-boilerplate>body{visibility:hidden}</style></head>` +
+	`<body>Hello</body></html>`
+
+func TestAMP(t *testing.T) {
+	m := NewMessage()
+	m.SetHeader("From", "from@example.com")
+	m.SetHeader("To", "to@example.com")
+	m.SetBody("text/plain", "Hello")
+	m.AddAlternative("text/html", "<b>Hello</b>")
+	m.AddAMP(testAMPBody, SetPartEncoding(Unencoded))
+
+	want := &message{
+		from: "from@example.com",
+		to:   []string{"to@example.com"},
+		content: "From: from@example.com\r\n" +
+			"To: to@example.com\r\n" +
+			"Content-Type: multipart/alternative;\r\n" +
+			" boundary=_BOUNDARY_1_\r\n" +
+			"\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			"Content-Type: text/plain; charset=UTF-8\r\n" +
+			"Content-Transfer-Encoding: quoted-printable\r\n" +
+			"\r\n" +
+			"Hello\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			"Content-Type: text/x-amp-html; charset=UTF-8\r\n" +
+			"Content-Transfer-Encoding: 8bit\r\n" +
+			"\r\n" +
+			testAMPBody + "\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			"Content-Type: text/html; charset=UTF-8\r\n" +
+			"Content-Transfer-Encoding: quoted-printable\r\n" +
+			"\r\n" +
+			"<b>Hello</b>\r\n" +
+			"--_BOUNDARY_1_--\r\n",
+	}
+
+	testMessage(t, m, 1, want)
+}
+
+func TestAMPValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		ok   bool
+	}{
+		{"valid", testAMPBody, true},
+		{"amp4email", strings.Replace(testAMPBody, "⚡4email", "AMP4EMAIL", 1), true},
+		{"no attribute", strings.Replace(testAMPBody, "⚡4email", "", 1), false},
+		{"other attribute", strings.Replace(testAMPBody, "⚡4email", "⚡", 1), false},
+		{"no charset", strings.Replace(testAMPBody, `<meta charset="utf-8">`, "", 1), false},
+		{"no runtime", strings.Replace(testAMPBody, "v0.js", "v1.js", 1), false},
+		{"sync runtime", strings.Replace(testAMPBody, "script async", "script", 1), false},
+		{"no boilerplate", strings.Replace(testAMPBody, " amp4email-boilerplate", "", 1), false},
+		{"too large", strings.Replace(testAMPBody, "Hello", strings.Repeat("a", maxAMPSize), 1), false},
+	}
+
+	for _, test := range tests {
+		m := NewMessage()
+		m.SetHeader("From", "from@example.com")
+		m.SetHeader("To", "to@example.com")
+		m.SetBody("text/plain", "Hello")
+		m.AddAMP(test.body)
+		m.AddAlternative("text/html", "<b>Hello</b>")
+
+		buf := new(bytes.Buffer)
+		_, err := m.WriteTo(buf)
+		if test.ok && err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+		if !test.ok {
+			if !errors.Is(err, &InvalidAMPError{}) {
+				t.Errorf("%s: got error %v, want an InvalidAMPError", test.name, err)
+			}
+			if buf.Len() != 0 {
+				t.Errorf("%s: the message should not be written", test.name)
+			}
+		}
+	}
+}
+
+func TestAMPWithoutHTML(t *testing.T) {
+	m := NewMessage()
+	m.SetHeader("From", "from@example.com")
+	m.SetHeader("To", "to@example.com")
+	m.SetBody("text/plain", "Hello")
+	m.AddAMP(testAMPBody)
+
+	if _, err := m.Size(); !errors.Is(err, &InvalidAMPError{}) {
+		t.Errorf("got error %v, want an InvalidAMPError", err)
+	}
+}
+
+func TestAMPOrder(t *testing.T) {
+	m := NewMessage()
+	m.SetHeader("From", "from@example.com")
+	m.SetHeader("To", "to@example.com")
+	m.AddAMP(testAMPBody)
+	m.AddAlternative("text/calendar", "BEGIN:VCALENDAR")
+	m.AddAlternative("text/html", "<b>Hello</b>")
+	m.AddAlternative("text/plain", "Hello")
+
+	var got []string
+	for _, p := range m.orderedParts() {
+		got = append(got, p.mediaType())
+	}
+	want := []string{"text/plain", "text/calendar", "text/x-amp-html", "text/html"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("invalid order, got %v, want %v", got, want)
+	}
+}
+
+func TestAMPWriterReadOnce(t *testing.T) {
+	m := NewMessage()
+	m.SetHeader("From", "from@example.com")
+	m.SetHeader("To", "to@example.com")
+	m.SetBody("text/plain", "Hello")
+	m.AddAlternative("text/html", "<b>Hello</b>")
+	r := strings.NewReader(testAMPBody)
+	m.AddAMPWriter(func(w io.Writer) error {
+		_, err := io.Copy(w, r)
+		return err
+	}, SetPartEncoding(Unencoded))
+
+	buf := new(bytes.Buffer)
+	if _, err := m.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "\r\n\r\n"+testAMPBody+"\r\n") {
+		t.Errorf("the AMP part is missing:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,224 @@
+package gomail
+
+import (
+	"crypto"
+	"strconv"
+	"strings"
+)
+
+// maxARCInstance is the maximum number of ARC sets allowed by RFC 8617.
+const maxARCInstance = 50
+
+// ARCOptions configures an ARCSealer.
+type ARCOptions struct {
+	// Domain is the signing domain (the d= tag).
+	Domain string
+	// Selector is the selector of the public key in the DNS (the s= tag).
+	Selector string
+	// Signer is the private key. The same key types than DKIMOptions.Signer
+	// are supported.
+	Signer crypto.Signer
+	// Headers lists the header fields signed by the ARC-Message-Signature.
+	// It defaults to DefaultDKIMHeaders and DKIM-Signature.
+	Headers []string
+}
+
+// An ARCSealer adds ARC sets (RFC 8617) to messages relayed or forwarded by
+// an intermediary, so that the authentication results it computed are
+// available to downstream receivers.
+type ARCSealer struct {
+	o ARCOptions
+}
+
+// NewARCSealer returns a new ARCSealer.
+func NewARCSealer(o ARCOptions) (*ARCSealer, error) {
+	if o.Domain == "" || o.Selector == "" || o.Signer == nil {
+		return nil, ErrInvalidARCOptions
+	}
+	if _, err := dkimAlgorithm(o.Signer); err != nil {
+		return nil, err
+	}
+	if len(o.Headers) == 0 {
+		o.Headers = append(append([]string{}, DefaultDKIMHeaders...), "DKIM-Signature")
+	}
+
+	return &ARCSealer{o: o}, nil
+}
+
+// Seal returns msg, a complete RFC 5322 message, with a new ARC set
+// prepended. authResults is the value of the Authentication-Results header
+// field computed when receiving the message, starting with the authserv-id.
+//
+// The chain validation status of the new ARC-Seal is "none" for the first
+// set. Otherwise it is "pass" when authResults contains an "arc=pass" result
+// and the existing chain is complete, and "fail" in any other case.
+//
+// The sealed message can be sent with any Sender:
+//
+//	sealed, err := sealer.Seal(raw, results)
+//	err = s.Send(ctx, from, to, bytes.NewReader(sealed))
+func (s *ARCSealer) Seal(msg []byte, authResults string) ([]byte, error) {
+	msg = normalizeCRLF(msg)
+	fields, body := splitMessage(msg)
+
+	sets := arcSets(fields)
+	i := len(sets) + 1
+	if i > maxARCInstance {
+		return nil, ErrTooManyARCSets
+	}
+
+	cv := "none"
+	if i > 1 {
+		cv = "fail"
+		if arcChainComplete(sets) && arcResult(authResults) == "pass" {
+			cv = "pass"
+		}
+	}
+
+	algo, err := dkimAlgorithm(s.o.Signer)
+	if err != nil {
+		return nil, err
+	}
+	instance := "i=" + strconv.Itoa(i)
+	t := "t=" + strconv.FormatInt(now().Unix(), 10)
+
+	aar := foldHeaderValue("ARC-Authentication-Results", instance+"; "+strings.TrimSpace(authResults))
+
+	cbody := canonicalBody(body, RelaxedCanonicalization)
+	bh := sha256Base64(cbody)
+	signed, names := selectHeaders(fields, s.o.Headers, nil)
+	ams, err := signHeaderField("ARC-Message-Signature", []string{
+		instance,
+		"a=" + algo,
+		"c=relaxed/relaxed",
+		"d=" + s.o.Domain,
+		"s=" + s.o.Selector,
+		t,
+		"h=" + strings.Join(names, ":"),
+		"bh=" + bh,
+	}, signed, RelaxedCanonicalization, s.o.Signer)
+	if err != nil {
+		return nil, err
+	}
+
+	// The seal covers every ARC set in increasing instance order.
+	var sealed []string
+	for _, set := range sets {
+		sealed = append(sealed, set[:]...)
+	}
+	sealed = append(sealed, aar, ams)
+	seal, err := signHeaderField("ARC-Seal", []string{
+		instance,
+		"a=" + algo,
+		t,
+		"cv=" + cv,
+		"d=" + s.o.Domain,
+		"s=" + s.o.Selector,
+	}, sealed, RelaxedCanonicalization, s.o.Signer)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(seal)+len(ams)+len(aar)+len(msg))
+	out = append(out, seal...)
+	out = append(out, ams...)
+	out = append(out, aar...)
+	return append(out, msg...), nil
+}
+
+// arcSet holds the ARC-Authentication-Results, ARC-Message-Signature and
+// ARC-Seal header fields of an instance, in that order.
+type arcSet [3]string
+
+// arcSets returns the existing ARC sets of a message ordered by instance.
+func arcSets(fields []string) []arcSet {
+	var sets []arcSet
+	for _, f := range fields {
+		var j int
+		switch strings.ToLower(fieldName(f)) {
+		case "arc-authentication-results":
+			j = 0
+		case "arc-message-signature":
+			j = 1
+		case "arc-seal":
+			j = 2
+		default:
+			continue
+		}
+
+		i := arcInstance(f)
+		if i < 1 || i > maxARCInstance {
+			continue
+		}
+		for len(sets) < i {
+			sets = append(sets, arcSet{})
+		}
+		sets[i-1][j] = f
+	}
+
+	return sets
+}
+
+func arcChainComplete(sets []arcSet) bool {
+	for _, set := range sets {
+		for _, f := range set {
+			if f == "" {
+				return false
+			}
+		}
+	}
+
+	last := sets[len(sets)-1][2]
+	return !strings.Contains(strings.ToLower(strings.ReplaceAll(last, " ", "")), "cv=fail")
+}
+
+// arcInstance returns the value of the i= tag of an ARC header field.
+func arcInstance(field string) int {
+	value := field[strings.IndexByte(field, ':')+1:]
+	for _, tag := range strings.Split(value, ";") {
+		tag = strings.TrimSpace(tag)
+		if strings.HasPrefix(tag, "i=") {
+			i, err := strconv.Atoi(strings.TrimSpace(tag[2:]))
+			if err != nil {
+				return 0
+			}
+			return i
+		}
+	}
+	return 0
+}
+
+// arcResult returns the result of the arc method in an Authentication-Results
+// header field value.
+func arcResult(authResults string) string {
+	for _, res := range strings.Split(authResults, ";") {
+		res = strings.TrimSpace(res)
+		if strings.HasPrefix(strings.ToLower(res), "arc=") {
+			if f := strings.Fields(res[4:]); len(f) > 0 {
+				return strings.ToLower(f[0])
+			}
+		}
+	}
+	return ""
+}
+
+// foldHeaderValue formats a header field, folding its value at spaces before
+// a line exceeds 76 characters.
+func foldHeaderValue(name, value string) string {
+	var sb strings.Builder
+	sb.WriteString(name)
+	sb.WriteString(":")
+	lineLen := len(name) + 1
+	for i, word := range strings.Fields(value) {
+		if i > 0 && lineLen+1+len(word) > 76 {
+			sb.WriteString("\r\n")
+			lineLen = 0
+		}
+		sb.WriteString(" ")
+		sb.WriteString(word)
+		lineLen += 1 + len(word)
+	}
+	sb.WriteString("\r\n")
+
+	return sb.String()
+}
@@ -0,0 +1,120 @@
+package gomail
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"strings"
+	"testing"
+)
+
+const testARCMessage = "From: from@example.com\r\n" +
+	"To: to@example.com\r\n" +
+	"Subject: Hello!\r\n" +
+	"\r\n" +
+	"Test message\r\n"
+
+func TestARCSeal(t *testing.T) {
+	first, err := NewARCSealer(ARCOptions{
+		Domain:   "example.com",
+		Selector: "arc",
+		Signer:   testEd25519Key,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewARCSealer(ARCOptions{
+		Domain:   "example.org",
+		Selector: "arc",
+		Signer:   testRSAKey,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := first.Seal([]byte(testARCMessage), "mx.example.com; spf=pass smtp.mailfrom=example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err = second.Seal(msg, "mx.example.org; arc=pass; spf=fail smtp.mailfrom=example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fields, _ := splitMessage(msg)
+	sets := arcSets(fields)
+	if len(sets) != 2 {
+		t.Fatalf("invalid ARC set count, got %d, want 2", len(sets))
+	}
+	if want := "ARC-Authentication-Results: i=2; mx.example.org; arc=pass; spf=fail\r\n smtp.mailfrom=example.com\r\n"; sets[1][0] != want {
+		t.Errorf("invalid ARC-Authentication-Results, got %q, want %q", sets[1][0], want)
+	}
+	if cv := parseTags(sets[0][2])["cv"]; cv != "none" {
+		t.Errorf("invalid cv of the first seal, got %q, want none", cv)
+	}
+	if cv := parseTags(sets[1][2])["cv"]; cv != "pass" {
+		t.Errorf("invalid cv of the second seal, got %q, want pass", cv)
+	}
+
+	// Message signatures are listed from the newest to the oldest.
+	if err := checkSignature(msg, "ARC-Message-Signature", 0, testRSAKey.Public()); err != "" {
+		t.Errorf("invalid second ARC-Message-Signature: %s", err)
+	}
+	if err := checkSignature(msg, "ARC-Message-Signature", 1, testEd25519Key.Public()); err != "" {
+		t.Errorf("invalid first ARC-Message-Signature: %s", err)
+	}
+	if err := checkARCSeal(sets, 1, testEd25519Key.Public()); err != "" {
+		t.Errorf("invalid first ARC-Seal: %s", err)
+	}
+	if err := checkARCSeal(sets, 2, testRSAKey.Public()); err != "" {
+		t.Errorf("invalid second ARC-Seal: %s", err)
+	}
+}
+
+func TestARCSealFailedChain(t *testing.T) {
+	s, err := NewARCSealer(ARCOptions{
+		Domain:   "example.com",
+		Selector: "arc",
+		Signer:   testEd25519Key,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The chain is incomplete as the ARC-Seal of the first set is missing.
+	msg := "ARC-Authentication-Results: i=1; mx.example.net; spf=pass\r\n" +
+		"ARC-Message-Signature: i=1; a=rsa-sha256; b=abc\r\n" +
+		testARCMessage
+	sealed, err := s.Seal([]byte(msg), "mx.example.com; arc=pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fields, _ := splitMessage(sealed)
+	sets := arcSets(fields)
+	if len(sets) != 2 {
+		t.Fatalf("invalid ARC set count, got %d, want 2", len(sets))
+	}
+	if cv := parseTags(sets[1][2])["cv"]; cv != "fail" {
+		t.Errorf("invalid cv, got %q, want fail", cv)
+	}
+}
+
+// checkARCSeal verifies the ARC-Seal of the given instance and returns a
+// description of the failure, if any.
+func checkARCSeal(sets []arcSet, instance int, key crypto.PublicKey) string {
+	h := sha256.New()
+	for _, set := range sets[:instance-1] {
+		for _, f := range set {
+			h.Write([]byte(canonicalHeader(f, RelaxedCanonicalization)))
+		}
+	}
+	set := sets[instance-1]
+	h.Write([]byte(canonicalHeader(set[0], RelaxedCanonicalization)))
+	h.Write([]byte(canonicalHeader(set[1], RelaxedCanonicalization)))
+
+	seal := set[2]
+	loc := bTag.FindAllStringIndex(seal, -1)
+	h.Write([]byte(strings.TrimSuffix(canonicalHeader(seal[:loc[len(loc)-1][1]], RelaxedCanonicalization), "\r\n")))
+
+	return checkSignatureValue(h.Sum(nil), parseTags(seal)["b"], key)
+}
@@ -0,0 +1,479 @@
+package gomail
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"math/big"
+	"sort"
+	"time"
+)
+
+// Object identifiers used by the Cryptographic Message Syntax (RFC 5652).
+var (
+	oidData              = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidAttrContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidAttrMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidAttrSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidSHA256            = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidRSAEncryption     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidECDSAWithSHA256   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+)
+
+type cmsContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue
+}
+
+type cmsEncapContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+}
+
+type cmsSignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo cmsEncapContentInfo
+	Certificates     asn1.RawValue
+	SignerInfos      []cmsSignerInfo `asn1:"set"`
+}
+
+type cmsIssuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type cmsSignerInfo struct {
+	Version            int
+	SID                cmsIssuerAndSerialNumber
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+}
+
+type cmsAttribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+// cmsSignatureAlgorithm returns the signature algorithm identifier matching
+// the key of signer.
+func cmsSignatureAlgorithm(signer crypto.Signer) (pkix.AlgorithmIdentifier, error) {
+	switch signer.Public().(type) {
+	case *rsa.PublicKey:
+		return pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue}, nil
+	case *ecdsa.PublicKey:
+		return pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA256}, nil
+	default:
+		return pkix.AlgorithmIdentifier{}, ErrUnsupportedSMIMEKey
+	}
+}
+
+// cmsSignDetached returns a DER encoded ContentInfo holding a SignedData
+// structure with a detached signature of content.
+func cmsSignDetached(content []byte, signer crypto.Signer, certs []*x509.Certificate, signingTime time.Time) ([]byte, error) {
+	sigAlg, err := cmsSignatureAlgorithm(signer)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := sha256.Sum256(content)
+	attrs, err := cmsMarshalAttributes(
+		cmsAttr{oidAttrContentType, oidData},
+		cmsAttr{oidAttrSigningTime, signingTime.UTC()},
+		cmsAttr{oidAttrMessageDigest, digest[:]},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// The signature is computed over the DER encoding of the attributes as a
+	// SET OF, not over their [0] IMPLICIT encoding in the SignerInfo.
+	set, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: attrs})
+	if err != nil {
+		return nil, err
+	}
+	attrsDigest := sha256.Sum256(set)
+	sig, err := signer.Sign(rand.Reader, attrsDigest[:], crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	var rawCerts []byte
+	for _, c := range certs {
+		rawCerts = append(rawCerts, c.Raw...)
+	}
+
+	sha256Alg := pkix.AlgorithmIdentifier{Algorithm: oidSHA256}
+	sd := cmsSignedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{sha256Alg},
+		EncapContentInfo: cmsEncapContentInfo{EContentType: oidData},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: rawCerts},
+		SignerInfos: []cmsSignerInfo{{
+			Version: 1,
+			SID: cmsIssuerAndSerialNumber{
+				Issuer:       asn1.RawValue{FullBytes: certs[0].RawIssuer},
+				SerialNumber: certs[0].SerialNumber,
+			},
+			DigestAlgorithm:    sha256Alg,
+			SignedAttrs:        asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: attrs},
+			SignatureAlgorithm: sigAlg,
+			Signature:          sig,
+		}},
+	}
+
+	return cmsMarshalContentInfo(oidSignedData, sd)
+}
+
+func cmsMarshalContentInfo(contentType asn1.ObjectIdentifier, content interface{}) ([]byte, error) {
+	b, err := asn1.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(cmsContentInfo{
+		ContentType: contentType,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: b},
+	})
+}
+
+type cmsAttr struct {
+	oid   asn1.ObjectIdentifier
+	value interface{}
+}
+
+// cmsMarshalAttributes returns the concatenated DER encoding of attributes,
+// sorted as required for a DER SET OF.
+func cmsMarshalAttributes(attrs ...cmsAttr) ([]byte, error) {
+	encoded := make([][]byte, 0, len(attrs))
+	for _, a := range attrs {
+		v, err := asn1.Marshal(a.value)
+		if err != nil {
+			return nil, err
+		}
+		b, err := asn1.Marshal(cmsAttribute{Type: a.oid, Values: []asn1.RawValue{{FullBytes: v}}})
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, b)
+	}
+
+	sort.Slice(encoded, func(i, j int) bool {
+		return bytes.Compare(encoded[i], encoded[j]) < 0
+	})
+
+	return bytes.Join(encoded, nil), nil
+}
+
+// Object identifiers used to encrypt CMS content (RFC 5652, RFC 5083, RFC
+// 5753 and RFC 8017).
+var (
+	oidEnvelopedData     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+	oidAuthEnvelopedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 23}
+	oidAES256CBC         = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidAES256GCM         = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 46}
+	oidAES256Wrap        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 45}
+	oidRSAESOAEP         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 7}
+	oidMGF1              = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 8}
+	oidECPublicKey       = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidECDHSHA256KDF     = asn1.ObjectIdentifier{1, 3, 132, 1, 11, 1}
+)
+
+// contentEncryptionKeyN is the size of the AES-256 content encryption key.
+const contentEncryptionKeyN = 32
+
+type cmsEnvelopedData struct {
+	Version              int
+	RecipientInfos       []asn1.RawValue `asn1:"set"`
+	EncryptedContentInfo cmsEncryptedContentInfo
+}
+
+type cmsAuthEnvelopedData struct {
+	Version                  int
+	RecipientInfos           []asn1.RawValue `asn1:"set"`
+	AuthEncryptedContentInfo cmsEncryptedContentInfo
+	MAC                      []byte
+}
+
+type cmsEncryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           []byte `asn1:"tag:0"`
+}
+
+type cmsGCMParameters struct {
+	Nonce  []byte
+	ICVLen int
+}
+
+type cmsKeyTransRecipientInfo struct {
+	Version                int
+	RID                    cmsIssuerAndSerialNumber
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedKey           []byte
+}
+
+type cmsRSAESOAEPParams struct {
+	HashAlgorithm    pkix.AlgorithmIdentifier `asn1:"explicit,tag:0"`
+	MaskGenAlgorithm pkix.AlgorithmIdentifier `asn1:"explicit,tag:1"`
+}
+
+type cmsKeyAgreeRecipientInfo struct {
+	Version                int
+	Originator             asn1.RawValue
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	RecipientEncryptedKeys []cmsRecipientEncryptedKey
+}
+
+type cmsOriginatorPublicKey struct {
+	Algorithm pkix.AlgorithmIdentifier
+	PublicKey asn1.BitString
+}
+
+type cmsRecipientEncryptedKey struct {
+	RID          cmsIssuerAndSerialNumber
+	EncryptedKey []byte
+}
+
+type cmsECCSharedInfo struct {
+	KeyInfo     pkix.AlgorithmIdentifier
+	SuppPubInfo []byte `asn1:"explicit,tag:2"`
+}
+
+// cmsEncrypt returns a DER encoded ContentInfo holding content encrypted for
+// the given certificates. AES-256-GCM produces an AuthEnvelopedData structure
+// (RFC 5083), AES-256-CBC an EnvelopedData structure.
+func cmsEncrypt(content []byte, certs []*x509.Certificate, gcm bool) ([]byte, error) {
+	key := make([]byte, contentEncryptionKeyN)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	var recipients []asn1.RawValue
+	version := 0
+	for _, c := range certs {
+		ri, err := cmsRecipientInfo(c, key)
+		if err != nil {
+			return nil, err
+		}
+		if ri.Tag != asn1.TagSequence {
+			version = 2
+		}
+		recipients = append(recipients, ri)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if gcm {
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, err
+		}
+		params, err := asn1.Marshal(cmsGCMParameters{Nonce: nonce, ICVLen: aead.Overhead()})
+		if err != nil {
+			return nil, err
+		}
+
+		sealed := aead.Seal(nil, nonce, content, nil)
+		n := len(sealed) - aead.Overhead()
+		return cmsMarshalContentInfo(oidAuthEnvelopedData, cmsAuthEnvelopedData{
+			RecipientInfos: recipients,
+			AuthEncryptedContentInfo: cmsEncryptedContentInfo{
+				ContentType:                oidData,
+				ContentEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidAES256GCM, Parameters: asn1.RawValue{FullBytes: params}},
+				EncryptedContent:           sealed[:n],
+			},
+			MAC: sealed[n:],
+		})
+	}
+
+	iv := make([]byte, block.BlockSize())
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+	params, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+
+	pad := block.BlockSize() - len(content)%block.BlockSize()
+	ciphertext := make([]byte, len(content)+pad)
+	copy(ciphertext, content)
+	for i := len(content); i < len(ciphertext); i++ {
+		ciphertext[i] = byte(pad)
+	}
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, ciphertext)
+
+	return cmsMarshalContentInfo(oidEnvelopedData, cmsEnvelopedData{
+		Version:        version,
+		RecipientInfos: recipients,
+		EncryptedContentInfo: cmsEncryptedContentInfo{
+			ContentType:                oidData,
+			ContentEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: params}},
+			EncryptedContent:           ciphertext,
+		},
+	})
+}
+
+// cmsRecipientInfo returns the RecipientInfo encrypting key for the owner of
+// cert: a KeyTransRecipientInfo using RSAES-OAEP for RSA keys, or a
+// KeyAgreeRecipientInfo using ephemeral-static ECDH for EC keys.
+func cmsRecipientInfo(cert *x509.Certificate, key []byte) (asn1.RawValue, error) {
+	rid := cmsIssuerAndSerialNumber{
+		Issuer:       asn1.RawValue{FullBytes: cert.RawIssuer},
+		SerialNumber: cert.SerialNumber,
+	}
+	sha256Alg := pkix.AlgorithmIdentifier{Algorithm: oidSHA256}
+
+	var b []byte
+	var err error
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		var encrypted, params []byte
+		encrypted, err = rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, key, nil)
+		if err != nil {
+			return asn1.RawValue{}, err
+		}
+		mgf, err := asn1.Marshal(sha256Alg)
+		if err != nil {
+			return asn1.RawValue{}, err
+		}
+		params, err = asn1.Marshal(cmsRSAESOAEPParams{
+			HashAlgorithm:    sha256Alg,
+			MaskGenAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidMGF1, Parameters: asn1.RawValue{FullBytes: mgf}},
+		})
+		if err != nil {
+			return asn1.RawValue{}, err
+		}
+		b, err = asn1.Marshal(cmsKeyTransRecipientInfo{
+			RID:                    rid,
+			KeyEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidRSAESOAEP, Parameters: asn1.RawValue{FullBytes: params}},
+			EncryptedKey:           encrypted,
+		})
+	case *ecdsa.PublicKey:
+		var kari cmsKeyAgreeRecipientInfo
+		kari, err = cmsKeyAgree(pub, key)
+		if err != nil {
+			return asn1.RawValue{}, err
+		}
+		kari.RecipientEncryptedKeys[0].RID = rid
+		// The KeyAgreeRecipientInfo alternative is tagged [1] IMPLICIT.
+		b, err = asn1.MarshalWithParams(kari, "tag:1")
+	default:
+		return asn1.RawValue{}, ErrUnsupportedSMIMEKey
+	}
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+
+	var ri asn1.RawValue
+	_, err = asn1.Unmarshal(b, &ri)
+	return ri, err
+}
+
+// cmsKeyAgree wraps key with a key derived from an ephemeral ECDH exchange
+// with pub, as described in RFC 5753 using the X9.63 KDF with SHA-256.
+func cmsKeyAgree(pub *ecdsa.PublicKey, key []byte) (cmsKeyAgreeRecipientInfo, error) {
+	remote, err := pub.ECDH()
+	if err != nil {
+		return cmsKeyAgreeRecipientInfo{}, err
+	}
+	priv, err := remote.Curve().GenerateKey(rand.Reader)
+	if err != nil {
+		return cmsKeyAgreeRecipientInfo{}, err
+	}
+	z, err := priv.ECDH(remote)
+	if err != nil {
+		return cmsKeyAgreeRecipientInfo{}, err
+	}
+
+	wrapAlg := pkix.AlgorithmIdentifier{Algorithm: oidAES256Wrap}
+	sharedInfo, err := asn1.Marshal(cmsECCSharedInfo{
+		KeyInfo:     wrapAlg,
+		SuppPubInfo: binary.BigEndian.AppendUint32(nil, contentEncryptionKeyN*8),
+	})
+	if err != nil {
+		return cmsKeyAgreeRecipientInfo{}, err
+	}
+	h := sha256.New()
+	h.Write(z)
+	h.Write([]byte{0, 0, 0, 1})
+	h.Write(sharedInfo)
+	kek := h.Sum(nil)
+
+	wrapped, err := aesKeyWrap(kek, key)
+	if err != nil {
+		return cmsKeyAgreeRecipientInfo{}, err
+	}
+	params, err := asn1.Marshal(wrapAlg)
+	if err != nil {
+		return cmsKeyAgreeRecipientInfo{}, err
+	}
+	point := priv.PublicKey().Bytes()
+
+	// The originator is the [1] IMPLICIT originatorKey alternative of the
+	// [0] EXPLICIT originator field.
+	originator, err := asn1.MarshalWithParams(cmsOriginatorPublicKey{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidECPublicKey},
+		PublicKey: asn1.BitString{Bytes: point, BitLength: len(point) * 8},
+	}, "tag:1")
+	if err != nil {
+		return cmsKeyAgreeRecipientInfo{}, err
+	}
+
+	return cmsKeyAgreeRecipientInfo{
+		Version:                3,
+		Originator:             asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: originator},
+		KeyEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidECDHSHA256KDF, Parameters: asn1.RawValue{FullBytes: params}},
+		RecipientEncryptedKeys: []cmsRecipientEncryptedKey{{EncryptedKey: wrapped}},
+	}, nil
+}
+
+// aesKeyWrap implements the AES key wrap algorithm of RFC 3394.
+func aesKeyWrap(kek, key []byte) ([]byte, error) {
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(key) / 8
+	out := make([]byte, 8+len(key))
+	copy(out, []byte{0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6})
+	copy(out[8:], key)
+
+	b := make([]byte, 16)
+	for j := 0; j < 6; j++ {
+		for i := 1; i <= n; i++ {
+			copy(b, out[:8])
+			copy(b[8:], out[i*8:i*8+8])
+			block.Encrypt(b, b)
+			t := uint64(n*j + i)
+			for k := 7; k >= 0; k-- {
+				b[k] ^= byte(t)
+				t >>= 8
+			}
+			copy(out[:8], b[:8])
+			copy(out[i*8:], b[8:])
+		}
+	}
+
+	return out, nil
+}
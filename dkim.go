@@ -0,0 +1,447 @@
+package gomail
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Canonicalization represents a DKIM canonicalization algorithm as defined in
+// RFC 6376, section 3.4.
+type Canonicalization string
+
+const (
+	// SimpleCanonicalization tolerates almost no modification of the message.
+	SimpleCanonicalization Canonicalization = "simple"
+	// RelaxedCanonicalization tolerates common modifications such as
+	// whitespace replacement and header field line rewrapping.
+	RelaxedCanonicalization Canonicalization = "relaxed"
+)
+
+// DefaultDKIMHeaders are the header fields signed when DKIMOptions.Headers is
+// empty. Fields absent from a message are skipped.
+var DefaultDKIMHeaders = []string{
+	"From", "Reply-To", "Subject", "Date", "To", "Cc", "Message-ID",
+	"In-Reply-To", "References", "MIME-Version", "Content-Type",
+	"Content-Transfer-Encoding", "List-Unsubscribe", "List-Unsubscribe-Post",
+}
+
+// DKIMOptions configures a DKIM signature.
+type DKIMOptions struct {
+	// Domain is the signing domain (the d= tag).
+	Domain string
+	// Selector is the selector of the public key in the DNS (the s= tag).
+	Selector string
+	// Signer is the private key. Both *rsa.PrivateKey (rsa-sha256) and
+	// ed25519.PrivateKey (ed25519-sha256, RFC 8463) are supported, as well as
+	// any crypto.Signer whose public key has one of those types.
+	Signer crypto.Signer
+	// Identifier is the optional agent or user identifier (the i= tag).
+	Identifier string
+	// HeaderCanonicalization and BodyCanonicalization default to
+	// RelaxedCanonicalization.
+	HeaderCanonicalization Canonicalization
+	BodyCanonicalization   Canonicalization
+	// Headers lists the header fields to sign. It defaults to
+	// DefaultDKIMHeaders. From is always signed.
+	Headers []string
+	// OversignHeaders lists header fields that are signed one more time than
+	// they appear in the message, so that adding another instance of them
+	// breaks the signature.
+	OversignHeaders []string
+	// BodyLength limits the number of bytes of the canonicalized body that
+	// are signed (the l= tag). The whole body is signed when it is 0.
+	BodyLength int64
+	// Expiration sets the signature expiration (the x= tag) relative to the
+	// signing time. Signatures do not expire when it is 0.
+	Expiration time.Duration
+}
+
+// A DKIMSigner adds DKIM-Signature header fields (RFC 6376) to messages. It
+// can be used with the SetDKIM message setting or the WithDKIM send
+// middleware.
+type DKIMSigner struct {
+	options []*DKIMOptions
+}
+
+// NewDKIMSigner returns a DKIMSigner adding one signature per given options,
+// which allows signing a message for several domains or selectors.
+func NewDKIMSigner(options ...DKIMOptions) (*DKIMSigner, error) {
+	if len(options) == 0 {
+		return nil, ErrDKIMOptionsAbsent
+	}
+
+	s := &DKIMSigner{}
+	for i := range options {
+		o := options[i]
+		if o.Domain == "" || o.Selector == "" || o.Signer == nil {
+			return nil, ErrInvalidDKIMOptions
+		}
+		if _, err := dkimAlgorithm(o.Signer); err != nil {
+			return nil, err
+		}
+		if o.HeaderCanonicalization == "" {
+			o.HeaderCanonicalization = RelaxedCanonicalization
+		}
+		if o.BodyCanonicalization == "" {
+			o.BodyCanonicalization = RelaxedCanonicalization
+		}
+		if !o.HeaderCanonicalization.valid() || !o.BodyCanonicalization.valid() {
+			return nil, ErrInvalidDKIMOptions
+		}
+		if len(o.Headers) == 0 {
+			o.Headers = DefaultDKIMHeaders
+		}
+		if !containsFold(o.Headers, "From") {
+			o.Headers = append([]string{"From"}, o.Headers...)
+		}
+		s.options = append(s.options, &o)
+	}
+
+	return s, nil
+}
+
+func (c Canonicalization) valid() bool {
+	return c == SimpleCanonicalization || c == RelaxedCanonicalization
+}
+
+func dkimAlgorithm(s crypto.Signer) (string, error) {
+	switch s.Public().(type) {
+	case *rsa.PublicKey:
+		return "rsa-sha256", nil
+	case ed25519.PublicKey:
+		return "ed25519-sha256", nil
+	default:
+		return "", ErrUnsupportedDKIMKey
+	}
+}
+
+// SetDKIM is a message setting to sign the message with s when it is written.
+func SetDKIM(s *DKIMSigner) MessageSetting {
+	return func(m *Message) {
+		m.dkim = s
+	}
+}
+
+// WithDKIM returns a send middleware signing every message with s before it
+// is passed to the next send function.
+func WithDKIM(s *DKIMSigner) SendMiddleware {
+	return func(ctx context.Context, from string, to []string, msg io.WriterTo, next SendFunction) error {
+		var buf bytes.Buffer
+		if _, err := msg.WriteTo(&buf); err != nil {
+			return err
+		}
+
+		signed, err := s.Sign(buf.Bytes())
+		if err != nil {
+			return err
+		}
+
+		return next(ctx, from, to, bytes.NewReader(signed))
+	}
+}
+
+// Sign returns msg, a complete RFC 5322 message, with DKIM-Signature header
+// fields prepended. Line endings are normalized to CRLF.
+func (s *DKIMSigner) Sign(msg []byte) ([]byte, error) {
+	msg = normalizeCRLF(msg)
+	fields, body := splitMessage(msg)
+
+	var sigs bytes.Buffer
+	for _, o := range s.options {
+		sig, err := o.sign(fields, body)
+		if err != nil {
+			return nil, err
+		}
+		sigs.WriteString(sig)
+	}
+
+	return append(sigs.Bytes(), msg...), nil
+}
+
+func (o *DKIMOptions) sign(fields []string, body []byte) (string, error) {
+	algo, err := dkimAlgorithm(o.Signer)
+	if err != nil {
+		return "", err
+	}
+
+	cbody := canonicalBody(body, o.BodyCanonicalization)
+	if o.BodyLength > 0 && o.BodyLength < int64(len(cbody)) {
+		cbody = cbody[:o.BodyLength]
+	}
+
+	signed, names := selectHeaders(fields, o.Headers, o.OversignHeaders)
+
+	t := now()
+	tags := []string{
+		"v=1",
+		"a=" + algo,
+		"c=" + string(o.HeaderCanonicalization) + "/" + string(o.BodyCanonicalization),
+		"d=" + o.Domain,
+		"s=" + o.Selector,
+	}
+	if o.Identifier != "" {
+		tags = append(tags, "i="+o.Identifier)
+	}
+	if o.BodyLength > 0 {
+		tags = append(tags, "l="+strconv.Itoa(len(cbody)))
+	}
+	tags = append(tags, "t="+strconv.FormatInt(t.Unix(), 10))
+	if o.Expiration > 0 {
+		tags = append(tags, "x="+strconv.FormatInt(t.Add(o.Expiration).Unix(), 10))
+	}
+	tags = append(tags,
+		"h="+strings.Join(names, ":"),
+		"bh="+sha256Base64(cbody),
+	)
+
+	return signHeaderField("DKIM-Signature", tags, signed, o.HeaderCanonicalization, o.Signer)
+}
+
+func sha256Base64(b []byte) string {
+	sum := sha256.Sum256(b)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// signHeaderField builds a signature header field from tags, and signs it
+// along with the signed header fields. The b= tag is appended last.
+func signHeaderField(name string, tags, signed []string, c Canonicalization, signer crypto.Signer) (string, error) {
+	field := foldTags(name, append(tags, "b="))
+
+	h := sha256.New()
+	for _, f := range signed {
+		io.WriteString(h, canonicalHeader(f, c))
+	}
+	io.WriteString(h, strings.TrimSuffix(canonicalHeader(field, c), "\r\n"))
+
+	var opts crypto.SignerOpts = crypto.SHA256
+	if _, ok := signer.Public().(ed25519.PublicKey); ok {
+		opts = crypto.Hash(0)
+	}
+	sig, err := signer.Sign(rand.Reader, h.Sum(nil), opts)
+	if err != nil {
+		return "", err
+	}
+
+	b := base64.StdEncoding.EncodeToString(sig)
+	field = strings.TrimSuffix(field, "\r\n")
+	for len(b) > 0 {
+		n := 72
+		if n > len(b) {
+			n = len(b)
+		}
+		field += "\r\n " + b[:n]
+		b = b[n:]
+	}
+
+	return field + "\r\n", nil
+}
+
+// foldTags formats a tag list header field, folding it before a line exceeds
+// 76 characters.
+func foldTags(name string, tags []string) string {
+	var sb strings.Builder
+	sb.WriteString(name)
+	sb.WriteString(":")
+	lineLen := len(name) + 1
+	for i, tag := range tags {
+		if i != len(tags)-1 {
+			tag += ";"
+		}
+		if lineLen+1+len(tag) > 76 && lineLen > len(name)+1 {
+			sb.WriteString("\r\n")
+			lineLen = 0
+		}
+		sb.WriteString(" ")
+		lineLen++
+
+		// Long values such as the h= tag are folded at colons.
+		for lineLen+len(tag) > 76 && lineLen < 76 {
+			i := strings.LastIndexByte(tag[:76-lineLen], ':')
+			if i <= 0 {
+				break
+			}
+			sb.WriteString(tag[:i])
+			sb.WriteString("\r\n ")
+			tag = tag[i:]
+			lineLen = 1
+		}
+		sb.WriteString(tag)
+		lineLen += len(tag)
+	}
+	sb.WriteString("\r\n")
+
+	return sb.String()
+}
+
+// selectHeaders returns the header fields to sign, in signing order, and the
+// names to list in the h= tag. Multiple instances of a field are signed from
+// the bottom up. Oversigned names are listed one extra time.
+func selectHeaders(fields []string, names, oversign []string) ([]string, []string) {
+	used := make(map[int]bool)
+	var signed, h []string
+
+	add := func(name string) bool {
+		for i := len(fields) - 1; i >= 0; i-- {
+			if !used[i] && strings.EqualFold(fieldName(fields[i]), name) {
+				used[i] = true
+				signed = append(signed, fields[i])
+				h = append(h, strings.ToLower(name))
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, name := range names {
+		for add(name) {
+		}
+	}
+	for _, name := range oversign {
+		for add(name) {
+		}
+		// A missing instance is signed as the null string.
+		h = append(h, strings.ToLower(name))
+	}
+
+	return signed, h
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitMessage splits msg into its raw header fields, including their
+// trailing CRLF, and its body.
+func splitMessage(msg []byte) ([]string, []byte) {
+	var header, body []byte
+	if bytes.HasPrefix(msg, []byte("\r\n")) {
+		body = msg[2:]
+	} else if i := bytes.Index(msg, []byte("\r\n\r\n")); i != -1 {
+		header, body = msg[:i+2], msg[i+4:]
+	} else {
+		header = msg
+	}
+
+	var fields []string
+	for _, line := range strings.SplitAfter(string(header), "\r\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1] += line
+		} else {
+			fields = append(fields, line)
+		}
+	}
+
+	return fields, body
+}
+
+func fieldName(field string) string {
+	if i := strings.IndexByte(field, ':'); i != -1 {
+		return strings.TrimRight(field[:i], " \t")
+	}
+	return strings.TrimSpace(field)
+}
+
+func canonicalHeader(field string, c Canonicalization) string {
+	if c == SimpleCanonicalization {
+		if !strings.HasSuffix(field, "\r\n") {
+			field += "\r\n"
+		}
+		return field
+	}
+
+	name, value := fieldName(field), ""
+	if i := strings.IndexByte(field, ':'); i != -1 {
+		value = field[i+1:]
+	}
+	value = strings.ReplaceAll(value, "\r\n", "")
+	value = strings.Trim(collapseWSP(value), " ")
+
+	return strings.ToLower(name) + ":" + value + "\r\n"
+}
+
+func collapseWSP(s string) string {
+	var sb strings.Builder
+	inWSP := false
+	for i := 0; i < len(s); i++ {
+		if s[i] == ' ' || s[i] == '\t' {
+			inWSP = true
+			continue
+		}
+		if inWSP {
+			sb.WriteByte(' ')
+			inWSP = false
+		}
+		sb.WriteByte(s[i])
+	}
+	if inWSP {
+		sb.WriteByte(' ')
+	}
+	return sb.String()
+}
+
+func canonicalBody(body []byte, c Canonicalization) []byte {
+	if c == RelaxedCanonicalization {
+		lines := strings.SplitAfter(string(body), "\r\n")
+		var sb strings.Builder
+		for _, line := range lines {
+			hasCRLF := strings.HasSuffix(line, "\r\n")
+			line = strings.TrimRight(collapseWSP(strings.TrimSuffix(line, "\r\n")), " ")
+			sb.WriteString(line)
+			if hasCRLF {
+				sb.WriteString("\r\n")
+			}
+		}
+		body = []byte(sb.String())
+		if len(body) == 0 {
+			return body
+		}
+	}
+
+	if !bytes.HasSuffix(body, []byte("\r\n")) {
+		body = append(body, '\r', '\n')
+	}
+	for bytes.HasSuffix(body, []byte("\r\n\r\n")) {
+		body = body[:len(body)-2]
+	}
+	if c == RelaxedCanonicalization && bytes.Equal(body, []byte("\r\n")) {
+		return nil
+	}
+
+	return body
+}
+
+// normalizeCRLF converts bare LF line endings to CRLF as they would be
+// converted during the SMTP transmission.
+func normalizeCRLF(msg []byte) []byte {
+	n := bytes.Count(msg, []byte("\n")) - bytes.Count(msg, []byte("\r\n"))
+	if n == 0 {
+		return msg
+	}
+
+	out := make([]byte, 0, len(msg)+n)
+	for i, b := range msg {
+		if b == '\n' && (i == 0 || msg[i-1] != '\r') {
+			out = append(out, '\r')
+		}
+		out = append(out, b)
+	}
+	return out
+}
@@ -0,0 +1,99 @@
+package gomail
+
+import (
+	"bytes"
+	"io"
+	"strings"
+)
+
+// encoding7bit is the transfer encoding of the Unencoded parts whose content
+// is 7-bit clean, once downgraded.
+const encoding7bit Encoding = "7bit"
+
+// A downgradedMessage writes a message for an SMTP server not supporting the
+// 8BITMIME extension (RFC 6152): the parts and files with the Unencoded
+// encoding are written with a 7-bit transfer encoding.
+type downgradedMessage struct {
+	m *Message
+}
+
+func (d downgradedMessage) WriteTo(w io.Writer) (int64, error) {
+	return d.m.writeTo(w, true)
+}
+
+func (d downgradedMessage) Size() (int64, error) {
+	return d.m.size(true)
+}
+
+// downgradePart returns the encoding and the copier of an Unencoded part of
+// the given media type, for a 7-bit transport. The part is kept as is and
+// labeled 7bit if its content is 7-bit clean. Otherwise, text parts are
+// encoded in quoted-printable and the other ones in base64.
+func downgradePart(mediaType string, copier func(io.Writer) error) (Encoding, func(io.Writer) error, error) {
+	var buf bytes.Buffer
+	if err := copier(&buf); err != nil {
+		return "", nil, err
+	}
+
+	body := buf.String()
+	enc := encoding7bit
+	switch {
+	case is7bit(body):
+	case strings.HasPrefix(mediaType, "text/"):
+		enc = QuotedPrintable
+	default:
+		enc = Base64
+	}
+	return enc, newCopier(body), nil
+}
+
+// is7bit reports whether s is 7-bit data as defined in RFC 2045, section 2.7:
+// ASCII characters other than NUL in lines of at most 998 bytes.
+func is7bit(s string) bool {
+	lineLen := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case 0:
+			return false
+		case '\n':
+			if i > 0 && s[i-1] == '\r' {
+				lineLen--
+			}
+			if lineLen > 998 {
+				return false
+			}
+			lineLen = 0
+		default:
+			if c >= 0x80 {
+				return false
+			}
+			lineLen++
+		}
+	}
+	return lineLen <= 998
+}
+
+// fileEncoding returns the encoding of the content of f: Unencoded files are
+// sent in base64 to servers not supporting 8BITMIME.
+func (w *messageWriter) fileEncoding(f *file) Encoding {
+	enc := f.contentEncoding()
+	if w.sevenBit && enc == Unencoded {
+		return Base64
+	}
+	return enc
+}
+
+// fileHeader returns the header of f written with the encoding enc, whose
+// Content-Transfer-Encoding is replaced when the file is downgraded.
+func fileHeader(f *file, enc Encoding) map[string][]string {
+	if enc == f.contentEncoding() {
+		return f.Header
+	}
+
+	h := make(map[string][]string, len(f.Header))
+	for k, v := range f.Header {
+		h[k] = v
+	}
+	h["Content-Transfer-Encoding"] = []string{string(enc)}
+	return h
+}
@@ -0,0 +1,175 @@
+package gomail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestIs7bit(t *testing.T) {
+	tests := []struct {
+		s    string
+		want bool
+	}{
+		{"", true},
+		{"Hello\r\nWorld\n", true},
+		{"Café", false},
+		{"nul\x00byte", false},
+		{strings.Repeat("a", 998) + "\r\n", true},
+		{strings.Repeat("a", 998), true},
+		{strings.Repeat("a", 999) + "\r\n", false},
+		{strings.Repeat("a", 999), false},
+	}
+
+	for _, tt := range tests {
+		if got := is7bit(tt.s); got != tt.want {
+			t.Errorf("is7bit(%.20q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
+
+func newUnencodedMessage() *Message {
+	m := NewMessage(SetEncoding(Unencoded))
+	m.SetHeader("From", "from@example.com")
+	m.SetHeader("To", "to@example.com")
+	m.SetBody("text/plain", "Café")
+	m.AddAlternative("text/html", "<p>Hello</p>")
+	m.AttachReader("data.bin", strings.NewReader("\xff\x00"), SetFileEncoding(Unencoded))
+	return m
+}
+
+func TestDowngradedMessage(t *testing.T) {
+	m := newUnencodedMessage()
+
+	var buf bytes.Buffer
+	if _, err := (downgradedMessage{m}).WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	got := buf.String()
+	for _, want := range []string{
+		"Content-Transfer-Encoding: quoted-printable\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\nCaf=C3=A9\r\n",
+		"Content-Transfer-Encoding: 7bit\r\nContent-Type: text/html; charset=UTF-8\r\n\r\n<p>Hello</p>\r\n",
+		"Content-Transfer-Encoding: base64\r\nContent-Type: application/octet-stream; name=\"data.bin\"\r\n\r\n/wA=\r\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("message does not contain %q:\n%s", want, got)
+		}
+	}
+
+	// The message is not downgraded when written as is.
+	buf.Reset()
+	if _, err := newUnencodedMessage().WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	got = buf.String()
+	for _, want := range []string{
+		"Content-Transfer-Encoding: 8bit\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\nCafé\r\n",
+		"Content-Transfer-Encoding: 8bit\r\nContent-Type: application/octet-stream; name=\"data.bin\"\r\n\r\n\xff\x00\r\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("message does not contain %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestDialer8BitMIME(t *testing.T) {
+	tests := []struct {
+		name       string
+		extensions string
+		msg        func() *Message
+		dkim       bool
+		mail       string
+		body       string
+		wantErr    error
+	}{
+		{
+			name:       "Supported",
+			extensions: "250 8BITMIME\n",
+			msg:        newUnencodedMessage,
+			mail:       "MAIL FROM:<from@example.com> BODY=8BITMIME\n",
+			body:       "\nCafé\n",
+		},
+		{
+			name:       "Downgraded",
+			extensions: "250 PIPELINING\n",
+			msg:        newUnencodedMessage,
+			mail:       "MAIL FROM:<from@example.com>\n",
+			body:       "\nCaf=C3=A9\n",
+		},
+		{
+			name:       "7Bit",
+			extensions: "250 8BITMIME\n",
+			msg: func() *Message {
+				m := NewMessage()
+				m.SetHeader("From", "from@example.com")
+				m.SetHeader("To", "to@example.com")
+				m.SetBody("text/plain", "Café")
+				return m
+			},
+			mail: "MAIL FROM:<from@example.com>\n",
+			body: "\nCaf=C3=A9\n",
+		},
+		{
+			name:       "Middleware",
+			extensions: "250 8BITMIME\n",
+			msg:        newUnencodedMessage,
+			dkim:       true,
+			mail:       "MAIL FROM:<from@example.com> BODY=8BITMIME\n",
+			body:       "\nCafé\n",
+		},
+		{
+			name:       "MiddlewareUnsupported",
+			extensions: "250 PIPELINING\n",
+			msg:        newUnencodedMessage,
+			dkim:       true,
+			mail:       "EHLO localhost\nQUIT\n",
+			wantErr:    Err8BitMIMEUnsupported,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := newLineSMTPConn(
+				"220 mx.example.com\n",
+				"250-mx.example.com\n"+tt.extensions,
+				"250 2.1.0 OK\n",
+				"250 2.1.5 OK\n",
+				"354 Go ahead\n",
+				"250 2.0.0 Queued\n",
+				"221 2.0.0 Bye\n",
+			)
+
+			origDial, origClient := dialContext, smtpNewClient
+			defer func() { dialContext, smtpNewClient = origDial, origClient }()
+			dialContext = func(ctx context.Context, d *Dialer) (net.Conn, error) {
+				return conn, nil
+			}
+			smtpNewClient = func(conn net.Conn, host string) (smtpClient, error) {
+				return newSMTPConn(conn, host)
+			}
+
+			d := NewDialer("localhost", testPort, "", "")
+			d.StartTLSPolicy = NoStartTLS
+			if tt.dkim {
+				signer, err := NewDKIMSigner(DKIMOptions{Domain: "example.com", Selector: "s", Signer: testEd25519Key})
+				if err != nil {
+					t.Fatal(err)
+				}
+				d.SendMiddlewares = SendMiddlewares{WithDKIM(signer)}
+			}
+			if err := d.DialAndSend(context.Background(), tt.msg()); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			got := conn.commands()
+			for _, want := range []string{tt.mail, tt.body} {
+				if !strings.Contains(got, want) {
+					t.Errorf("commands do not contain %q:\n%s", want, got)
+				}
+			}
+		})
+	}
+}
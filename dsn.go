@@ -0,0 +1,155 @@
+package gomail
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// A DSNNotify is a condition in which a delivery status notification is sent
+// for a recipient.
+type DSNNotify string
+
+const (
+	// DSNNotifySuccess requests a notification when the message is
+	// delivered.
+	DSNNotifySuccess DSNNotify = "SUCCESS"
+	// DSNNotifyFailure requests a notification when the message cannot be
+	// delivered.
+	DSNNotifyFailure DSNNotify = "FAILURE"
+	// DSNNotifyDelay requests a notification when the delivery is delayed.
+	DSNNotifyDelay DSNNotify = "DELAY"
+	// DSNNotifyNever requests no notification at all. It cannot be combined
+	// with the other conditions.
+	DSNNotifyNever DSNNotify = "NEVER"
+)
+
+// A DSNReturn is the part of the message returned with a delivery status
+// notification.
+type DSNReturn string
+
+const (
+	// DSNReturnFull returns the whole message.
+	DSNReturnFull DSNReturn = "FULL"
+	// DSNReturnHeaders returns the header of the message only.
+	DSNReturnHeaders DSNReturn = "HDRS"
+)
+
+// maxEnvelopeIDLen is the maximum length of the value of the ENVID parameter.
+const maxEnvelopeIDLen = 100
+
+// DSN holds the delivery status notification parameters (RFC 3461) sent with
+// the MAIL and RCPT commands, to control the bounces of a message. The zero
+// value of each field leaves the decision to the server.
+type DSN struct {
+	// Notify is the list of conditions in which a notification is sent for
+	// each recipient, sent with the NOTIFY parameter.
+	Notify []DSNNotify
+	// Return is the part of the message returned in the notifications, sent
+	// with the RET parameter.
+	Return DSNReturn
+	// EnvelopeID is the identifier of the transaction returned in the
+	// notifications, sent with the ENVID parameter. It is at most 100
+	// characters long once encoded as an xtext.
+	EnvelopeID string
+	// OriginalRecipients maps the envelope recipients to the address the
+	// message was originally sent to, sent with the ORCPT parameter. It is
+	// useful when the message is forwarded. The recipients absent from the
+	// map get no ORCPT parameter.
+	OriginalRecipients map[string]string
+	// Required makes the sending fail with ErrDSNUnsupported when the server
+	// does not support the DSN extension. The parameters are dropped
+	// otherwise.
+	Required bool
+}
+
+// SetDSN is a message setting to send the message with the given delivery
+// status notification parameters. They are only used by Dialer, and take
+// precedence over the ones set with WithDSN.
+func SetDSN(dsn DSN) MessageSetting {
+	return func(m *Message) {
+		m.dsn = &dsn
+	}
+}
+
+type dsnKey struct{}
+
+// WithDSN returns a copy of ctx carrying the given delivery status
+// notification parameters. The messages sent with it by Dialer, including raw
+// messages which cannot be given a message setting, use these parameters.
+func WithDSN(ctx context.Context, dsn DSN) context.Context {
+	return context.WithValue(ctx, dsnKey{}, &dsn)
+}
+
+// dsnFor returns the DSN parameters of the messages sent with ctx, if any.
+func dsnFor(ctx context.Context) *DSN {
+	dsn, _ := ctx.Value(dsnKey{}).(*DSN)
+	return dsn
+}
+
+func (d *DSN) validate() error {
+	for _, n := range d.Notify {
+		switch n {
+		case DSNNotifySuccess, DSNNotifyFailure, DSNNotifyDelay:
+		case DSNNotifyNever:
+			if len(d.Notify) > 1 {
+				return &InvalidDSNError{Reason: "NEVER is combined with other conditions"}
+			}
+		default:
+			return &InvalidDSNError{Reason: fmt.Sprintf("unknown notify condition %q", n)}
+		}
+	}
+	switch d.Return {
+	case "", DSNReturnFull, DSNReturnHeaders:
+	default:
+		return &InvalidDSNError{Reason: fmt.Sprintf("unknown return %q", d.Return)}
+	}
+	if len(xtext(d.EnvelopeID)) > maxEnvelopeIDLen {
+		return &InvalidDSNError{Reason: "the encoded envelope identifier exceeds 100 characters"}
+	}
+	return nil
+}
+
+// mailParams returns the parameters of the MAIL command.
+func (d *DSN) mailParams() []string {
+	var params []string
+	if d.Return != "" {
+		params = append(params, "RET="+string(d.Return))
+	}
+	if d.EnvelopeID != "" {
+		params = append(params, "ENVID="+xtext(d.EnvelopeID))
+	}
+	return params
+}
+
+// rcptParams returns the parameters of the RCPT command of the recipient to.
+func (d *DSN) rcptParams(to string) []string {
+	var params []string
+	if len(d.Notify) > 0 {
+		conditions := make([]string, len(d.Notify))
+		for i, n := range d.Notify {
+			conditions[i] = string(n)
+		}
+		params = append(params, "NOTIFY="+strings.Join(conditions, ","))
+	}
+	if orcpt, ok := d.OriginalRecipients[to]; ok {
+		params = append(params, "ORCPT=rfc822;"+xtext(orcpt))
+	}
+	return params
+}
+
+// xtext encodes s as an xtext (RFC 3461, section 4): the characters outside
+// of the printable ASCII range, "+" and "=" are encoded as "+" followed by
+// their hexadecimal value.
+func xtext(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < '!' || c > '~' || c == '+' || c == '=' {
+			fmt.Fprintf(&sb, "+%02X", c)
+			continue
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
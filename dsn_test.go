@@ -0,0 +1,178 @@
+package gomail
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestXText(t *testing.T) {
+	tests := []struct {
+		s, want string
+	}{
+		{"", ""},
+		{"abc-123@example.com", "abc-123@example.com"},
+		{"a+b=c", "a+2Bb+3Dc"},
+		{"id with space", "id+20with+20space"},
+		{"café", "caf+C3+A9"},
+	}
+
+	for _, tt := range tests {
+		if got := xtext(tt.s); got != tt.want {
+			t.Errorf("xtext(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestDSNValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		dsn   DSN
+		valid bool
+	}{
+		{"Empty", DSN{}, true},
+		{"Notify", DSN{Notify: []DSNNotify{DSNNotifySuccess, DSNNotifyFailure, DSNNotifyDelay}}, true},
+		{"Never", DSN{Notify: []DSNNotify{DSNNotifyNever}}, true},
+		{"NeverCombined", DSN{Notify: []DSNNotify{DSNNotifyNever, DSNNotifyFailure}}, false},
+		{"UnknownNotify", DSN{Notify: []DSNNotify{"ALWAYS"}}, false},
+		{"Return", DSN{Return: DSNReturnHeaders}, true},
+		{"UnknownReturn", DSN{Return: "BODY"}, false},
+		{"EnvelopeID", DSN{EnvelopeID: strings.Repeat("a", 100)}, true},
+		{"LongEnvelopeID", DSN{EnvelopeID: strings.Repeat("+", 34)}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.dsn.validate()
+			if tt.valid && err != nil {
+				t.Errorf("got error %v, want none", err)
+			}
+			if !tt.valid && !errors.Is(err, &InvalidDSNError{}) {
+				t.Errorf("got error %v, want an InvalidDSNError", err)
+			}
+		})
+	}
+}
+
+var testDSN = DSN{
+	Notify:             []DSNNotify{DSNNotifySuccess, DSNNotifyFailure},
+	Return:             DSNReturnHeaders,
+	EnvelopeID:         "batch+1",
+	OriginalRecipients: map[string]string{testTo1: "orig+1@example.com"},
+}
+
+func TestDialerDSN(t *testing.T) {
+	tests := []struct {
+		name       string
+		extensions string
+		settings   []MessageSetting
+		dsn        *DSN
+		dkim       bool
+		commands   string
+		wantErr    error
+	}{
+		{
+			name:       "MessageSetting",
+			extensions: "250-PIPELINING\n250 DSN\n",
+			settings:   []MessageSetting{SetDSN(testDSN)},
+			commands: "MAIL FROM:<" + testFrom + "> RET=HDRS ENVID=batch+2B1\n" +
+				"RCPT TO:<" + testTo1 + "> NOTIFY=SUCCESS,FAILURE ORCPT=rfc822;orig+2B1@example.com\n" +
+				"RCPT TO:<" + testTo2 + "> NOTIFY=SUCCESS,FAILURE\n" +
+				"DATA\n",
+		},
+		{
+			name:       "Context",
+			extensions: "250 DSN\n",
+			dsn:        &DSN{Notify: []DSNNotify{DSNNotifyNever}},
+			commands: "MAIL FROM:<" + testFrom + ">\n" +
+				"RCPT TO:<" + testTo1 + "> NOTIFY=NEVER\n" +
+				"RCPT TO:<" + testTo2 + "> NOTIFY=NEVER\n" +
+				"DATA\n",
+		},
+		{
+			name:       "MessageSettingFirst",
+			extensions: "250 DSN\n",
+			settings:   []MessageSetting{SetDSN(DSN{Return: DSNReturnFull})},
+			dsn:        &DSN{Return: DSNReturnHeaders},
+			commands:   "MAIL FROM:<" + testFrom + "> RET=FULL\n",
+		},
+		{
+			name:       "Middleware",
+			extensions: "250 DSN\n",
+			settings:   []MessageSetting{SetDSN(DSN{Return: DSNReturnFull})},
+			dkim:       true,
+			commands:   "MAIL FROM:<" + testFrom + "> RET=FULL\n",
+		},
+		{
+			name:       "Unsupported",
+			extensions: "250 PIPELINING\n",
+			settings:   []MessageSetting{SetDSN(testDSN)},
+			commands: "MAIL FROM:<" + testFrom + ">\n" +
+				"RCPT TO:<" + testTo1 + ">\n" +
+				"RCPT TO:<" + testTo2 + ">\n" +
+				"DATA\n",
+		},
+		{
+			name:       "Required",
+			extensions: "250 PIPELINING\n",
+			settings:   []MessageSetting{SetDSN(DSN{Return: DSNReturnFull, Required: true})},
+			commands:   "EHLO localhost\nQUIT\n",
+			wantErr:    ErrDSNUnsupported,
+		},
+		{
+			name:       "Invalid",
+			extensions: "250 DSN\n",
+			settings:   []MessageSetting{SetDSN(DSN{Return: "BODY"})},
+			commands:   "EHLO localhost\nQUIT\n",
+			wantErr:    &InvalidDSNError{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := newLineSMTPConn(
+				"220 mx.example.com\n",
+				"250-mx.example.com\n"+tt.extensions,
+			)
+			if tt.wantErr == nil {
+				conn.replies = append(conn.replies,
+					"250 2.1.0 OK\n",
+					"250 2.1.5 OK\n",
+					"250 2.1.5 OK\n",
+					"354 Go ahead\n",
+					"250 2.0.0 Queued\n",
+				)
+			}
+			conn.replies = append(conn.replies, "221 2.0.0 Bye\n")
+			useSMTPConn(t, conn)
+
+			ctx := context.Background()
+			if tt.dsn != nil {
+				ctx = WithDSN(ctx, *tt.dsn)
+			}
+
+			m := NewMessage(tt.settings...)
+			m.SetHeader("From", testFrom)
+			m.SetHeader("To", testTo1, testTo2)
+			m.SetBody("text/plain", "Test message")
+
+			d := NewDialer("localhost", testPort, "", "")
+			d.StartTLSPolicy = NoStartTLS
+			if tt.dkim {
+				signer, err := NewDKIMSigner(DKIMOptions{Domain: "example.com", Selector: "s", Signer: testEd25519Key})
+				if err != nil {
+					t.Fatal(err)
+				}
+				d.SendMiddlewares = SendMiddlewares{WithDKIM(signer)}
+			}
+			if err := d.DialAndSend(ctx, m); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			if got := conn.commands(); !strings.Contains(got, tt.commands) {
+				t.Errorf("commands do not contain:\n%s\ngot:\n%s", tt.commands, got)
+			}
+		})
+	}
+}
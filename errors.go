@@ -10,6 +10,35 @@ var (
 	ErrWrongHostName            = errors.New("gomail: wrong host name")
 	ErrInvalidMessageFromAbsent = errors.New(`gomail: invalid message, "From" field is absent`)
 	ErrCannotWriteAsWriter      = errors.New("gomail: cannot write as writer is in error")
+
+	ErrInvalidMessageRecipientAbsent = errors.New("gomail: invalid message, no recipient")
+	ErrSeveralRecipients             = errors.New("gomail: per-recipient tokens require a message per recipient")
+	ErrBccRecipient                  = errors.New("gomail: per-recipient tokens cannot be signed for a Bcc recipient")
+	ErrUnsubscribeKeyAbsent          = errors.New("gomail: no unsubscribe key")
+	ErrOneClickURLAbsent             = errors.New("gomail: one-click unsubscribe requires an URL")
+	ErrInvalidUnsubscribeToken       = errors.New("gomail: invalid unsubscribe token")
+	ErrDKIMOptionsAbsent             = errors.New("gomail: no DKIM options")
+	ErrInvalidDKIMOptions            = errors.New("gomail: invalid DKIM options")
+	ErrUnsupportedDKIMKey            = errors.New("gomail: unsupported DKIM key type")
+	ErrInvalidARCOptions             = errors.New("gomail: invalid ARC options")
+	ErrTooManyARCSets                = errors.New("gomail: too many ARC sets")
+	ErrInvalidSMIMESigner            = errors.New("gomail: S/MIME signing requires a key and a certificate")
+	ErrUnsupportedSMIMEKey           = errors.New("gomail: unsupported S/MIME key type")
+	ErrInvalidPGPKey                 = errors.New("gomail: invalid OpenPGP key")
+	ErrUnsupportedPGPKey             = errors.New("gomail: unsupported OpenPGP key")
+	ErrInvalidPGPPassphrase          = errors.New("gomail: invalid OpenPGP passphrase")
+	ErrPGPSecretKeyAbsent            = errors.New("gomail: no OpenPGP secret key")
+	ErrTrackingKeyAbsent             = errors.New("gomail: no tracking key")
+	ErrTrackingURLAbsent             = errors.New("gomail: no tracking URL")
+	ErrInvalidTrackingToken          = errors.New("gomail: invalid tracking token")
+	ErrDispositionNotificationAbsent = errors.New("gomail: no disposition notification requested")
+	ErrInvalidDispositionType        = errors.New("gomail: invalid disposition type")
+	ErrInvalidSMTPLine               = errors.New("gomail: SMTP command argument contains a line break")
+	ErrSMTPHelloSent                 = errors.New("gomail: EHLO or HELO already sent")
+	ErrBinaryMIMEUnsupported         = errors.New("gomail: binary content requires the CHUNKING and BINARYMIME extensions")
+	Err8BitMIMEUnsupported           = errors.New("gomail: 8-bit content of a replaced message requires the 8BITMIME extension")
+	ErrDSNUnsupported                = errors.New("gomail: server does not support delivery status notifications")
+	ErrRequireTLSUnsupported         = errors.New("gomail: server does not support REQUIRETLS")
 )
 
 // A SendError represents the failure to transmit a Message, detailing the cause
@@ -18,6 +47,9 @@ type SendError struct {
 	// Index specifies the index of the Message within a batch.
 	Index uint
 	Cause error
+	// Result lists the accepted and rejected recipients of the Message when
+	// the SMTP server rejected some of them. It is nil otherwise.
+	Result *SendResult
 }
 
 func (err *SendError) Error() string {
@@ -25,6 +57,10 @@ func (err *SendError) Error() string {
 		err.Index+1, err.Cause)
 }
 
+func (err *SendError) Unwrap() error {
+	return err.Cause
+}
+
 func (*SendError) Is(err error) bool {
 	if _, ok := err.(*SendError); ok {
 		return true
@@ -67,8 +103,163 @@ func (*InvalidAddress) Is(err error) bool {
 	return false
 }
 
+// A MissingAddressError is returned when the header field holding the sender
+// of a message, such as From or Sender, has no mailbox. It matches
+// ErrInvalidMessageFromAbsent with errors.Is.
+type MissingAddressError struct {
+	Field string
+}
+
+func (e *MissingAddressError) Error() string {
+	return fmt.Sprintf("gomail: invalid message, %q field has no address", e.Field)
+}
+
+func (*MissingAddressError) Is(err error) bool {
+	if _, ok := err.(*MissingAddressError); ok {
+		return true
+	}
+	return err == ErrInvalidMessageFromAbsent
+}
+
+// A MissingCertificateError is returned when a message cannot be encrypted
+// because the S/MIME certificate or the OpenPGP key of a recipient is unknown.
+type MissingCertificateError struct {
+	Address string
+}
+
+func (e *MissingCertificateError) Error() string {
+	return fmt.Sprintf("gomail: no certificate or key for recipient %q", e.Address)
+}
+
+func (*MissingCertificateError) Is(err error) bool {
+	if _, ok := err.(*MissingCertificateError); ok {
+		return true
+	}
+	return false
+}
+
+// A MessageTooLargeError is returned when a message is larger than the
+// maximum size allowed by the Dialer or by the SMTP server. It is returned
+// before the message is transmitted.
+type MessageTooLargeError struct {
+	Size    int64
+	MaxSize int64
+	// Server reports that MaxSize is the limit advertised by the SMTP server
+	// with the SIZE extension (RFC 1870) rather than Dialer.MaxSize.
+	Server bool
+}
+
+func (e *MessageTooLargeError) Error() string {
+	return fmt.Sprintf("gomail: message size %d exceeds the maximum size %d", e.Size, e.MaxSize)
+}
+
+// Temporary always reports false: sending the message again fails the same
+// way.
+func (*MessageTooLargeError) Temporary() bool {
+	return false
+}
+
+func (*MessageTooLargeError) Is(err error) bool {
+	if _, ok := err.(*MessageTooLargeError); ok {
+		return true
+	}
+	return false
+}
+
+// An InvalidAMPError is returned when the AMP for Email part of a message does
+// not meet the requirements of AMP for Email. It is returned before the
+// message is written.
+type InvalidAMPError struct {
+	Reason string
+}
+
+func (e *InvalidAMPError) Error() string {
+	return "gomail: invalid AMP part: " + e.Reason
+}
+
+func (*InvalidAMPError) Is(err error) bool {
+	if _, ok := err.(*InvalidAMPError); ok {
+		return true
+	}
+	return false
+}
+
+// An InvalidDSNError is returned when the delivery status notification
+// parameters of a message are invalid. It is returned before the message is
+// transmitted.
+type InvalidDSNError struct {
+	Reason string
+}
+
+func (e *InvalidDSNError) Error() string {
+	return "gomail: invalid DSN parameters: " + e.Reason
+}
+
+func (*InvalidDSNError) Is(err error) bool {
+	if _, ok := err.(*InvalidDSNError); ok {
+		return true
+	}
+	return false
+}
+
+// A RecipientsError is returned by Dialer when the SMTP server rejects
+// recipients of a message, or when one of the transactions of a message whose
+// recipients were split fails. It wraps the error of the first rejected
+// recipient, or else the error of the failed transaction.
+type RecipientsError struct {
+	Result SendResult
+	// Sent reports that the message has been sent to the accepted recipients,
+	// as allowed by the AcceptedRecipients policy, or to the recipients of
+	// the transactions which succeeded.
+	Sent bool
+}
+
+func (e *RecipientsError) Error() string {
+	total := len(e.Result.Accepted) + len(e.Result.Rejected) + len(e.Result.Skipped)
+	var msg string
+	if len(e.Result.Rejected) > 0 {
+		msg = fmt.Sprintf("gomail: %d of %d recipients rejected", len(e.Result.Rejected), total)
+	} else {
+		msg = fmt.Sprintf("gomail: message not sent to all of its %d recipients", total)
+	}
+	if e.Sent {
+		msg += ", message sent to the others"
+	}
+	if len(e.Result.Rejected) > 0 {
+		return msg + ": " + e.Result.Rejected[0].Address + ": " + e.Result.Rejected[0].Err.Error()
+	}
+	if b := e.Result.failedBatch(); b != nil {
+		return msg + ": " + b.Err.Error()
+	}
+	return msg
+}
+
+func (e *RecipientsError) Unwrap() error {
+	if len(e.Result.Rejected) > 0 {
+		return e.Result.Rejected[0].Err
+	}
+	if b := e.Result.failedBatch(); b != nil {
+		return b.Err
+	}
+	return nil
+}
+
+func (*RecipientsError) Is(err error) bool {
+	if _, ok := err.(*RecipientsError); ok {
+		return true
+	}
+	return false
+}
+
 var _ = []error{
 	(*SendError)(nil),
 	(*UnexpectedServerChallengeError)(nil),
 	(*InvalidAddress)(nil),
+	(*MissingAddressError)(nil),
+	(*MissingCertificateError)(nil),
+	(*MessageTooLargeError)(nil),
+	(*InvalidAMPError)(nil),
+	(*SMTPError)(nil),
+	(*InvalidDSNError)(nil),
+	(*RecipientsError)(nil),
 }
@@ -0,0 +1,201 @@
+package gomail
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"strings"
+)
+
+const (
+	// defaultFlowedWidth is the line length recommended by RFC 3676.
+	defaultFlowedWidth = 72
+	// maxFlowedWidth is the maximum line length allowed by RFC 3676.
+	maxFlowedWidth = 78
+	// sigSeparator is the usenet signature separator, which must never be
+	// considered as a flowed line.
+	sigSeparator = "-- "
+)
+
+type flowed struct {
+	width int
+	delSp bool
+}
+
+// SetPartFlowed wraps the text of a text/plain part at width columns and sends
+// it with format=flowed (RFC 3676), so that capable clients reflow the
+// paragraphs to the width of their window while others display tidy lines.
+// width defaults to 72 when it is 0 and cannot exceed 78.
+//
+// Lines are wrapped at spaces. When delSp is true, delsp=yes is set and the
+// words too long to fit on a line, such as the sentences of languages written
+// without spaces, are wrapped too.
+//
+// Lines starting with ">" are considered as quoted text: they are wrapped at
+// the same quote depth.
+func SetPartFlowed(width int, delSp bool) PartSetting {
+	if width <= 0 {
+		width = defaultFlowedWidth
+	}
+	if width > maxFlowedWidth {
+		width = maxFlowedWidth
+	}
+
+	return PartSetting(func(p *part) {
+		p.flowed = &flowed{width: width, delSp: delSp}
+	})
+}
+
+// appliesTo reports whether a part of the given content type can be flowed:
+// only text/plain parts without a format parameter can.
+func (f *flowed) appliesTo(contentType string) bool {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "text/plain" && params["format"] == ""
+}
+
+// contentType returns the parameters to add to the Content-Type header field.
+func (f *flowed) contentType() string {
+	if f.delSp {
+		return "; format=flowed; delsp=yes"
+	}
+	return "; format=flowed"
+}
+
+// copier returns a copier writing the text written by copier as flowed text.
+func (f *flowed) copier(copier func(io.Writer) error) func(io.Writer) error {
+	return func(w io.Writer) error {
+		var buf bytes.Buffer
+		if err := copier(&buf); err != nil {
+			return err
+		}
+
+		_, err := io.WriteString(w, f.encode(buf.String()))
+		return err
+	}
+}
+
+// encode returns text as flowed text with CRLF line breaks.
+func (f *flowed) encode(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	lines := strings.Split(text, "\n")
+
+	var sb strings.Builder
+	for i, line := range lines {
+		if i > 0 {
+			sb.WriteString("\r\n")
+		}
+		if line == sigSeparator {
+			sb.WriteString(line)
+			continue
+		}
+
+		// Spaces before a hard line break would turn it into a soft one.
+		line = strings.TrimRight(line, " ")
+
+		depth := 0
+		for strings.HasPrefix(line, ">") {
+			depth++
+			line = line[1:]
+			// Nested quote marks are sometimes separated by spaces.
+			if strings.HasPrefix(line, " >") {
+				line = line[1:]
+			}
+		}
+		quote := strings.Repeat(">", depth)
+		if depth > 0 {
+			line = strings.TrimPrefix(line, " ")
+		}
+
+		// A character is kept for the space which may be stuffed.
+		for j, l := range f.wrap(line, f.width-depth-1) {
+			if j > 0 {
+				sb.WriteString("\r\n")
+			}
+			sb.WriteString(quote)
+			sb.WriteString(stuff(l, depth > 0))
+		}
+	}
+
+	return sb.String()
+}
+
+// wrap splits line into lines of at most width characters. Every line but the
+// last ends with a space, making it a flowed line.
+func (f *flowed) wrap(line string, width int) []string {
+	if f.delSp {
+		// A character is kept for the space added at the end of the lines.
+		width--
+	}
+	if width < 1 {
+		width = 1
+	}
+
+	var lines []string
+	var current strings.Builder
+	currentLen := 0
+	flush := func() {
+		if f.delSp {
+			current.WriteString(" ")
+		}
+		lines = append(lines, current.String())
+		current.Reset()
+		currentLen = 0
+	}
+
+	for _, word := range splitWords(line) {
+		runes := []rune(word)
+		if currentLen > 0 && currentLen+len(runes) > width {
+			flush()
+		}
+
+		// A word longer than a line can only be broken with delsp=yes, as
+		// the spaces added at the end of the lines are then removed.
+		for f.delSp && len(runes) > width {
+			current.WriteString(string(runes[:width]))
+			runes = runes[width:]
+			flush()
+		}
+
+		current.WriteString(string(runes))
+		currentLen += len(runes)
+	}
+	if currentLen > 0 || len(lines) == 0 {
+		lines = append(lines, current.String())
+	}
+
+	return lines
+}
+
+// splitWords splits s after each space, so that each word keeps its trailing
+// space.
+func splitWords(s string) []string {
+	var words []string
+	for s != "" {
+		i := strings.IndexByte(s, ' ')
+		if i < 0 {
+			words = append(words, s)
+			break
+		}
+		for i+1 < len(s) && s[i+1] == ' ' {
+			i++
+		}
+		words = append(words, s[:i+1])
+		s = s[i+1:]
+	}
+
+	return words
+}
+
+// stuff adds a space before lines which would otherwise be misinterpreted
+// (RFC 3676, section 4.4). Quoted lines are always stuffed so that the quote
+// marks are followed by a space, unless they are empty.
+func stuff(line string, quoted bool) string {
+	if quoted && line == "" {
+		return line
+	}
+	if quoted || strings.HasPrefix(line, " ") || strings.HasPrefix(line, ">") ||
+		strings.HasPrefix(line, "From ") {
+		return " " + line
+	}
+	return line
+}
@@ -0,0 +1,162 @@
+package gomail
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestPartFlowed(t *testing.T) {
+	m := NewMessage()
+	m.SetHeader("From", "from@example.com")
+	m.SetHeader("To", "to@example.com")
+	m.SetBody("text/plain", "Lorem ipsum dolor sit amet, consectetur adipiscing elit.\n"+
+		"> From the quoted message.\n"+
+		"\n"+
+		"-- \n"+
+		"Signature", SetPartFlowed(30, false), SetPartEncoding(Unencoded))
+	m.AddAlternative("text/html", "<p>Lorem ipsum</p>", SetPartFlowed(30, false))
+
+	want := &message{
+		from: "from@example.com",
+		to:   []string{"to@example.com"},
+		content: "From: from@example.com\r\n" +
+			"To: to@example.com\r\n" +
+			"Content-Type: multipart/alternative;\r\n" +
+			" boundary=_BOUNDARY_1_\r\n" +
+			"\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			"Content-Type: text/plain; charset=UTF-8; format=flowed\r\n" +
+			"Content-Transfer-Encoding: 8bit\r\n" +
+			"\r\n" +
+			"Lorem ipsum dolor sit amet, \r\n" +
+			"consectetur adipiscing elit.\r\n" +
+			"> From the quoted message.\r\n" +
+			"\r\n" +
+			"-- \r\n" +
+			"Signature\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			"Content-Type: text/html; charset=UTF-8\r\n" +
+			"Content-Transfer-Encoding: quoted-printable\r\n" +
+			"\r\n" +
+			"<p>Lorem ipsum</p>\r\n" +
+			"--_BOUNDARY_1_--\r\n",
+	}
+
+	testMessage(t, m, 1, want)
+}
+
+func TestFlowedEncode(t *testing.T) {
+	tests := []struct {
+		name  string
+		width int
+		delSp bool
+		text  string
+		want  string
+	}{
+		{
+			name:  "short lines",
+			width: 20,
+			text:  "Hello!\r\nHow are you?  ",
+			want:  "Hello!\r\nHow are you?",
+		},
+		{
+			name:  "wrapped paragraph",
+			width: 20,
+			text:  "The quick brown fox jumps over the lazy dog.",
+			want:  "The quick brown \r\nfox jumps over the \r\nlazy dog.",
+		},
+		{
+			name:  "space-stuffing",
+			width: 20,
+			text:  " indented\nFrom me",
+			want:  "  indented\r\n From me",
+		},
+		{
+			name:  "wrapped line starting with From",
+			width: 13,
+			text:  "Hello world From here",
+			want:  "Hello world \r\n From here",
+		},
+		{
+			name:  "quoted text",
+			width: 20,
+			text:  "> > The quick brown fox jumps.\n>\n> Over the lazy dog.",
+			want:  ">> The quick brown \r\n>> fox jumps.\r\n>\r\n> Over the lazy dog.",
+		},
+		{
+			name:  "long word",
+			width: 10,
+			text:  "a https://example.com/long b",
+			want:  "a \r\nhttps://example.com/long \r\nb",
+		},
+		{
+			name:  "delsp",
+			width: 10,
+			delSp: true,
+			text:  "日本語のテキストです。 ok",
+			want:  "日本語のテキスト \r\nです。 ok",
+		},
+	}
+
+	for _, test := range tests {
+		f := &flowed{width: test.width, delSp: test.delSp}
+		if got := f.encode(test.text); got != test.want {
+			t.Errorf("%s: invalid flowed text, got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestFlowedRoundTrip(t *testing.T) {
+	text := strings.Repeat("Lorem ipsum dolor sit amet, consectetur adipiscing elit. ", 10) + "End.\n" +
+		"> " + strings.Repeat("Quoted text that goes on. ", 8) + "End.\n" +
+		"\n" +
+		strings.Repeat("長い日本語の文章", 20)
+
+	for _, delSp := range []bool{false, true} {
+		f := &flowed{width: defaultFlowedWidth, delSp: delSp}
+		encoded := f.encode(text)
+		for _, line := range strings.Split(encoded, "\r\n") {
+			if n := utf8.RuneCountInString(line); n > defaultFlowedWidth && strings.Contains(line, " ") {
+				t.Errorf("delsp=%t: line too long (%d): %q", delSp, n, line)
+			}
+		}
+
+		want := strings.Replace(text, "> ", ">", 1)
+		if got := decodeFlowed(encoded, delSp); got != want {
+			t.Errorf("delsp=%t: invalid decoded text, got %q, want %q", delSp, got, want)
+		}
+	}
+}
+
+// decodeFlowed decodes flowed text as described in RFC 3676, section 4.
+func decodeFlowed(text string, delSp bool) string {
+	var out []string
+	var paragraph strings.Builder
+	prevQuote, flowing := "", false
+	for _, line := range strings.Split(text, "\r\n") {
+		quote := line[:len(line)-len(strings.TrimLeft(line, ">"))]
+		line = strings.TrimPrefix(line[len(quote):], " ")
+
+		if flowing && quote != prevQuote {
+			out = append(out, paragraph.String())
+			paragraph.Reset()
+		}
+		if !flowing || quote != prevQuote {
+			paragraph.WriteString(quote)
+		}
+
+		flowing = strings.HasSuffix(line, " ") && line != sigSeparator
+		if flowing && delSp {
+			line = line[:len(line)-1]
+		}
+		paragraph.WriteString(line)
+		prevQuote = quote
+		if !flowing {
+			out = append(out, paragraph.String())
+			paragraph.Reset()
+		}
+	}
+
+	return strings.Join(out, "\n")
+}
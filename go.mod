@@ -1,6 +1,6 @@
 module github.com/sters/gomail
 
-go 1.19
+go 1.20
 
 require github.com/golangci/golangci-lint v1.49.0
 
@@ -0,0 +1,233 @@
+package gomail
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"mime"
+	stdmail "net/mail"
+	"strings"
+)
+
+// SetDispositionNotificationTo is a message setting to request a read receipt
+// with the Disposition-Notification-To header (RFC 8098). The notifications
+// are sent to the given addresses, or to the first address of the From header
+// when none is given. The header is left untouched if it is already set on
+// the message.
+func SetDispositionNotificationTo(addresses ...Address) MessageSetting {
+	return func(m *Message) {
+		m.dispositionNotificationTo = addresses
+		m.requestDisposition = true
+	}
+}
+
+func (w *messageWriter) writeDispositionNotificationTo(m *Message) {
+	if !m.requestDisposition {
+		return
+	}
+	if _, ok := m.header["Disposition-Notification-To"]; ok {
+		return
+	}
+
+	addresses := m.dispositionNotificationTo
+	if len(addresses) == 0 {
+		list, err := m.GetAddresses("From")
+		if err != nil {
+			w.err = err
+			return
+		}
+		mailboxes := list.Mailboxes()
+		if len(mailboxes) == 0 {
+			w.err = ErrInvalidMessageFromAbsent
+			return
+		}
+		addresses = mailboxes[:1]
+	}
+
+	values := make([]string, len(addresses))
+	for i, a := range addresses {
+		values[i] = m.formatAddress(a)
+	}
+	w.writeHeader("Disposition-Notification-To", values...)
+}
+
+// A DispositionType is what happened to a message reported by a disposition
+// notification.
+type DispositionType string
+
+const (
+	// DispositionDisplayed reports that the message has been displayed to
+	// the recipient.
+	DispositionDisplayed DispositionType = "displayed"
+	// DispositionDeleted reports that the message has been deleted without
+	// being displayed.
+	DispositionDeleted DispositionType = "deleted"
+	// DispositionDispatched reports that the message has been sent somewhere
+	// without being displayed, such as printed or forwarded.
+	DispositionDispatched DispositionType = "dispatched"
+	// DispositionProcessed reports that the message has been processed
+	// without being displayed.
+	DispositionProcessed DispositionType = "processed"
+)
+
+// DispositionNotification describes the disposition notification (RFC 8098)
+// built by NewDispositionNotification.
+type DispositionNotification struct {
+	// FinalRecipient is the mailbox which received the original message. It
+	// is the author of the notification and is required.
+	FinalRecipient Address
+	// ReportingUA is the name of the user agent generating the notification,
+	// such as "mail.example.com; Example Mail". It is optional.
+	ReportingUA string
+	// Type is the disposition of the original message. It defaults to
+	// DispositionDisplayed.
+	Type DispositionType
+	// Automatic reports that the notification is generated without an action
+	// of the recipient, rather than after they allowed it.
+	Automatic bool
+	// Text is the human readable explanation of the notification. A default
+	// one is generated when it is empty.
+	Text string
+	// IncludeHeaders adds the header of the original message as a
+	// text/rfc822-headers part.
+	IncludeHeaders bool
+}
+
+var dispositionSubjects = map[DispositionType]string{
+	DispositionDisplayed:  "Read",
+	DispositionDeleted:    "Deleted",
+	DispositionDispatched: "Dispatched",
+	DispositionProcessed:  "Processed",
+}
+
+// NewDispositionNotification reads the original message and returns the
+// disposition notification replying to it, a multipart/report message with a
+// human readable part and a message/disposition-notification part holding the
+// original recipient and Message-ID. ErrDispositionNotificationAbsent is
+// returned if the original message does not request a notification.
+//
+// The notification is sent to the addresses of the Disposition-Notification-To
+// header of the original message, with a null return path as required by RFC
+// 8098.
+func NewDispositionNotification(original io.Reader, n DispositionNotification, settings ...MessageSetting) (*Message, error) {
+	if n.FinalRecipient.Address == "" {
+		return nil, ErrInvalidMessageFromAbsent
+	}
+	if n.Type == "" {
+		n.Type = DispositionDisplayed
+	}
+	if _, ok := dispositionSubjects[n.Type]; !ok {
+		return nil, ErrInvalidDispositionType
+	}
+
+	header, err := readHeader(original)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := stdmail.ReadMessage(bytes.NewReader(append(header, "\r\n"...)))
+	if err != nil {
+		return nil, err
+	}
+	notifyTo := msg.Header.Get("Disposition-Notification-To")
+	if notifyTo == "" {
+		return nil, ErrDispositionNotificationAbsent
+	}
+
+	dec := new(mime.WordDecoder)
+	subject, err := dec.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		subject = msg.Header.Get("Subject")
+	}
+	messageID := msg.Header.Get("Message-ID")
+
+	m := NewMessage(settings...)
+	m.SetAddresses("From", n.FinalRecipient)
+	m.SetHeader("To", notifyTo)
+	m.SetHeader("Subject", dispositionSubjects[n.Type]+": "+subject)
+	if messageID != "" {
+		m.SetHeader("In-Reply-To", messageID)
+		m.SetHeader("References", messageID)
+	}
+	m.SetHeader("Auto-Submitted", "auto-replied")
+	m.reportType = "disposition-notification"
+	m.nullReturnPath = true
+
+	text := n.Text
+	if text == "" {
+		text = dispositionText(n, msg.Header.Get("Date"), subject)
+	}
+	m.SetBody("text/plain", text)
+	m.AddAlternative("message/disposition-notification", dispositionFields(n, msg.Header),
+		SetPartEncoding(Unencoded), SetPartCharset(""))
+
+	if n.IncludeHeaders {
+		m.AddAlternative("text/rfc822-headers", string(header),
+			SetPartEncoding(Unencoded), SetPartCharset(""))
+	}
+
+	return m, nil
+}
+
+// readHeader reads the header of a message from r and returns it as it is,
+// with its fields in their order and folding, and CRLF line endings. The
+// empty line ending it is left out, and the body is not read.
+func readHeader(r io.Reader) ([]byte, error) {
+	br := bufio.NewReader(r)
+	var header []byte
+	for {
+		line, err := br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		line = bytes.TrimRight(line, "\r\n")
+		if len(line) == 0 {
+			return header, nil
+		}
+		header = append(append(header, line...), "\r\n"...)
+		if err == io.EOF {
+			return header, nil
+		}
+	}
+}
+
+// dispositionText returns the default human readable part of n.
+func dispositionText(n DispositionNotification, date, subject string) string {
+	var sb strings.Builder
+	sb.WriteString("The message")
+	if date != "" {
+		sb.WriteString(" sent on " + date)
+	}
+	sb.WriteString(" to " + n.FinalRecipient.Address)
+	if subject != "" {
+		sb.WriteString(" with subject \"" + subject + "\"")
+	}
+	sb.WriteString(" has been " + string(n.Type) + ".")
+	if n.Type == DispositionDisplayed {
+		sb.WriteString(" This is no guarantee that the message has been read or understood.")
+	}
+	return sb.String()
+}
+
+// dispositionFields returns the content of the message/disposition-notification
+// part (RFC 8098, section 3.1).
+func dispositionFields(n DispositionNotification, original stdmail.Header) string {
+	mode := "manual-action/MDN-sent-manually"
+	if n.Automatic {
+		mode = "automatic-action/MDN-sent-automatically"
+	}
+
+	var sb strings.Builder
+	if n.ReportingUA != "" {
+		sb.WriteString("Reporting-UA: " + n.ReportingUA + "\r\n")
+	}
+	if r := original.Get("Original-Recipient"); r != "" {
+		sb.WriteString("Original-Recipient: " + r + "\r\n")
+	}
+	sb.WriteString("Final-Recipient: rfc822;" + n.FinalRecipient.Address + "\r\n")
+	if id := original.Get("Message-ID"); id != "" {
+		sb.WriteString("Original-Message-ID: " + id + "\r\n")
+	}
+	sb.WriteString("Disposition: " + mode + "; " + string(n.Type) + "\r\n")
+
+	return sb.String()
+}
@@ -0,0 +1,159 @@
+package gomail
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSetDispositionNotificationTo(t *testing.T) {
+	tests := []struct {
+		addresses []Address
+		header    string
+		want      string
+	}{
+		{nil, "", "Disposition-Notification-To: \"Sender\" <from@example.com>\r\n"},
+		{[]Address{{Address: "receipts@example.com"}}, "", "Disposition-Notification-To: receipts@example.com\r\n"},
+		{nil, "other@example.com", "Disposition-Notification-To: other@example.com\r\n"},
+	}
+
+	for _, test := range tests {
+		m := NewMessage(SetDispositionNotificationTo(test.addresses...))
+		m.SetAddressHeader("From", "from@example.com", "Sender")
+		m.SetHeader("To", "to@example.com")
+		if test.header != "" {
+			m.SetHeader("Disposition-Notification-To", test.header)
+		}
+		m.SetBody("text/plain", "Test message")
+
+		want := &message{
+			from: "from@example.com",
+			to:   []string{"to@example.com"},
+			content: "From: \"Sender\" <from@example.com>\r\n" +
+				"To: to@example.com\r\n" +
+				test.want +
+				"Content-Type: text/plain; charset=UTF-8\r\n" +
+				"Content-Transfer-Encoding: quoted-printable\r\n" +
+				"\r\n" +
+				"Test message",
+		}
+
+		testMessage(t, m, 0, want)
+	}
+}
+
+const testMDNOriginal = "From: from@example.com\r\n" +
+	"To: to@example.com\r\n" +
+	"Subject: =?UTF-8?q?Mise_en_demeure?=\r\n" +
+	"Date: Tue, 24 Jun 2014 10:00:00 +0000\r\n" +
+	"Message-ID: <notice-1@example.com>\r\n" +
+	"Original-Recipient: rfc822;legal@example.com\r\n" +
+	"Disposition-Notification-To: \"Legal\" <receipts@example.com>\r\n" +
+	"\r\n" +
+	"Notice"
+
+func TestNewDispositionNotification(t *testing.T) {
+	m, err := NewDispositionNotification(strings.NewReader(testMDNOriginal), DispositionNotification{
+		FinalRecipient: Address{Address: "to@example.com"},
+		ReportingUA:    "mail.example.com; gomail",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &message{
+		from: "",
+		to:   []string{"receipts@example.com"},
+		content: "From: to@example.com\r\n" +
+			"To: \"Legal\" <receipts@example.com>\r\n" +
+			"Subject: Read: Mise en demeure\r\n" +
+			"In-Reply-To: <notice-1@example.com>\r\n" +
+			"References: <notice-1@example.com>\r\n" +
+			"Auto-Submitted: auto-replied\r\n" +
+			"Content-Type: multipart/report; report-type=disposition-notification;\r\n" +
+			" boundary=_BOUNDARY_1_\r\n" +
+			"\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			"Content-Type: text/plain; charset=UTF-8\r\n" +
+			"Content-Transfer-Encoding: quoted-printable\r\n" +
+			"\r\n" +
+			"The message sent on Tue, 24 Jun 2014 10:00:00 +0000 to to@example.com with =\r\n" +
+			"subject \"Mise en demeure\" has been displayed. This is no guarantee that the=\r\n" +
+			" message has been read or understood.\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			"Content-Type: message/disposition-notification\r\n" +
+			"Content-Transfer-Encoding: 8bit\r\n" +
+			"\r\n" +
+			"Reporting-UA: mail.example.com; gomail\r\n" +
+			"Original-Recipient: rfc822;legal@example.com\r\n" +
+			"Final-Recipient: rfc822;to@example.com\r\n" +
+			"Original-Message-ID: <notice-1@example.com>\r\n" +
+			"Disposition: manual-action/MDN-sent-manually; displayed\r\n" +
+			"\r\n" +
+			"--_BOUNDARY_1_--\r\n",
+	}
+
+	testMessage(t, m, 1, want)
+}
+
+func TestNewDispositionNotificationHeaders(t *testing.T) {
+	original := "From: from@example.com\n" +
+		"To: to@example.com,\n" +
+		" other@example.com\n" +
+		"Subject: =?UTF-8?q?Mise_en_demeure?=\n" +
+		"Date: Tue, 24 Jun 2014 10:00:00 +0000\n" +
+		"Message-Id: <notice-1@example.com>\n" +
+		"Disposition-Notification-To: \"Legal\" <receipts@example.com>\n" +
+		"\n" +
+		"Notice"
+	m, err := NewDispositionNotification(strings.NewReader(original), DispositionNotification{
+		FinalRecipient: Address{Address: "to@example.com"},
+		Type:           DispositionDeleted,
+		Automatic:      true,
+		Text:           "Deleted.",
+		IncludeHeaders: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	if _, err := m.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	msg := buf.String()
+	for _, want := range []string{
+		"Subject: Deleted: Mise en demeure\r\n",
+		"\r\nDeleted.\r\n",
+		"Disposition: automatic-action/MDN-sent-automatically; deleted\r\n",
+		"Content-Type: text/rfc822-headers\r\n\r\n" +
+			"From: from@example.com\r\n" +
+			"To: to@example.com,\r\n" +
+			" other@example.com\r\n" +
+			"Subject: =?UTF-8?q?Mise_en_demeure?=\r\n" +
+			"Date: Tue, 24 Jun 2014 10:00:00 +0000\r\n" +
+			"Message-Id: <notice-1@example.com>\r\n",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("notification does not contain %q:\n%s", want, msg)
+		}
+	}
+}
+
+func TestNewDispositionNotificationErrors(t *testing.T) {
+	n := DispositionNotification{FinalRecipient: Address{Address: "to@example.com"}}
+	original := "From: from@example.com\r\nTo: to@example.com\r\n\r\nNotice"
+	if _, err := NewDispositionNotification(strings.NewReader(original), n); !errors.Is(err, ErrDispositionNotificationAbsent) {
+		t.Errorf("got error %v, want %v", err, ErrDispositionNotificationAbsent)
+	}
+
+	n.Type = "read"
+	if _, err := NewDispositionNotification(strings.NewReader(testMDNOriginal), n); !errors.Is(err, ErrInvalidDispositionType) {
+		t.Errorf("got error %v, want %v", err, ErrInvalidDispositionType)
+	}
+
+	if _, err := NewDispositionNotification(strings.NewReader(testMDNOriginal), DispositionNotification{}); !errors.Is(err, ErrInvalidMessageFromAbsent) {
+		t.Errorf("got error %v, want %v", err, ErrInvalidMessageFromAbsent)
+	}
+}
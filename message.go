@@ -10,15 +10,40 @@ import (
 
 // Message represents an email.
 type Message struct {
-	header      header
-	parts       []*part
-	attachments []*file
-	embedded    []*file
-	charset     string
-	encoding    Encoding
-	hEncoder    mimeEncoder
-	buf         bytes.Buffer
-	boundary    string
+	header         header
+	addresses      map[string]AddressList
+	parts          []*part
+	attachments    []*file
+	embedded       []*file
+	charset        string
+	encoding       Encoding
+	hEncoder       mimeEncoder
+	buf            bytes.Buffer
+	boundary       string
+	unsubscribe    *ListUnsubscribe
+	dkim           *DKIMSigner
+	smimeSigner    *SMIMESigner
+	smimeEncrypter *SMIMEEncrypter
+	pgpSigner      *PGPSigner
+	pgpEncrypter   *PGPEncrypter
+	autocrypt      *Autocrypt
+	resent         []*Resent
+	tracking       *Tracking
+	dsn            *DSN
+
+	dispositionNotificationTo []Address
+	requestDisposition        bool
+	// reportType is the report-type of the multipart/report grouping the
+	// parts of a report, such as a disposition notification.
+	reportType string
+	// nullReturnPath is set for the messages sent with an empty envelope
+	// sender.
+	nullReturnPath bool
+	// requireTLS and tlsOptional are set by SetRequireTLS.
+	requireTLS  bool
+	tlsOptional bool
+
+	detectContentType bool
 }
 
 type header map[string][]string
@@ -27,15 +52,20 @@ type part struct {
 	contentType string
 	copier      func(io.Writer) error
 	encoding    Encoding
+	charset     string
+	params      [][2]string
+	header      map[string][]string
+	flowed      *flowed
 }
 
 // NewMessage creates a new message. It uses UTF-8 and quoted-printable encoding
 // by default.
 func NewMessage(settings ...MessageSetting) *Message {
 	m := &Message{
-		header:   make(header),
-		charset:  "UTF-8",
-		encoding: QuotedPrintable,
+		header:    make(header),
+		addresses: make(map[string]AddressList),
+		charset:   "UTF-8",
+		encoding:  QuotedPrintable,
 	}
 
 	m.applySettings(settings)
@@ -55,9 +85,15 @@ func (m *Message) Reset() {
 	for k := range m.header {
 		delete(m.header, k)
 	}
+	for k := range m.addresses {
+		delete(m.addresses, k)
+	}
 	m.parts = nil
 	m.attachments = nil
 	m.embedded = nil
+	m.resent = nil
+	m.reportType = ""
+	m.nullReturnPath = false
 }
 
 func (m *Message) applySettings(settings []MessageSetting) {
@@ -94,8 +130,16 @@ const (
 	// Base64 represents the base64 encoding as defined in RFC 2045.
 	Base64 Encoding = "base64"
 	// Unencoded can be used to avoid encoding the body of an email. The headers
-	// will still be encoded using quoted-printable encoding.
+	// will still be encoded using quoted-printable encoding. When the SMTP
+	// server does not support 8BITMIME, the parts containing 8-bit data are
+	// sent in quoted-printable, or base64 if they are not text. As a message
+	// replaced by a send middleware such as WithDKIM cannot be downgraded, it
+	// then fails with Err8BitMIMEUnsupported.
 	Unencoded Encoding = "8bit"
+	// Binary sends the body as is, without line length limits, as defined in
+	// RFC 3030. Messages with binary parts can only be sent to SMTP servers
+	// supporting the CHUNKING and BINARYMIME extensions.
+	Binary Encoding = "binary"
 )
 
 // SetBoundary sets a custom multipart boundary.
@@ -113,6 +157,7 @@ func (m *Message) SetHeader(field string, value ...string) {
 // m.SetRawHeader("To", m.FormatAddress(address, name), m.FormatAddress(address, name))
 func (m *Message) SetRawHeader(field string, value ...string) {
 	m.header[field] = value
+	delete(m.addresses, field)
 }
 
 func (m *Message) encodeHeader(values []string) []string {
@@ -137,7 +182,7 @@ func (m *Message) SetHeaders(h map[string][]string) {
 
 // SetAddressHeader sets an address to the given header field.
 func (m *Message) SetAddressHeader(field, address, name string) {
-	m.SetRawHeader(field, m.FormatAddress(address, name))
+	m.SetAddresses(field, Address{Name: name, Address: address})
 }
 
 // FormatAddress formats an address and a name as a valid RFC 5322 address.
@@ -146,6 +191,18 @@ func (m *Message) FormatAddress(address, name string) string {
 		return address
 	}
 
+	m.writePhrase(name)
+	m.buf.WriteString(" <")
+	m.buf.WriteString(address)
+	m.buf.WriteByte('>')
+
+	addr := m.buf.String()
+	m.buf.Reset()
+	return addr
+}
+
+// writePhrase writes name to m.buf, quoted or encoded as needed.
+func (m *Message) writePhrase(name string) {
 	enc := m.encodeString(name)
 	switch {
 	case enc == name:
@@ -163,14 +220,6 @@ func (m *Message) FormatAddress(address, name string) string {
 	default:
 		m.buf.WriteString(enc)
 	}
-
-	m.buf.WriteString(" <")
-	m.buf.WriteString(address)
-	m.buf.WriteByte('>')
-
-	addr := m.buf.String()
-	m.buf.Reset()
-	return addr
 }
 
 func hasSpecials(text string) bool {
@@ -184,6 +233,19 @@ func hasSpecials(text string) bool {
 	return false
 }
 
+// bodyEncoding returns the encoding declared with the BODY parameter of the
+// MAIL command for the message: Binary or Unencoded if it has parts or files
+// with these encodings, and encoding7bit otherwise.
+func (m *Message) bodyEncoding() Encoding {
+	switch {
+	case m.hasEncoding(Binary):
+		return Binary
+	case m.hasEncoding(Unencoded):
+		return Unencoded
+	}
+	return encoding7bit
+}
+
 // SetDateHeader sets a date to the given header field.
 func (m *Message) SetDateHeader(field string, date time.Time) {
 	m.header[field] = []string{m.FormatDate(date)}
@@ -239,6 +301,8 @@ func (m *Message) newPart(contentType string, f func(io.Writer) error, settings
 		contentType: contentType,
 		copier:      f,
 		encoding:    m.encoding,
+		charset:     m.charset,
+		header:      make(map[string][]string),
 	}
 
 	for _, s := range settings {
@@ -261,16 +325,81 @@ func SetPartEncoding(e Encoding) PartSetting {
 	})
 }
 
+// SetPartCharset sets the charset parameter of the Content-Type of the part
+// added to the message. By default, parts use the charset of the message. An
+// empty charset omits the parameter, which is useful for non-text parts such
+// as application/json or text/calendar parts whose content is not text.
+func SetPartCharset(charset string) PartSetting {
+	return PartSetting(func(p *part) {
+		p.charset = charset
+	})
+}
+
+// SetPartContentTypeParam adds a parameter to the Content-Type of the part
+// added to the message, such as method=REQUEST for text/calendar parts.
+// Values are quoted when needed.
+func SetPartContentTypeParam(name, value string) PartSetting {
+	return PartSetting(func(p *part) {
+		p.params = append(p.params, [2]string{name, value})
+	})
+}
+
+// SetPartHeader is a part setting to set custom headers of the part added to
+// the message, such as Content-Language, Content-Description, Content-ID or
+// Content-Disposition. Setting the Content-Type or Content-Transfer-Encoding
+// headers replaces the ones computed from the part settings.
+func SetPartHeader(h map[string][]string) PartSetting {
+	return PartSetting(func(p *part) {
+		for k, v := range h {
+			p.header[k] = v
+		}
+	})
+}
+
 type file struct {
 	Name     string
 	Header   map[string][]string
 	CopyFunc func(w io.Writer) error
+
+	// size returns the size of the content without reading it, if known.
+	size func() (int64, error)
+	// buffer reads the content in memory so that it can be read again.
+	buffer func() error
+	// detectType is set when the media type is detected from the content.
+	detectType bool
+	// encoding is the encoding of the content, base64 by default.
+	encoding Encoding
 }
 
 func (f *file) setHeader(field, value string) {
 	f.Header[field] = []string{value}
 }
 
+func (f *file) contentEncoding() Encoding {
+	if f.encoding == "" {
+		return Base64
+	}
+	return f.encoding
+}
+
+// hasEncoding reports whether the message has parts or files with the
+// encoding enc.
+func (m *Message) hasEncoding(enc Encoding) bool {
+	for _, p := range m.parts {
+		if p.encoding == enc {
+			return true
+		}
+	}
+	for _, list := range [][]*file{m.attachments, m.embedded} {
+		for _, f := range list {
+			if f.contentEncoding() == enc {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // A FileSetting can be used as an argument in Message.Attach or Message.Embed.
 type FileSetting func(*file)
 
@@ -295,6 +424,15 @@ func Rename(name string) FileSetting {
 	}
 }
 
+// SetFileEncoding is a file setting to set the encoding of the content of the
+// file, which is base64 by default. Binary avoids the overhead of base64 when
+// the SMTP server supports BINARYMIME.
+func SetFileEncoding(enc Encoding) FileSetting {
+	return func(f *file) {
+		f.encoding = enc
+	}
+}
+
 // SetCopyFunc is a file setting to replace the function that runs when the
 // message is sent. It should copy the content of the file to the io.Writer.
 //
@@ -303,6 +441,8 @@ func Rename(name string) FileSetting {
 func SetCopyFunc(f func(io.Writer) error) FileSetting {
 	return func(fi *file) {
 		fi.CopyFunc = f
+		fi.size = nil
+		fi.buffer = nil
 	}
 }
 
@@ -341,23 +481,23 @@ func fileFromFilename(name string) *file {
 			}
 			return h.Close()
 		},
+		size: filenameSize(name),
 	}
 }
 
 func fileFromReader(name string, r io.Reader) *file {
+	c := &readerContent{r: r}
 	return &file{
-		Name:   filepath.Base(name),
-		Header: make(map[string][]string),
-		CopyFunc: func(w io.Writer) error {
-			if _, err := io.Copy(w, r); err != nil {
-				return err
-			}
-			return nil
-		},
+		Name:     filepath.Base(name),
+		Header:   make(map[string][]string),
+		CopyFunc: c.copy,
+		size:     c.size,
+		buffer:   c.buffer,
 	}
 }
 
 func (m *Message) appendFile(list []*file, f *file, settings []FileSetting) []*file {
+	f.detectType = m.detectContentType
 	for _, s := range settings {
 		s(f)
 	}
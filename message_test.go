@@ -200,6 +200,52 @@ func TestPartSetting(t *testing.T) {
 	testMessage(t, m, 1, want)
 }
 
+func TestPartHeaders(t *testing.T) {
+	m := NewMessage()
+	m.SetHeader("From", "from@example.com")
+	m.SetHeader("To", "to@example.com")
+	m.SetBody("text/plain", "Hello!", SetPartHeader(map[string][]string{
+		"Content-Language":    {"en"},
+		"Content-Description": {"Greetings"},
+	}))
+	m.AddAlternative("text/calendar", "BEGIN:VCALENDAR",
+		SetPartContentTypeParam("method", "REQUEST"),
+		SetPartContentTypeParam("name", "invite file.ics"),
+		SetPartHeader(map[string][]string{"Content-ID": {"<invite@example.com>"}}))
+	m.AddAlternative("application/json", `{"a":1}`, SetPartCharset(""), SetPartEncoding(Base64))
+
+	want := &message{
+		from: "from@example.com",
+		to:   []string{"to@example.com"},
+		content: "From: from@example.com\r\n" +
+			"To: to@example.com\r\n" +
+			"Content-Type: multipart/alternative;\r\n" +
+			" boundary=_BOUNDARY_1_\r\n" +
+			"\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			"Content-Description: Greetings\r\n" +
+			"Content-Language: en\r\n" +
+			"Content-Type: text/plain; charset=UTF-8\r\n" +
+			"Content-Transfer-Encoding: quoted-printable\r\n" +
+			"\r\n" +
+			"Hello!\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			"Content-ID: <invite@example.com>\r\n" +
+			"Content-Type: text/calendar; charset=UTF-8; method=REQUEST; name=\"invite file.ics\"\r\n" +
+			"Content-Transfer-Encoding: quoted-printable\r\n" +
+			"\r\n" +
+			"BEGIN:VCALENDAR\r\n" +
+			"--_BOUNDARY_1_\r\n" +
+			"Content-Type: application/json\r\n" +
+			"Content-Transfer-Encoding: base64\r\n" +
+			"\r\n" +
+			base64.StdEncoding.EncodeToString([]byte(`{"a":1}`)) + "\r\n" +
+			"--_BOUNDARY_1_--\r\n",
+	}
+
+	testMessage(t, m, 1, want)
+}
+
 func TestPartSettingWithCustomBoundary(t *testing.T) {
 	m := NewMessage()
 	m.SetBoundary("lalalaDaiMne3Ryblya")
@@ -0,0 +1,1062 @@
+package gomail
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1" //nolint:gosec // SHA-1 is mandated by OpenPGP v4 fingerprints and MDC.
+	"crypto/sha256"
+	_ "crypto/sha512" // Registers SHA-384 and SHA-512 for crypto.Hash.
+	"encoding/base64"
+	"encoding/binary"
+	"hash"
+	"io"
+	"math/big"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// OpenPGP algorithm identifiers (RFC 4880, section 9 and RFC 6637).
+const (
+	pgpAlgoRSA            = 1
+	pgpAlgoRSAEncryptOnly = 2
+	pgpAlgoRSASignOnly    = 3
+	pgpAlgoECDH           = 18
+	pgpAlgoEdDSA          = 22
+
+	pgpHashSHA1   = 2
+	pgpHashSHA256 = 8
+	pgpHashSHA384 = 9
+	pgpHashSHA512 = 10
+
+	pgpCipherAES128 = 7
+	pgpCipherAES192 = 8
+	pgpCipherAES256 = 9
+
+	pgpFlagSign           = 0x02
+	pgpFlagEncryptComms   = 0x04
+	pgpFlagEncryptStorage = 0x08
+)
+
+// OpenPGP packet tags (RFC 4880, section 4.3).
+const (
+	pgpTagPKESK        = 1
+	pgpTagSignature    = 2
+	pgpTagSecretKey    = 5
+	pgpTagPublicKey    = 6
+	pgpTagSecretSubkey = 7
+	pgpTagLiteralData  = 11
+	pgpTagUserID       = 13
+	pgpTagPublicSubkey = 14
+	pgpTagSEIPD        = 18
+	pgpTagMDC          = 19
+)
+
+var (
+	oidEd25519    = []byte{0x2b, 0x06, 0x01, 0x04, 0x01, 0xda, 0x47, 0x0f, 0x01}
+	oidCurve25519 = []byte{0x2b, 0x06, 0x01, 0x04, 0x01, 0x97, 0x55, 0x01, 0x05, 0x01}
+)
+
+// A PGPKey is an OpenPGP transferable key: a primary key with its user IDs
+// and subkeys, as exported by "gpg --export" or "gpg --export-secret-keys".
+//
+// Only version 4 keys are supported, with RSA and Ed25519 signing keys, and
+// RSA and Curve25519 encryption keys. The self-signatures are verified: the
+// user IDs and subkeys without a valid one are ignored, as are the signing
+// subkeys without a primary key binding signature.
+type PGPKey struct {
+	// UserIDs are the user IDs of the key, such as "Alice <alice@example.com>".
+	UserIDs []string
+
+	primary *pgpPublicKey
+	subkeys []*pgpPublicKey
+	packets []pgpPacket
+}
+
+type pgpPacket struct {
+	tag  byte
+	body []byte
+}
+
+type pgpPublicKey struct {
+	algo    byte
+	created time.Time
+	// body is the public key packet body, used to compute the fingerprint.
+	body []byte
+	// secret is the secret key material, possibly encrypted.
+	secret []byte
+
+	rsa     *rsa.PublicKey
+	ed25519 ed25519.PublicKey
+	x25519  []byte
+	kdfHash byte
+	kdfAlgo byte
+
+	flags   byte
+	expires time.Time
+	revoked bool
+	// certified reports that a valid self-signature binds the key to a user
+	// ID, for the primary key, or to the primary key, for a subkey.
+	certified bool
+	// crossCertified reports that the key can sign: it is the primary key,
+	// or a subkey whose binding signature holds a valid primary key binding
+	// signature made by the subkey.
+	crossCertified bool
+
+	signer crypto.Signer
+}
+
+// ReadPGPKeyRing reads the keys of a binary OpenPGP keyring, or of the first
+// block of an armored one.
+func ReadPGPKeyRing(r io.Reader) ([]*PGPKey, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Contains(data, []byte("-----BEGIN PGP")) {
+		if data, err = pgpDearmor(data); err != nil {
+			return nil, err
+		}
+	}
+
+	packets, err := pgpReadPackets(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []*PGPKey
+	var key *PGPKey
+	// The signatures apply to the user ID or the subkey they follow, if any.
+	var userID []byte
+	var subkey *pgpPublicKey
+	for _, p := range packets {
+		switch p.tag {
+		case pgpTagPublicKey, pgpTagSecretKey:
+			pk, err := pgpParsePublicKey(p.body, p.tag == pgpTagSecretKey)
+			if err != nil {
+				return nil, err
+			}
+			pk.crossCertified = true
+			key = &PGPKey{primary: pk}
+			keys = append(keys, key)
+			userID, subkey = nil, nil
+			p = pgpPacket{pgpTagPublicKey, pk.body}
+		case pgpTagPublicSubkey, pgpTagSecretSubkey:
+			if key == nil {
+				return nil, ErrInvalidPGPKey
+			}
+			pk, err := pgpParsePublicKey(p.body, p.tag == pgpTagSecretSubkey)
+			if err != nil {
+				return nil, err
+			}
+			key.subkeys = append(key.subkeys, pk)
+			userID, subkey = nil, pk
+			p = pgpPacket{pgpTagPublicSubkey, pk.body}
+		case pgpTagUserID:
+			if key == nil {
+				return nil, ErrInvalidPGPKey
+			}
+			userID, subkey = p.body, nil
+		case pgpTagSignature:
+			if key == nil {
+				return nil, ErrInvalidPGPKey
+			}
+			key.applySignature(p.body, userID, subkey)
+		default:
+			// Trust packets and unknown packets are ignored.
+			continue
+		}
+		key.packets = append(key.packets, p)
+	}
+
+	if len(keys) == 0 {
+		return nil, ErrInvalidPGPKey
+	}
+
+	return keys, nil
+}
+
+// Decrypt decrypts the secret keys protected with passphrase. It must be
+// called before signing with a protected key, while unprotected keys can be
+// used as they are read.
+func (k *PGPKey) Decrypt(passphrase []byte) error {
+	hasSecret := false
+	for _, pk := range append([]*pgpPublicKey{k.primary}, k.subkeys...) {
+		if pk.secret == nil {
+			continue
+		}
+		hasSecret = true
+		if pk.signer != nil {
+			continue
+		}
+		if err := pk.decrypt(passphrase); err != nil {
+			return err
+		}
+	}
+	if !hasSecret {
+		return ErrPGPSecretKeyAbsent
+	}
+
+	return nil
+}
+
+// Addresses returns the email addresses of the user IDs of the key.
+func (k *PGPKey) Addresses() []string {
+	var list []string
+	for _, id := range k.UserIDs {
+		if addr, err := mail.ParseAddress(id); err == nil {
+			list = append(list, addr.Address)
+		}
+	}
+	return list
+}
+
+// Fingerprint returns the fingerprint of the primary key.
+func (k *PGPKey) Fingerprint() []byte {
+	return k.primary.fingerprint()
+}
+
+// signingKey returns the key used to sign messages: the primary key or the
+// most recent signing subkey with a decrypted secret key.
+func (k *PGPKey) signingKey() *pgpPublicKey {
+	var found *pgpPublicKey
+	for _, pk := range append([]*pgpPublicKey{k.primary}, k.subkeys...) {
+		if pk.signer != nil && pk.can(pgpFlagSign) && (found == nil || pk.created.After(found.created)) {
+			found = pk
+		}
+	}
+	return found
+}
+
+// encryptionKey returns the most recent valid encryption key.
+func (k *PGPKey) encryptionKey() *pgpPublicKey {
+	var found *pgpPublicKey
+	for _, pk := range append([]*pgpPublicKey{k.primary}, k.subkeys...) {
+		if pk.can(pgpFlagEncryptComms|pgpFlagEncryptStorage) && (found == nil || pk.created.After(found.created)) {
+			found = pk
+		}
+	}
+	return found
+}
+
+// publicKey returns the binary transferable public key.
+func (k *PGPKey) publicKey() []byte {
+	var buf bytes.Buffer
+	for _, p := range k.packets {
+		pgpWritePacket(&buf, p.tag, p.body)
+	}
+	return buf.Bytes()
+}
+
+func (pk *pgpPublicKey) can(flags byte) bool {
+	if !pk.certified || pk.revoked || (!pk.expires.IsZero() && now().After(pk.expires)) {
+		return false
+	}
+
+	isSigning := flags&pgpFlagSign != 0
+	if isSigning && !pk.crossCertified {
+		return false
+	}
+	switch pk.algo {
+	case pgpAlgoRSA:
+	case pgpAlgoRSASignOnly, pgpAlgoEdDSA:
+		if !isSigning {
+			return false
+		}
+	case pgpAlgoRSAEncryptOnly, pgpAlgoECDH:
+		if isSigning {
+			return false
+		}
+	default:
+		return false
+	}
+
+	// Keys without flags can be used for anything their algorithm allows.
+	return pk.flags == 0 || pk.flags&flags != 0
+}
+
+func (pk *pgpPublicKey) fingerprint() []byte {
+	h := sha1.New() //nolint:gosec
+	h.Write(pk.signedData())
+	return h.Sum(nil)
+}
+
+// signedData returns the key as it is hashed by the signatures over it (RFC
+// 4880, section 5.2.4).
+func (pk *pgpPublicKey) signedData() []byte {
+	return append([]byte{0x99, byte(len(pk.body) >> 8), byte(len(pk.body))}, pk.body...)
+}
+
+func (pk *pgpPublicKey) keyID() []byte {
+	return pk.fingerprint()[12:]
+}
+
+// pgpParsePublicKey parses a public or secret key packet body.
+func pgpParsePublicKey(body []byte, hasSecret bool) (*pgpPublicKey, error) {
+	if len(body) < 6 || body[0] != 4 {
+		return nil, ErrUnsupportedPGPKey
+	}
+	pk := &pgpPublicKey{
+		algo:    body[5],
+		created: time.Unix(int64(binary.BigEndian.Uint32(body[1:5])), 0),
+	}
+
+	r := &pgpReader{b: body[6:]}
+	switch pk.algo {
+	case pgpAlgoRSA, pgpAlgoRSAEncryptOnly, pgpAlgoRSASignOnly:
+		n, e := r.mpi(), r.mpi()
+		if r.err == nil {
+			pk.rsa = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		}
+	case pgpAlgoEdDSA:
+		oid, point := r.oid(), r.mpi()
+		if r.err == nil && bytes.Equal(oid, oidEd25519) && len(point) == 33 && point[0] == 0x40 {
+			pk.ed25519 = ed25519.PublicKey(point[1:])
+		}
+	case pgpAlgoECDH:
+		oid, point, kdf := r.oid(), r.mpi(), r.oid()
+		if r.err == nil && bytes.Equal(oid, oidCurve25519) && len(point) == 33 && point[0] == 0x40 && len(kdf) == 3 {
+			pk.x25519 = point[1:]
+			pk.kdfHash, pk.kdfAlgo = kdf[1], kdf[2]
+		}
+	default:
+		// Public keys of other algorithms are kept so that they are exported
+		// along with the others, but they are never used.
+		if hasSecret {
+			return nil, ErrUnsupportedPGPKey
+		}
+		pk.body = body
+		return pk, nil
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	pk.body = body[:len(body)-len(r.b)]
+	if hasSecret {
+		pk.secret = r.b
+		// Unprotected secret keys are ready to use without Decrypt.
+		if len(pk.secret) > 0 && pk.secret[0] == 0 {
+			if err := pk.decrypt(nil); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return pk, nil
+}
+
+// applySignature applies a signature following the primary key, the user ID
+// userID or the subkey subkey. Only the self-signatures made by the primary
+// key are applied, once verified, so that the user IDs and subkeys added by
+// someone else are ignored.
+func (k *PGPKey) applySignature(body, userID []byte, subkey *pgpPublicKey) {
+	if len(body) < 2 {
+		return
+	}
+	sigType := body[1]
+	data := k.primary.signedData()
+	switch {
+	case subkey != nil:
+		data = append(data, subkey.signedData()...)
+		switch {
+		case sigType == 0x18 && pgpVerifySignature(k.primary, body, data):
+			subkey.certified = true
+			subkey.crossCertified = pgpVerifyBackSignature(subkey, body, data)
+			subkey.applySignature(body)
+		case sigType == 0x28 && pgpVerifySignature(k.primary, body, data):
+			subkey.revoked = true
+		}
+	case userID != nil:
+		data = append(data, 0xb4)
+		data = binary.BigEndian.AppendUint32(data, uint32(len(userID)))
+		data = append(data, userID...)
+		if sigType < 0x10 || sigType > 0x13 || !pgpVerifySignature(k.primary, body, data) {
+			return
+		}
+		if n := len(k.UserIDs); n == 0 || k.UserIDs[n-1] != string(userID) {
+			k.UserIDs = append(k.UserIDs, string(userID))
+		}
+		k.primary.certified = true
+		k.primary.applySignature(body)
+	case sigType == 0x1f && pgpVerifySignature(k.primary, body, data):
+		k.primary.certified = true
+		k.primary.applySignature(body)
+	case sigType == 0x20 && pgpVerifySignature(k.primary, body, data):
+		k.primary.revoked = true
+	}
+}
+
+// applySignature applies the key flags and the key expiration of a verified
+// self-signature to the key.
+func (pk *pgpPublicKey) applySignature(body []byte) {
+	hashed, _, _ := pgpSignatureAreas(body)
+	pgpForEachSubpacket(hashed, func(typ byte, data []byte) {
+		switch typ {
+		case 9: // Key expiration time.
+			if len(data) == 4 {
+				if secs := binary.BigEndian.Uint32(data); secs != 0 {
+					pk.expires = pk.created.Add(time.Duration(secs) * time.Second)
+				}
+			}
+		case 27: // Key flags.
+			if len(data) > 0 {
+				pk.flags = data[0]
+			}
+		}
+	})
+}
+
+// pgpSignatureAreas returns the hashed and unhashed subpacket areas of a
+// version 4 signature body, and the rest of the body: the left 16 bits of
+// the digest and the signature MPIs. They are nil if the body is invalid.
+func pgpSignatureAreas(body []byte) (hashed, unhashed, rest []byte) {
+	if len(body) < 6 || body[0] != 4 {
+		return nil, nil, nil
+	}
+	n := int(binary.BigEndian.Uint16(body[4:6]))
+	if len(body) < 8+n {
+		return nil, nil, nil
+	}
+	m := int(binary.BigEndian.Uint16(body[6+n:]))
+	if len(body) < 10+n+m {
+		return nil, nil, nil
+	}
+	return body[6 : 6+n], body[8+n : 8+n+m], body[8+n+m:]
+}
+
+// pgpForEachSubpacket calls fn with the type and the data of the subpackets
+// of a signature subpacket area.
+func pgpForEachSubpacket(area []byte, fn func(typ byte, data []byte)) {
+	for len(area) > 0 {
+		l, hl := pgpSubpacketLength(area)
+		if l == 0 || hl+l > len(area) {
+			return
+		}
+		fn(area[hl]&0x7f, area[hl+1:hl+l])
+		area = area[hl+l:]
+	}
+}
+
+// pgpVerifySignature reports whether the signature body was made by pk over
+// data (RFC 4880, section 5.2.4).
+func pgpVerifySignature(pk *pgpPublicKey, body, data []byte) bool {
+	hashed, _, rest := pgpSignatureAreas(body)
+	if rest == nil || len(rest) < 2 || body[2] != pk.algo {
+		return false
+	}
+	hashAlgo := pgpCryptoHash(body[3])
+	if hashAlgo == 0 {
+		return false
+	}
+
+	h := hashAlgo.New()
+	h.Write(data)
+	h.Write(body[:6+len(hashed)])
+	h.Write([]byte{4, 0xff})
+	_ = binary.Write(h, binary.BigEndian, uint32(6+len(hashed)))
+	digest := h.Sum(nil)
+
+	r := &pgpReader{b: rest[2:]}
+	switch pk.algo {
+	case pgpAlgoRSA, pgpAlgoRSASignOnly:
+		s := r.mpi()
+		if r.err != nil || pk.rsa == nil || len(s) > pk.rsa.Size() {
+			return false
+		}
+		s = append(make([]byte, pk.rsa.Size()-len(s)), s...)
+		return rsa.VerifyPKCS1v15(pk.rsa, hashAlgo, digest, s) == nil
+	case pgpAlgoEdDSA:
+		rv, sv := r.mpi(), r.mpi()
+		if r.err != nil || pk.ed25519 == nil || len(rv) > 32 || len(sv) > 32 {
+			return false
+		}
+		sig := append(append(make([]byte, 32-len(rv)), rv...), append(make([]byte, 32-len(sv)), sv...)...)
+		return ed25519.Verify(pk.ed25519, digest, sig)
+	default:
+		return false
+	}
+}
+
+// pgpVerifyBackSignature reports whether the subkey binding signature body
+// holds a valid primary key binding signature made by subkey over data, as
+// required for the subkey to sign (RFC 4880, section 5.2.1).
+func pgpVerifyBackSignature(subkey *pgpPublicKey, body, data []byte) bool {
+	hashed, unhashed, _ := pgpSignatureAreas(body)
+	verified := false
+	for _, area := range [][]byte{hashed, unhashed} {
+		pgpForEachSubpacket(area, func(typ byte, embedded []byte) {
+			if typ == 32 && len(embedded) > 1 && embedded[1] == 0x19 && pgpVerifySignature(subkey, embedded, data) {
+				verified = true
+			}
+		})
+	}
+	return verified
+}
+
+func pgpSubpacketLength(b []byte) (int, int) {
+	switch {
+	case b[0] < 192:
+		return int(b[0]), 1
+	case b[0] < 255 && len(b) > 1:
+		return (int(b[0])-192)<<8 + int(b[1]) + 192, 2
+	case b[0] == 255 && len(b) > 4:
+		return int(binary.BigEndian.Uint32(b[1:5])), 5
+	}
+	return 0, 0
+}
+
+// decrypt decrypts the secret key material and creates the matching signer.
+func (pk *pgpPublicKey) decrypt(passphrase []byte) error {
+	if len(pk.secret) == 0 {
+		return ErrInvalidPGPKey
+	}
+
+	usage, data := pk.secret[0], pk.secret[1:]
+	switch usage {
+	case 0:
+	case 254, 255:
+		plain, err := pgpDecryptSecret(data, passphrase)
+		if err != nil {
+			return err
+		}
+		if usage == 254 {
+			if len(plain) < sha1.Size {
+				return ErrInvalidPGPKey
+			}
+			sum := sha1.Sum(plain[:len(plain)-sha1.Size]) //nolint:gosec
+			if !bytes.Equal(sum[:], plain[len(plain)-sha1.Size:]) {
+				return ErrInvalidPGPPassphrase
+			}
+			data = plain[:len(plain)-sha1.Size]
+		} else {
+			data = plain
+		}
+	default:
+		return ErrUnsupportedPGPKey
+	}
+
+	r := &pgpReader{b: data}
+	switch pk.algo {
+	case pgpAlgoRSA, pgpAlgoRSASignOnly:
+		d, p, q := r.mpi(), r.mpi(), r.mpi()
+		if r.err != nil {
+			return r.err
+		}
+		priv := &rsa.PrivateKey{
+			PublicKey: *pk.rsa,
+			D:         new(big.Int).SetBytes(d),
+			Primes:    []*big.Int{new(big.Int).SetBytes(p), new(big.Int).SetBytes(q)},
+		}
+		if err := priv.Validate(); err != nil {
+			return ErrInvalidPGPPassphrase
+		}
+		priv.Precompute()
+		pk.signer = priv
+	case pgpAlgoEdDSA:
+		seed := r.mpi()
+		if r.err != nil || len(seed) > ed25519.SeedSize {
+			return ErrInvalidPGPKey
+		}
+		seed = append(make([]byte, ed25519.SeedSize-len(seed)), seed...)
+		priv := ed25519.NewKeyFromSeed(seed)
+		if !bytes.Equal(priv.Public().(ed25519.PublicKey), pk.ed25519) {
+			return ErrInvalidPGPPassphrase
+		}
+		pk.signer = priv
+	}
+
+	return nil
+}
+
+// pgpDecryptSecret decrypts secret key material protected with a string to
+// key specifier (RFC 4880, section 3.7).
+func pgpDecryptSecret(data, passphrase []byte) ([]byte, error) {
+	if len(data) < 2 {
+		return nil, ErrInvalidPGPKey
+	}
+	keyLen := pgpCipherKeySize(data[0])
+	if keyLen == 0 {
+		return nil, ErrUnsupportedPGPKey
+	}
+
+	s2kType, data := data[1], data[2:]
+	if len(data) < 1 {
+		return nil, ErrInvalidPGPKey
+	}
+	newHash := pgpHash(data[0])
+	if newHash == nil {
+		return nil, ErrUnsupportedPGPKey
+	}
+	data = data[1:]
+
+	var salt []byte
+	count := 0
+	switch s2kType {
+	case 0:
+	case 1, 3:
+		if len(data) < 8 {
+			return nil, ErrInvalidPGPKey
+		}
+		salt, data = data[:8], data[8:]
+		if s2kType == 3 {
+			if len(data) < 1 {
+				return nil, ErrInvalidPGPKey
+			}
+			c := int(data[0])
+			count = (16 + c&15) << (uint(c>>4) + 6)
+			data = data[1:]
+		}
+	default:
+		return nil, ErrUnsupportedPGPKey
+	}
+
+	key := pgpS2K(newHash, passphrase, salt, count, keyLen)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < block.BlockSize() {
+		return nil, ErrInvalidPGPKey
+	}
+	iv, data := data[:block.BlockSize()], data[block.BlockSize():]
+	plain := make([]byte, len(data))
+	cipher.NewCFBDecrypter(block, iv).XORKeyStream(plain, data) //nolint:staticcheck // OpenPGP uses CFB.
+
+	return plain, nil
+}
+
+func pgpS2K(newHash func() hash.Hash, passphrase, salt []byte, count, keyLen int) []byte {
+	input := append(append([]byte{}, salt...), passphrase...)
+	if count < len(input) {
+		count = len(input)
+	}
+
+	var key []byte
+	for i := 0; len(key) < keyLen; i++ {
+		h := newHash()
+		h.Write(make([]byte, i))
+		for n := count; n > 0; n -= len(input) {
+			if n < len(input) {
+				h.Write(input[:n])
+				break
+			}
+			h.Write(input)
+		}
+		key = h.Sum(key)
+	}
+
+	return key[:keyLen]
+}
+
+func pgpCipherKeySize(algo byte) int {
+	switch algo {
+	case pgpCipherAES128:
+		return 16
+	case pgpCipherAES192:
+		return 24
+	case pgpCipherAES256:
+		return 32
+	}
+	return 0
+}
+
+func pgpHash(algo byte) func() hash.Hash {
+	if h := pgpCryptoHash(algo); h != 0 {
+		return h.New
+	}
+	return nil
+}
+
+func pgpCryptoHash(algo byte) crypto.Hash {
+	switch algo {
+	case pgpHashSHA1:
+		return crypto.SHA1
+	case pgpHashSHA256:
+		return crypto.SHA256
+	case pgpHashSHA384:
+		return crypto.SHA384
+	case pgpHashSHA512:
+		return crypto.SHA512
+	}
+	return 0
+}
+
+// pgpSign returns a binary detached signature of data (RFC 4880, section
+// 5.2) made with SHA-256.
+func pgpSign(pk *pgpPublicKey, data []byte) ([]byte, error) {
+	created := make([]byte, 4)
+	binary.BigEndian.PutUint32(created, uint32(now().Unix()))
+
+	var hashed bytes.Buffer
+	pgpWriteSubpacket(&hashed, 2, created)
+	pgpWriteSubpacket(&hashed, 33, append([]byte{4}, pk.fingerprint()...))
+
+	sig := []byte{4, 0x00, pk.algo, pgpHashSHA256, byte(hashed.Len() >> 8), byte(hashed.Len())}
+	sig = append(sig, hashed.Bytes()...)
+
+	h := sha256.New()
+	h.Write(data)
+	h.Write(sig)
+	h.Write([]byte{4, 0xff})
+	binary.Write(h, binary.BigEndian, uint32(len(sig)))
+	digest := h.Sum(nil)
+
+	var unhashed bytes.Buffer
+	pgpWriteSubpacket(&unhashed, 16, pk.keyID())
+	sig = append(sig, byte(unhashed.Len()>>8), byte(unhashed.Len()))
+	sig = append(sig, unhashed.Bytes()...)
+	sig = append(sig, digest[:2]...)
+
+	switch pk.algo {
+	case pgpAlgoEdDSA:
+		s, err := pk.signer.Sign(rand.Reader, digest, crypto.Hash(0))
+		if err != nil {
+			return nil, err
+		}
+		sig = pgpAppendMPI(sig, s[:32])
+		sig = pgpAppendMPI(sig, s[32:])
+	default:
+		s, err := pk.signer.Sign(rand.Reader, digest, crypto.SHA256)
+		if err != nil {
+			return nil, err
+		}
+		sig = pgpAppendMPI(sig, s)
+	}
+
+	var buf bytes.Buffer
+	pgpWritePacket(&buf, pgpTagSignature, sig)
+	return buf.Bytes(), nil
+}
+
+// pgpEncrypt returns a binary OpenPGP message holding data in a literal data
+// packet, encrypted with AES-256 for the given keys.
+func pgpEncrypt(keys []*pgpPublicKey, data []byte) ([]byte, error) {
+	sessionKey := make([]byte, 32)
+	if _, err := rand.Read(sessionKey); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	for _, pk := range keys {
+		pkesk, err := pgpEncryptSessionKey(pk, sessionKey)
+		if err != nil {
+			return nil, err
+		}
+		pgpWritePacket(&buf, pgpTagPKESK, pkesk)
+	}
+
+	var literal bytes.Buffer
+	pgpWritePacket(&literal, pgpTagLiteralData, append([]byte{'b', 0, 0, 0, 0, 0}, data...))
+
+	block, err := aes.NewCipher(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, block.BlockSize()+2)
+	if _, err := rand.Read(prefix[:block.BlockSize()]); err != nil {
+		return nil, err
+	}
+	copy(prefix[block.BlockSize():], prefix[block.BlockSize()-2:block.BlockSize()])
+
+	// The modification detection code covers the prefix, the plaintext and
+	// the MDC packet header (RFC 4880, section 5.13).
+	plain := append(prefix, literal.Bytes()...)
+	plain = append(plain, 0xc0|pgpTagMDC, sha1.Size)
+	mdc := sha1.Sum(plain) //nolint:gosec
+	plain = append(plain, mdc[:]...)
+
+	encrypted := make([]byte, 1+len(plain))
+	encrypted[0] = 1
+	cipher.NewCFBEncrypter(block, make([]byte, block.BlockSize())).XORKeyStream(encrypted[1:], plain) //nolint:staticcheck
+	pgpWritePacket(&buf, pgpTagSEIPD, encrypted)
+
+	return buf.Bytes(), nil
+}
+
+// pgpEncryptSessionKey returns the body of a public-key encrypted session key
+// packet (RFC 4880, section 5.1).
+func pgpEncryptSessionKey(pk *pgpPublicKey, sessionKey []byte) ([]byte, error) {
+	m := append([]byte{pgpCipherAES256}, sessionKey...)
+	var sum uint16
+	for _, b := range sessionKey {
+		sum += uint16(b)
+	}
+	m = append(m, byte(sum>>8), byte(sum))
+
+	out := append([]byte{3}, pk.keyID()...)
+	out = append(out, pk.algo)
+
+	switch {
+	case pk.rsa != nil:
+		c, err := rsa.EncryptPKCS1v15(rand.Reader, pk.rsa, m)
+		if err != nil {
+			return nil, err
+		}
+		return pgpAppendMPI(out, c), nil
+	case pk.x25519 != nil:
+		return pgpAppendECDH(out, pk, m)
+	}
+
+	return nil, ErrUnsupportedPGPKey
+}
+
+// pgpAppendECDH wraps m for a Curve25519 key as described in RFC 6637.
+func pgpAppendECDH(out []byte, pk *pgpPublicKey, m []byte) ([]byte, error) {
+	newHash := pgpHash(pk.kdfHash)
+	keyLen := pgpCipherKeySize(pk.kdfAlgo)
+	if newHash == nil || keyLen == 0 {
+		return nil, ErrUnsupportedPGPKey
+	}
+
+	remote, err := ecdh.X25519().NewPublicKey(pk.x25519)
+	if err != nil {
+		return nil, ErrInvalidPGPKey
+	}
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := ephemeral.ECDH(remote)
+	if err != nil {
+		return nil, ErrInvalidPGPKey
+	}
+
+	param := append([]byte{byte(len(oidCurve25519))}, oidCurve25519...)
+	param = append(param, pgpAlgoECDH, 3, 1, pk.kdfHash, pk.kdfAlgo)
+	param = append(param, "Anonymous Sender    "...)
+	param = append(param, pk.fingerprint()...)
+
+	h := newHash()
+	h.Write([]byte{0, 0, 0, 1})
+	h.Write(shared)
+	h.Write(param)
+	kek := h.Sum(nil)[:keyLen]
+
+	// The session key is padded to a multiple of 8 bytes (PKCS #5).
+	pad := 8 - len(m)%8
+	for i := 0; i < pad; i++ {
+		m = append(m, byte(pad))
+	}
+	wrapped, err := aesKeyWrap(kek, m)
+	if err != nil {
+		return nil, err
+	}
+
+	out = pgpAppendMPI(out, append([]byte{0x40}, ephemeral.PublicKey().Bytes()...))
+	out = append(out, byte(len(wrapped)))
+	return append(out, wrapped...), nil
+}
+
+type pgpReader struct {
+	b   []byte
+	err error
+}
+
+func (r *pgpReader) mpi() []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.b) < 2 {
+		r.err = ErrInvalidPGPKey
+		return nil
+	}
+	n := (int(binary.BigEndian.Uint16(r.b)) + 7) / 8
+	if len(r.b) < 2+n {
+		r.err = ErrInvalidPGPKey
+		return nil
+	}
+	v := r.b[2 : 2+n]
+	r.b = r.b[2+n:]
+	return v
+}
+
+// oid reads a field prefixed by its one-octet length, such as a curve OID or
+// KDF parameters.
+func (r *pgpReader) oid() []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.b) < 1 || len(r.b) < 1+int(r.b[0]) {
+		r.err = ErrInvalidPGPKey
+		return nil
+	}
+	v := r.b[1 : 1+int(r.b[0])]
+	r.b = r.b[1+int(r.b[0]):]
+	return v
+}
+
+func pgpAppendMPI(out, v []byte) []byte {
+	v = bytes.TrimLeft(v, "\x00")
+	bits := len(v) * 8
+	if len(v) > 0 {
+		for b := v[0]; b&0x80 == 0; b <<= 1 {
+			bits--
+		}
+	}
+	out = append(out, byte(bits>>8), byte(bits))
+	return append(out, v...)
+}
+
+func pgpWriteSubpacket(buf *bytes.Buffer, typ byte, data []byte) {
+	buf.WriteByte(byte(len(data) + 1))
+	buf.WriteByte(typ)
+	buf.Write(data)
+}
+
+// pgpWritePacket writes a packet with a new format header.
+func pgpWritePacket(buf *bytes.Buffer, tag byte, body []byte) {
+	buf.WriteByte(0xc0 | tag)
+	switch n := len(body); {
+	case n < 192:
+		buf.WriteByte(byte(n))
+	case n < 8384:
+		n -= 192
+		buf.WriteByte(byte(n>>8) + 192)
+		buf.WriteByte(byte(n))
+	default:
+		buf.WriteByte(255)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+	buf.Write(body)
+}
+
+// pgpReadPackets splits data into packets. Partial body lengths are not
+// supported as they are not used by keys.
+func pgpReadPackets(data []byte) ([]pgpPacket, error) {
+	var packets []pgpPacket
+	for len(data) > 0 {
+		ctb := data[0]
+		if ctb&0x80 == 0 {
+			return nil, ErrInvalidPGPKey
+		}
+
+		var tag byte
+		var n, hl int
+		if ctb&0x40 != 0 {
+			tag = ctb & 0x3f
+			if len(data) < 2 {
+				return nil, ErrInvalidPGPKey
+			}
+			switch b := data[1]; {
+			case b < 192:
+				n, hl = int(b), 2
+			case b < 224 && len(data) > 2:
+				n, hl = (int(b)-192)<<8+int(data[2])+192, 3
+			case b == 255 && len(data) > 5:
+				n, hl = int(binary.BigEndian.Uint32(data[2:6])), 6
+			default:
+				return nil, ErrInvalidPGPKey
+			}
+		} else {
+			tag = (ctb >> 2) & 0x0f
+			switch ctb & 3 {
+			case 0:
+				if len(data) > 1 {
+					n, hl = int(data[1]), 2
+				}
+			case 1:
+				if len(data) > 2 {
+					n, hl = int(binary.BigEndian.Uint16(data[1:3])), 3
+				}
+			case 2:
+				if len(data) > 4 {
+					n, hl = int(binary.BigEndian.Uint32(data[1:5])), 5
+				}
+			}
+			if hl == 0 {
+				return nil, ErrInvalidPGPKey
+			}
+		}
+
+		if n < 0 || len(data) < hl+n {
+			return nil, ErrInvalidPGPKey
+		}
+		packets = append(packets, pgpPacket{tag, data[hl : hl+n]})
+		data = data[hl+n:]
+	}
+
+	return packets, nil
+}
+
+// pgpArmor encodes data with the OpenPGP ASCII armor (RFC 4880, section 6).
+func pgpArmor(blockType string, data []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("-----BEGIN " + blockType + "-----\r\n\r\n")
+	b64 := base64.StdEncoding.EncodeToString(data)
+	for len(b64) > 64 {
+		buf.WriteString(b64[:64] + "\r\n")
+		b64 = b64[64:]
+	}
+	buf.WriteString(b64 + "\r\n")
+
+	crc := pgpCRC24(data)
+	buf.WriteString("=" + base64.StdEncoding.EncodeToString([]byte{byte(crc >> 16), byte(crc >> 8), byte(crc)}) + "\r\n")
+	buf.WriteString("-----END " + blockType + "-----\r\n")
+
+	return buf.Bytes()
+}
+
+// pgpDearmor decodes the first armored block of data.
+func pgpDearmor(data []byte) ([]byte, error) {
+	s := bufio.NewScanner(bytes.NewReader(data))
+	var b64 strings.Builder
+	state := 0
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		switch state {
+		case 0:
+			if strings.HasPrefix(line, "-----BEGIN PGP") {
+				state = 1
+			}
+		case 1:
+			// Skip the armor headers.
+			if line == "" {
+				state = 2
+			}
+		case 2:
+			if !strings.HasPrefix(line, "-----END PGP") && !strings.HasPrefix(line, "=") {
+				b64.WriteString(line)
+				continue
+			}
+			decoded, err := base64.StdEncoding.DecodeString(b64.String())
+			if err != nil {
+				return nil, ErrInvalidPGPKey
+			}
+			// The checksum is optional (RFC 4880, section 6.2).
+			if strings.HasPrefix(line, "=") {
+				sum, err := base64.StdEncoding.DecodeString(line[1:])
+				if err != nil || len(sum) != 3 {
+					return nil, ErrInvalidPGPKey
+				}
+				if uint32(sum[0])<<16|uint32(sum[1])<<8|uint32(sum[2]) != pgpCRC24(decoded) {
+					return nil, ErrInvalidPGPKey
+				}
+			}
+			return decoded, nil
+		}
+	}
+
+	return nil, ErrInvalidPGPKey
+}
+
+func pgpCRC24(data []byte) uint32 {
+	crc := uint32(0xb704ce)
+	for _, b := range data {
+		crc ^= uint32(b) << 16
+		for i := 0; i < 8; i++ {
+			crc <<= 1
+			if crc&0x1000000 != 0 {
+				crc ^= 0x1864cfb
+			}
+		}
+	}
+	return crc & 0xffffff
+}
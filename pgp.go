@@ -0,0 +1,227 @@
+package gomail
+
+import (
+	"bytes"
+	"encoding/base64"
+	"mime/multipart"
+	"strings"
+)
+
+// A PGPKeyLookup finds the OpenPGP key of a recipient.
+type PGPKeyLookup interface {
+	// LookupPGPKey returns the key of the given email address, or nil if the
+	// key is unknown.
+	LookupPGPKey(address string) (*PGPKey, error)
+}
+
+// A PGPKeyRing is a list of keys implementing PGPKeyLookup. Addresses are
+// matched against the user IDs of the keys.
+type PGPKeyRing []*PGPKey
+
+// LookupPGPKey implements PGPKeyLookup.
+func (r PGPKeyRing) LookupPGPKey(address string) (*PGPKey, error) {
+	for _, k := range r {
+		for _, addr := range k.Addresses() {
+			if strings.EqualFold(addr, address) {
+				return k, nil
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// A PGPSigner signs messages with PGP/MIME (RFC 3156). The signed message is a
+// multipart/signed entity holding the original MIME body and a detached
+// OpenPGP signature.
+type PGPSigner struct {
+	key *PGPKey
+}
+
+// NewPGPSigner returns a PGPSigner using the secret key of k. Protected keys
+// must be decrypted with PGPKey.Decrypt first.
+func NewPGPSigner(k *PGPKey) (*PGPSigner, error) {
+	if k == nil || k.signingKey() == nil {
+		return nil, ErrPGPSecretKeyAbsent
+	}
+
+	return &PGPSigner{key: k}, nil
+}
+
+// SetPGPSigning is a message setting to sign the message with s when it is
+// written.
+func SetPGPSigning(s *PGPSigner) MessageSetting {
+	return func(m *Message) {
+		m.pgpSigner = s
+	}
+}
+
+// Sign returns msg, a complete RFC 5322 message, with its MIME body replaced
+// by a multipart/signed entity.
+func (s *PGPSigner) Sign(msg []byte) ([]byte, error) {
+	header, entity := splitEntity(normalizeCRLF(msg))
+
+	sig, err := pgpSign(s.key.signingKey(), entity)
+	if err != nil {
+		return nil, err
+	}
+
+	boundary := multipart.NewWriter(nil).Boundary()
+
+	var buf bytes.Buffer
+	for _, f := range header {
+		buf.WriteString(f)
+	}
+	buf.WriteString("Content-Type: multipart/signed; protocol=\"application/pgp-signature\";\r\n" +
+		" micalg=pgp-sha256; boundary=" + boundary + "\r\n\r\n")
+	buf.WriteString("--" + boundary + "\r\n")
+	buf.Write(entity)
+	buf.WriteString("\r\n--" + boundary + "\r\n")
+	buf.WriteString("Content-Type: application/pgp-signature; name=\"signature.asc\"\r\n" +
+		"Content-Disposition: attachment; filename=\"signature.asc\"\r\n\r\n")
+	buf.Write(pgpArmor("PGP SIGNATURE", sig))
+	buf.WriteString("\r\n--" + boundary + "--\r\n")
+
+	return buf.Bytes(), nil
+}
+
+// A PGPEncrypter encrypts messages with PGP/MIME (RFC 3156) for their
+// recipients, using AES-256 as the session key algorithm.
+type PGPEncrypter struct {
+	lookup PGPKeyLookup
+	extra  []*PGPKey
+}
+
+// NewPGPEncrypter returns a PGPEncrypter looking up the keys of the
+// recipients with lookup.
+func NewPGPEncrypter(lookup PGPKeyLookup) *PGPEncrypter {
+	return &PGPEncrypter{lookup: lookup}
+}
+
+// AddKey adds a key the messages are always encrypted for, whatever their
+// recipients are. It is typically the key of the sender so that the sent
+// messages can be read again.
+func (e *PGPEncrypter) AddKey(k *PGPKey) {
+	e.extra = append(e.extra, k)
+}
+
+// SetPGPEncryption is a message setting to encrypt the message with e when it
+// is written. Writing the message fails with a MissingCertificateError when
+// the key of a recipient is unknown. When the message is also signed with
+// SetPGPSigning, it is signed before being encrypted.
+func SetPGPEncryption(e *PGPEncrypter) MessageSetting {
+	return func(m *Message) {
+		m.pgpEncrypter = e
+	}
+}
+
+// Encrypt returns msg, a complete RFC 5322 message, with its MIME body
+// replaced by a multipart/encrypted entity readable by the given recipients.
+func (e *PGPEncrypter) Encrypt(msg []byte, recipients []string) ([]byte, error) {
+	keys := make([]*pgpPublicKey, 0, len(recipients)+len(e.extra))
+	for _, addr := range recipients {
+		k, err := e.lookup.LookupPGPKey(addr)
+		if err != nil {
+			return nil, err
+		}
+		var pk *pgpPublicKey
+		if k != nil {
+			pk = k.encryptionKey()
+		}
+		if pk == nil {
+			return nil, &MissingCertificateError{Address: addr}
+		}
+		keys = addPGPKey(keys, pk)
+	}
+	for _, k := range e.extra {
+		pk := k.encryptionKey()
+		if pk == nil {
+			return nil, ErrUnsupportedPGPKey
+		}
+		keys = addPGPKey(keys, pk)
+	}
+
+	header, entity := splitEntity(normalizeCRLF(msg))
+
+	encrypted, err := pgpEncrypt(keys, entity)
+	if err != nil {
+		return nil, err
+	}
+
+	boundary := multipart.NewWriter(nil).Boundary()
+
+	var buf bytes.Buffer
+	for _, f := range header {
+		buf.WriteString(f)
+	}
+	buf.WriteString("Content-Type: multipart/encrypted; protocol=\"application/pgp-encrypted\";\r\n" +
+		" boundary=" + boundary + "\r\n\r\n")
+	buf.WriteString("--" + boundary + "\r\n")
+	buf.WriteString("Content-Type: application/pgp-encrypted\r\n" +
+		"Content-Description: PGP/MIME version identification\r\n\r\n" +
+		"Version: 1\r\n")
+	buf.WriteString("\r\n--" + boundary + "\r\n")
+	buf.WriteString("Content-Type: application/octet-stream; name=\"encrypted.asc\"\r\n" +
+		"Content-Description: OpenPGP encrypted message\r\n" +
+		"Content-Disposition: inline; filename=\"encrypted.asc\"\r\n\r\n")
+	buf.Write(pgpArmor("PGP MESSAGE", encrypted))
+	buf.WriteString("\r\n--" + boundary + "--\r\n")
+
+	return buf.Bytes(), nil
+}
+
+func addPGPKey(list []*pgpPublicKey, k *pgpPublicKey) []*pgpPublicKey {
+	for _, l := range list {
+		if l == k {
+			return list
+		}
+	}
+
+	return append(list, k)
+}
+
+// An Autocrypt holds the key advertised in the Autocrypt header of messages
+// (https://autocrypt.org/level1.html).
+type Autocrypt struct {
+	key           *PGPKey
+	preferEncrypt bool
+}
+
+// SetAutocrypt is a message setting to add an Autocrypt header advertising
+// the public key k of the sender. preferEncrypt sets prefer-encrypt=mutual,
+// telling the clients of the recipients to encrypt their replies by default.
+func SetAutocrypt(k *PGPKey, preferEncrypt bool) MessageSetting {
+	return func(m *Message) {
+		m.autocrypt = &Autocrypt{key: k, preferEncrypt: preferEncrypt}
+	}
+}
+
+func (w *messageWriter) writeAutocrypt(m *Message) {
+	if m.autocrypt == nil {
+		return
+	}
+	if _, ok := m.header["Autocrypt"]; ok {
+		return
+	}
+
+	addr, err := m.getFirstAddress("From")
+	if err != nil {
+		w.err = err
+		return
+	}
+
+	value := "addr=" + addr + ";"
+	if m.autocrypt.preferEncrypt {
+		value += " prefer-encrypt=mutual;"
+	}
+	value += " keydata="
+	// Whitespace is ignored in keydata, which lets the header be folded.
+	keydata := base64.StdEncoding.EncodeToString(m.autocrypt.key.publicKey())
+	for len(keydata) > 72 {
+		value += keydata[:72] + " "
+		keydata = keydata[72:]
+	}
+	value += keydata
+
+	w.writeString(foldHeaderValue("Autocrypt", value))
+}
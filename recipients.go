@@ -0,0 +1,215 @@
+package gomail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// RecipientPolicy constants are valid values for Dialer.RecipientPolicy.
+type RecipientPolicy int
+
+const (
+	// AllRecipients means that a message is only sent if the SMTP server
+	// accepts all its recipients. This is the default setting. When the
+	// recipients are split into several transactions, it applies to each of
+	// them.
+	AllRecipients RecipientPolicy = iota
+	// AcceptedRecipients means that a message is sent to the recipients
+	// accepted by the SMTP server, even if others are rejected. It is only
+	// aborted when all of them are rejected.
+	AcceptedRecipients
+)
+
+func (policy *RecipientPolicy) String() string {
+	switch *policy {
+	case AllRecipients:
+		return "AllRecipients"
+	case AcceptedRecipients:
+		return "AcceptedRecipients"
+	default:
+		return fmt.Sprintf("RecipientPolicy:%v", *policy)
+	}
+}
+
+// A SendResult lists the recipients of a message accepted and rejected by the
+// SMTP server.
+type SendResult struct {
+	Accepted []string
+	Rejected []RejectedRecipient
+	// Batches lists the transactions of a message whose recipients were
+	// split, either because of Dialer.MaxRecipientsPerMessage or because the
+	// server replied that there were too many of them. It is empty when the
+	// message was sent in a single transaction.
+	Batches []BatchResult
+	// Skipped lists the recipients which were not tried, as a transaction
+	// failed before their RCPT command.
+	Skipped []string
+}
+
+// A BatchResult is the outcome of one of the transactions of a message.
+type BatchResult struct {
+	Recipients []string
+	// Err is the error of the transaction, or nil if the message was sent to
+	// all its accepted recipients.
+	Err error
+}
+
+// A RejectedRecipient is a recipient rejected by the SMTP server.
+type RejectedRecipient struct {
+	Address string
+	// Err is the reply of the server to the RCPT command, holding its reply
+	// codes.
+	Err *SMTPError
+}
+
+// add adds the recipient to and the error of its RCPT command to r. It
+// returns err if it is not a reply of the server.
+func (r *SendResult) add(to string, err error) error {
+	var smtpErr *SMTPError
+	switch {
+	case err == nil:
+		r.Accepted = append(r.Accepted, to)
+	case errors.As(err, &smtpErr):
+		r.Rejected = append(r.Rejected, RejectedRecipient{Address: to, Err: smtpErr})
+	default:
+		return err
+	}
+	return nil
+}
+
+// recipientsError returns the RecipientsError of the recipients rejected in
+// result, or nil if all of them were accepted. The message can still be sent
+// if Sent is set.
+func (c *smtpSender) recipientsError(result SendResult) *RecipientsError {
+	if len(result.Rejected) == 0 {
+		return nil
+	}
+	return &RecipientsError{
+		Result: result,
+		Sent:   c.d.RecipientPolicy == AcceptedRecipients && len(result.Accepted) > 0,
+	}
+}
+
+// tooManyRecipients reports whether err is the reply of the server to a RCPT
+// command exceeding its number of recipients per transaction (RFC 5321,
+// section 4.5.3.1.10), in which case the recipient can be sent the message in
+// another transaction.
+func tooManyRecipients(err error) bool {
+	var smtpErr *SMTPError
+	if !errors.As(err, &smtpErr) || smtpErr.Code != 452 {
+		return false
+	}
+	return smtpErr.EnhancedCode == "" || smtpErr.EnhancedCode == "4.5.3"
+}
+
+// without returns a copy of to without the recipients in excluded.
+func without(to, excluded []string) []string {
+	skip := make(map[string]bool, len(excluded))
+	for _, addr := range excluded {
+		skip[addr] = true
+	}
+	list := make([]string, 0, len(to))
+	for _, addr := range to {
+		if !skip[addr] {
+			list = append(list, addr)
+		}
+	}
+	return list
+}
+
+// untried returns the recipients of to which are neither accepted nor
+// rejected in r.
+func (r *SendResult) untried(to []string) []string {
+	tried := make([]string, 0, len(r.Accepted)+len(r.Rejected))
+	tried = append(tried, r.Accepted...)
+	for _, rejected := range r.Rejected {
+		tried = append(tried, rejected.Address)
+	}
+	return without(to, tried)
+}
+
+// batchesError returns the error of a message sent in several transactions,
+// or nil if it was sent to all its recipients. skipped lists the recipients
+// which were not tried.
+func (c *smtpSender) batchesError(result SendResult, skipped []string) error {
+	sent := false
+	failed := len(result.Rejected) > 0 || len(skipped) > 0
+	for _, b := range result.Batches {
+		var recipientsErr *RecipientsError
+		if b.Err == nil || errors.As(b.Err, &recipientsErr) && recipientsErr.Sent {
+			sent = true
+		}
+		if b.Err != nil {
+			failed = true
+		}
+	}
+	if !failed {
+		return nil
+	}
+	if len(skipped) > 0 {
+		result.Skipped = skipped
+	}
+	return &RecipientsError{Result: result, Sent: sent}
+}
+
+// failedBatch returns the first transaction of r which failed, if any.
+func (r *SendResult) failedBatch() *BatchResult {
+	for i := range r.Batches {
+		var recipientsErr *RecipientsError
+		if err := r.Batches[i].Err; err != nil && !errors.As(err, &recipientsErr) {
+			return &r.Batches[i]
+		}
+	}
+	return nil
+}
+
+// replayable reports whether msg can be written again to send it in another
+// transaction, as a Message renders its content every time it is written.
+func replayable(msg io.WriterTo) bool {
+	switch msg.(type) {
+	case *Message, downgradedMessage, *resentMessage:
+		return true
+	default:
+		return false
+	}
+}
+
+// replayableMessage is a message which can be written several times, to send
+// it in several transactions. Its content is only kept in memory when it is
+// written with keep set, as another transaction is needed.
+type replayableMessage struct {
+	msg      io.WriterTo
+	buf      bytes.Buffer
+	keep     bool
+	buffered bool
+}
+
+func newReplayableMessage(msg io.WriterTo) *replayableMessage {
+	return &replayableMessage{msg: msg}
+}
+
+func (m *replayableMessage) WriteTo(w io.Writer) (int64, error) {
+	if m.buffered {
+		return bytes.NewReader(m.buf.Bytes()).WriteTo(w)
+	}
+	if !m.keep {
+		return m.msg.WriteTo(w)
+	}
+
+	m.buf.Reset()
+	n, err := m.msg.WriteTo(io.MultiWriter(w, &m.buf))
+	m.buffered = err == nil
+	return n, err
+}
+
+// content returns the content of t, to be written in the transaction. It is
+// kept in memory if it cannot be written again and another transaction is
+// needed for the recipients deferred or remaining after t.
+func (t *transaction) content(deferred []string) io.WriterTo {
+	if m, ok := t.msg.(*replayableMessage); ok && (t.more || len(deferred) > 0) {
+		m.keep = true
+	}
+	return t.msg
+}
@@ -0,0 +1,391 @@
+package gomail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRecipientPolicy(t *testing.T) {
+	const (
+		rcpts = "RCPT TO:<a@example.com>\nRCPT TO:<b@example.com>\nRCPT TO:<c@example.com>\n"
+		sent  = "\n.\nQUIT\n"
+	)
+
+	tests := []struct {
+		name       string
+		extensions string
+		policy     RecipientPolicy
+		replies    []string
+		commands   string
+		sent       bool
+		accepted   []string
+		rejected   []string
+	}{
+		{
+			name:       "AllRecipients",
+			extensions: "250 8BITMIME\n",
+			replies:    []string{"250 OK\n", "250 OK\n", "550 5.1.1 No such user\n", "250 OK\n", "250 Reset\n", "221 Bye\n"},
+			commands:   rcpts + "RSET\nQUIT\n",
+			accepted:   []string{"a@example.com", "c@example.com"},
+			rejected:   []string{"b@example.com"},
+		},
+		{
+			name:       "AcceptedRecipients",
+			extensions: "250 8BITMIME\n",
+			policy:     AcceptedRecipients,
+			replies:    []string{"250 OK\n", "250 OK\n", "550 5.1.1 No such user\n", "250 OK\n", "354 Go ahead\n", "250 Queued\n", "221 Bye\n"},
+			commands:   sent,
+			sent:       true,
+			accepted:   []string{"a@example.com", "c@example.com"},
+			rejected:   []string{"b@example.com"},
+		},
+		{
+			name:       "AcceptedRecipientsPipelined",
+			extensions: "250 PIPELINING\n",
+			policy:     AcceptedRecipients,
+			replies:    []string{"250 OK\n", "550 5.1.1 No such user\n", "250 OK\n", "450 4.2.0 Greylisted\n", "354 Go ahead\n", "250 Queued\n", "221 Bye\n"},
+			commands:   sent,
+			sent:       true,
+			accepted:   []string{"b@example.com"},
+			rejected:   []string{"a@example.com", "c@example.com"},
+		},
+		{
+			name:       "NoneAccepted",
+			extensions: "250 8BITMIME\n",
+			policy:     AcceptedRecipients,
+			replies:    []string{"250 OK\n", "550 No\n", "550 No\n", "550 No\n", "250 Reset\n", "221 Bye\n"},
+			commands:   rcpts + "RSET\nQUIT\n",
+			rejected:   []string{"a@example.com", "b@example.com", "c@example.com"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := newLineSMTPConn(append([]string{
+				"220 mx.example.com\n",
+				"250-mx.example.com\n" + tt.extensions,
+			}, tt.replies...)...)
+			useSMTPConn(t, conn)
+
+			m := NewMessage()
+			m.SetHeader("From", "from@example.com")
+			m.SetHeader("To", "a@example.com", "b@example.com", "c@example.com")
+			m.SetBody("text/plain", "Test message")
+
+			d := NewDialer("localhost", testPort, "", "")
+			d.StartTLSPolicy = NoStartTLS
+			d.RecipientPolicy = tt.policy
+			err := d.DialAndSend(context.Background(), m)
+
+			var sendErr *SendError
+			if !errors.As(err, &sendErr) || sendErr.Result == nil {
+				t.Fatalf("got error %v, want a SendError with a result", err)
+			}
+			var recipientsErr *RecipientsError
+			if !errors.As(err, &recipientsErr) {
+				t.Fatalf("got error %v, want a RecipientsError", err)
+			}
+			if !errors.Is(err, &SMTPError{}) {
+				t.Errorf("error %v does not wrap the SMTPError", err)
+			}
+			if recipientsErr.Sent != tt.sent {
+				t.Errorf("got Sent %v, want %v", recipientsErr.Sent, tt.sent)
+			}
+			if !reflect.DeepEqual(*sendErr.Result, recipientsErr.Result) {
+				t.Errorf("the result of the SendError differs from the one of the RecipientsError")
+			}
+
+			result := recipientsErr.Result
+			if !reflect.DeepEqual(result.Accepted, tt.accepted) {
+				t.Errorf("got accepted recipients %v, want %v", result.Accepted, tt.accepted)
+			}
+			var rejected []string
+			for _, r := range result.Rejected {
+				rejected = append(rejected, r.Address)
+				if r.Err == nil || r.Err.Code/100 != 4 && r.Err.Code/100 != 5 {
+					t.Errorf("invalid error for %s: %v", r.Address, r.Err)
+				}
+			}
+			if !reflect.DeepEqual(rejected, tt.rejected) {
+				t.Errorf("got rejected recipients %v, want %v", rejected, tt.rejected)
+			}
+
+			got := conn.commands()
+			if !strings.Contains(got, tt.commands) {
+				t.Errorf("commands do not contain:\n%s\ngot:\n%s", tt.commands, got)
+			}
+			if strings.Contains(got, "Test message") != tt.sent {
+				t.Errorf("message sent: %v, want %v:\n%s", !tt.sent, tt.sent, got)
+			}
+		})
+	}
+}
+
+func TestSendWithResults(t *testing.T) {
+	conn := newLineSMTPConn(
+		"220 mx.example.com\n",
+		"250-mx.example.com\n250 8BITMIME\n",
+		"250 OK\n", "250 OK\n", "550 5.1.1 No such user\n", "354 Go ahead\n", "250 Queued\n",
+		"250 OK\n", "250 OK\n", "354 Go ahead\n", "250 Queued\n",
+		"221 Bye\n",
+	)
+	useSMTPConn(t, conn)
+
+	m1 := NewMessage()
+	m1.SetHeader("From", "from@example.com")
+	m1.SetHeader("To", "a@example.com", "b@example.com")
+	m1.SetBody("text/plain", "Test message")
+	m2 := NewMessage()
+	m2.SetHeader("From", "from@example.com")
+	m2.SetHeader("To", "a@example.com")
+	m2.SetBody("text/plain", "Test message")
+
+	d := NewDialer("localhost", testPort, "", "")
+	d.StartTLSPolicy = NoStartTLS
+	d.RecipientPolicy = AcceptedRecipients
+	s, err := d.Dial(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := SendWithResults(context.Background(), s, m1, m2)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var sendErr *SendError
+	if !errors.As(err, &sendErr) || sendErr.Index != 0 {
+		t.Fatalf("got error %v, want the SendError of the first message", err)
+	}
+	if len(results) != 2 || results[0] == nil || results[1] == nil {
+		t.Fatalf("got results %v, want one per message", results)
+	}
+	if results[0] != sendErr.Result {
+		t.Errorf("the result of the first message is not the one of its SendError")
+	}
+	if want := []string{"a@example.com"}; !reflect.DeepEqual(results[1].Accepted, want) {
+		t.Errorf("got accepted recipients %v, want %v", results[1].Accepted, want)
+	}
+	if len(results[1].Rejected) != 0 || len(results[1].Batches) != 0 {
+		t.Errorf("got result %+v, want no rejected recipients nor batches", *results[1])
+	}
+	if got := strings.Count(conn.commands(), "\n.\n"); got != 2 {
+		t.Errorf("got %d messages sent, want 2", got)
+	}
+}
+
+func TestRecipientsErrorMessage(t *testing.T) {
+	err := &RecipientsError{
+		Result: SendResult{
+			Accepted: []string{"a@example.com"},
+			Rejected: []RejectedRecipient{
+				{Address: "b@example.com", Err: &SMTPError{Code: 550, EnhancedCode: "5.1.1", Message: "No such user"}},
+			},
+		},
+		Sent: true,
+	}
+	want := "gomail: 1 of 2 recipients rejected, message sent to the others: b@example.com: gomail: SMTP error 550 5.1.1: No such user"
+	if got := err.Error(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSplitRecipients(t *testing.T) {
+	const sent = "354 Go ahead\n"
+
+	tests := []struct {
+		name       string
+		extensions string
+		max        int
+		replies    []string
+		rcpts      int
+		batches    [][]string
+		// raw sends a message which can only be written once.
+		raw bool
+	}{
+		{
+			name:       "MaxRecipientsPerMessage",
+			extensions: "250 8BITMIME\n",
+			max:        2,
+			replies:    []string{"250 OK\n", "250 OK\n", "250 OK\n", sent, "250 Queued\n", "250 OK\n", "250 OK\n", sent, "250 Queued\n", "221 Bye\n"},
+			rcpts:      3,
+			batches:    [][]string{{"a@example.com", "b@example.com"}, {"c@example.com"}},
+		},
+		{
+			name:       "TooManyRecipients",
+			extensions: "250 8BITMIME\n",
+			replies:    []string{"250 OK\n", "250 OK\n", "250 OK\n", "452 4.5.3 Too many recipients\n", sent, "250 Queued\n", "250 OK\n", "250 OK\n", sent, "250 Queued\n", "221 Bye\n"},
+			rcpts:      4,
+			batches:    [][]string{{"a@example.com", "b@example.com"}, {"c@example.com"}},
+		},
+		{
+			name:       "TooManyRecipientsPipelined",
+			extensions: "250 PIPELINING\n",
+			replies:    []string{"250 OK\n", "250 OK\n", "250 OK\n", "452 Too many recipients\n", sent, "250 Queued\n", "250 OK\n", "250 OK\n", sent, "250 Queued\n", "221 Bye\n"},
+			rcpts:      4,
+			batches:    [][]string{{"a@example.com", "b@example.com"}, {"c@example.com"}},
+		},
+		{
+			name:       "MaxRecipientsPerMessageRaw",
+			extensions: "250 8BITMIME\n",
+			max:        2,
+			replies:    []string{"250 OK\n", "250 OK\n", "250 OK\n", sent, "250 Queued\n", "250 OK\n", "250 OK\n", sent, "250 Queued\n", "221 Bye\n"},
+			rcpts:      3,
+			batches:    [][]string{{"a@example.com", "b@example.com"}, {"c@example.com"}},
+			raw:        true,
+		},
+		{
+			name:       "TooManyRecipientsRaw",
+			extensions: "250 PIPELINING\n",
+			replies:    []string{"250 OK\n", "250 OK\n", "250 OK\n", "452 Too many recipients\n", sent, "250 Queued\n", "250 OK\n", "250 OK\n", sent, "250 Queued\n", "221 Bye\n"},
+			rcpts:      4,
+			batches:    [][]string{{"a@example.com", "b@example.com"}, {"c@example.com"}},
+			raw:        true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := newLineSMTPConn(append([]string{
+				"220 mx.example.com\n",
+				"250-mx.example.com\n" + tt.extensions,
+			}, tt.replies...)...)
+			useSMTPConn(t, conn)
+
+			m := NewMessage()
+			m.SetHeader("From", "from@example.com")
+			m.SetHeader("To", "a@example.com", "b@example.com", "c@example.com")
+			m.SetBody("text/plain", "Test message")
+
+			d := NewDialer("localhost", testPort, "", "")
+			d.StartTLSPolicy = NoStartTLS
+			d.MaxRecipientsPerMessage = tt.max
+			if tt.raw {
+				s, err := d.Dial(context.Background())
+				if err != nil {
+					t.Fatal(err)
+				}
+				to := []string{"a@example.com", "b@example.com", "c@example.com"}
+				if err := s.Send(context.Background(), "from@example.com", to, &onceMessage{}); err != nil {
+					t.Fatal(err)
+				}
+				if err := s.Close(); err != nil {
+					t.Fatal(err)
+				}
+			} else if err := d.DialAndSend(context.Background(), m); err != nil {
+				t.Fatal(err)
+			}
+
+			got := conn.commands()
+			if n := strings.Count(got, "MAIL FROM:<from@example.com>"); n != len(tt.batches) {
+				t.Errorf("got %d transactions, want %d:\n%s", n, len(tt.batches), got)
+			}
+			if n := strings.Count(got, "RCPT TO:"); n != tt.rcpts {
+				t.Errorf("got %d RCPT commands, want %d:\n%s", n, tt.rcpts, got)
+			}
+			if n := strings.Count(got, "Test message"); n != len(tt.batches) {
+				t.Errorf("message sent %d times, want %d:\n%s", n, len(tt.batches), got)
+			}
+			if strings.Contains(got, "RSET") {
+				t.Errorf("transaction reset:\n%s", got)
+			}
+			if last := tt.batches[len(tt.batches)-1]; !strings.Contains(got, "RCPT TO:<"+last[0]+">\nDATA\n") {
+				t.Errorf("last transaction not sent to %v:\n%s", last, got)
+			}
+		})
+	}
+}
+
+// onceMessage is a raw message which can only be written once.
+type onceMessage struct {
+	written bool
+}
+
+func (m *onceMessage) WriteTo(w io.Writer) (int64, error) {
+	if m.written {
+		return 0, errors.New("message written twice")
+	}
+	m.written = true
+	n, err := io.WriteString(w, "Subject: Test\r\n\r\nTest message\r\n")
+	return int64(n), err
+}
+
+func TestReplayableMessage(t *testing.T) {
+	if !replayable(NewMessage()) || replayable(&onceMessage{}) {
+		t.Error("only a Message should be replayable")
+	}
+
+	m := newReplayableMessage(&onceMessage{})
+	tr := &transaction{msg: m}
+	var buf bytes.Buffer
+	if _, err := tr.content(nil).WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if m.buf.Len() != 0 {
+		t.Error("the content of the last transaction is kept in memory")
+	}
+
+	m = newReplayableMessage(&onceMessage{})
+	tr = &transaction{msg: m}
+	for _, deferred := range [][]string{{"b@example.com"}, nil} {
+		buf.Reset()
+		if _, err := tr.content(deferred).WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(buf.String(), "Test message") {
+			t.Errorf("got content %q", buf.String())
+		}
+	}
+}
+
+func TestSplitRecipientsFailure(t *testing.T) {
+	conn := newLineSMTPConn(
+		"220 mx.example.com\n",
+		"250-mx.example.com\n250 8BITMIME\n",
+		"250 OK\n", "250 OK\n", "250 OK\n", "354 Go ahead\n", "250 Queued\n",
+		"250 OK\n", "250 OK\n", "554 Transaction failed\n",
+		"250 Reset\n", "221 Bye\n",
+	)
+	useSMTPConn(t, conn)
+
+	m := NewMessage()
+	m.SetHeader("From", "from@example.com")
+	m.SetHeader("To", "a@example.com", "b@example.com", "c@example.com")
+	m.SetBody("text/plain", "Test message")
+
+	d := NewDialer("localhost", testPort, "", "")
+	d.StartTLSPolicy = NoStartTLS
+	d.MaxRecipientsPerMessage = 2
+	err := d.DialAndSend(context.Background(), m)
+
+	var recipientsErr *RecipientsError
+	if !errors.As(err, &recipientsErr) {
+		t.Fatalf("got error %v, want a RecipientsError", err)
+	}
+	if !recipientsErr.Sent {
+		t.Error("got Sent false, want true")
+	}
+	var smtpErr *SMTPError
+	if !errors.As(err, &smtpErr) || smtpErr.Code != 554 {
+		t.Errorf("error %v does not wrap the 554 reply", err)
+	}
+	want := "gomail: message not sent to all of its 3 recipients, message sent to the others: gomail: SMTP error 554: Transaction failed"
+	if got := recipientsErr.Error(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	batches := recipientsErr.Result.Batches
+	if len(batches) != 2 {
+		t.Fatalf("got %d batches, want 2", len(batches))
+	}
+	if !reflect.DeepEqual(batches[0].Recipients, []string{"a@example.com", "b@example.com"}) || batches[0].Err != nil {
+		t.Errorf("invalid first batch %+v", batches[0])
+	}
+	if !reflect.DeepEqual(batches[1].Recipients, []string{"c@example.com"}) || batches[1].Err == nil {
+		t.Errorf("invalid second batch %+v", batches[1])
+	}
+}
@@ -0,0 +1,107 @@
+package gomail
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+)
+
+// SetRequireTLS is a message setting to control the use of TLS along the
+// whole delivery path of the message (RFC 8689).
+//
+// When required is true, Dialer sends the message with the REQUIRETLS
+// parameter of the MAIL command, which asks every server relaying it to use
+// TLS with a verified certificate or to bounce it. The sending fails with
+// ErrUnencryptedConnection if the SMTP session is not protected by TLS, and
+// with ErrRequireTLSUnsupported if the server does not support REQUIRETLS.
+//
+// When required is false, the "TLS-Required: No" header field is written, so
+// that the message is delivered even when the recipient domain requires TLS
+// with MTA-STS or DANE and it cannot be negotiated. It is meant for messages,
+// such as system alerts, whose delivery matters more than their
+// confidentiality.
+func SetRequireTLS(required bool) MessageSetting {
+	return func(m *Message) {
+		m.requireTLS = required
+		m.tlsOptional = !required
+	}
+}
+
+type requireTLSKey struct{}
+
+// WithRequireTLS returns a copy of ctx controlling the use of REQUIRETLS for
+// the messages sent with it by Dialer, including raw messages which cannot be
+// given a message setting. A setting of the message takes precedence. Unlike
+// SetRequireTLS, it writes no header field when required is false.
+func WithRequireTLS(ctx context.Context, required bool) context.Context {
+	return context.WithValue(ctx, requireTLSKey{}, required)
+}
+
+// requireTLSFor reports whether the messages sent with ctx require TLS.
+func requireTLSFor(ctx context.Context) bool {
+	required, _ := ctx.Value(requireTLSKey{}).(bool)
+	return required
+}
+
+// withMessageSettings returns a copy of ctx carrying the settings and the body
+// encoding of msg used by the SMTP transaction, if it is a Message, so that they are kept when the
+// message is replaced by a send middleware or downgraded to 7-bit.
+func withMessageSettings(ctx context.Context, msg io.WriterTo) context.Context {
+	m, ok := msg.(*Message)
+	if !ok {
+		return ctx
+	}
+	ctx = context.WithValue(ctx, bodyKey{}, m.bodyEncoding())
+	if m.dsn != nil {
+		ctx = context.WithValue(ctx, dsnKey{}, m.dsn)
+	}
+	if m.requireTLS || m.tlsOptional {
+		ctx = WithRequireTLS(ctx, m.requireTLS)
+	}
+	return ctx
+}
+
+func (w *messageWriter) writeTLSRequired(m *Message) {
+	if !m.tlsOptional {
+		return
+	}
+	if _, ok := m.header["TLS-Required"]; ok {
+		return
+	}
+	w.writeHeader("TLS-Required", "No")
+}
+
+// TLSConnectionState returns the state of the TLS connection, and whether
+// the session is protected by TLS.
+func (c *smtpConn) TLSConnectionState() (tls.ConnectionState, bool) {
+	tc, ok := c.conn.(*tls.Conn)
+	if !ok {
+		return tls.ConnectionState{}, false
+	}
+	return tc.ConnectionState(), true
+}
+
+// setRequireTLS adds the REQUIRETLS parameter to the MAIL command when
+// required is true, after checking that the session uses TLS and that the
+// server supports REQUIRETLS.
+func (c *smtpSender) setRequireTLS(t *transaction, required bool) error {
+	if !required {
+		return nil
+	}
+
+	tc, ok := c.smtpClient.(interface {
+		TLSConnectionState() (tls.ConnectionState, bool)
+	})
+	if !ok {
+		return ErrUnencryptedConnection
+	}
+	if _, ok := tc.TLSConnectionState(); !ok {
+		return ErrUnencryptedConnection
+	}
+	if ok, _ := c.Extension("REQUIRETLS"); !ok {
+		return ErrRequireTLSUnsupported
+	}
+
+	t.mailParams = append(t.mailParams, "REQUIRETLS")
+	return nil
+}
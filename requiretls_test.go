@@ -0,0 +1,173 @@
+package gomail
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSetRequireTLS(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings []MessageSetting
+		header   bool
+	}{
+		{"Default", nil, false},
+		{"Required", []MessageSetting{SetRequireTLS(true)}, false},
+		{"NotRequired", []MessageSetting{SetRequireTLS(false)}, true},
+		{"Overridden", []MessageSetting{SetRequireTLS(false), SetRequireTLS(true)}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMessage(tt.settings...)
+			m.SetHeader("From", "from@example.com")
+			m.SetHeader("To", "to@example.com")
+			m.SetBody("text/plain", "Test")
+
+			var buf bytes.Buffer
+			if _, err := m.WriteTo(&buf); err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Contains(buf.String(), "\r\nTLS-Required: No\r\n"); got != tt.header {
+				t.Errorf("TLS-Required header written: %v, want %v:\n%s", got, tt.header, buf.String())
+			}
+		})
+	}
+}
+
+// tlsSMTPConn is an smtpConn reporting a TLS session.
+type tlsSMTPConn struct {
+	*smtpConn
+}
+
+func (tlsSMTPConn) TLSConnectionState() (tls.ConnectionState, bool) {
+	return tls.ConnectionState{}, true
+}
+
+func TestSendRequireTLS(t *testing.T) {
+	tests := []struct {
+		name       string
+		tls        bool
+		extensions string
+		// unencoded sends an 8-bit body, downgraded without 8BITMIME.
+		unencoded bool
+		// context requires TLS with WithRequireTLS rather than a setting.
+		context  bool
+		dkim     bool
+		commands string
+		wantErr  error
+	}{
+		{
+			name:       "Required",
+			tls:        true,
+			extensions: "250 REQUIRETLS\n",
+			commands:   "MAIL FROM:<" + testFrom + "> REQUIRETLS\n",
+		},
+		{
+			name:       "Unencrypted",
+			extensions: "250 REQUIRETLS\n",
+			wantErr:    ErrUnencryptedConnection,
+		},
+		{
+			name:       "Unsupported",
+			tls:        true,
+			extensions: "250 8BITMIME\n",
+			wantErr:    ErrRequireTLSUnsupported,
+		},
+		{
+			name:       "Downgraded",
+			tls:        true,
+			extensions: "250 REQUIRETLS\n",
+			unencoded:  true,
+			commands:   "MAIL FROM:<" + testFrom + "> REQUIRETLS\n",
+		},
+		{
+			name:       "Middleware",
+			tls:        true,
+			extensions: "250 REQUIRETLS\n",
+			dkim:       true,
+			commands:   "MAIL FROM:<" + testFrom + "> REQUIRETLS\n",
+		},
+		{
+			name:       "MiddlewareUnsupported",
+			tls:        true,
+			extensions: "250 8BITMIME\n",
+			dkim:       true,
+			wantErr:    ErrRequireTLSUnsupported,
+		},
+		{
+			name:       "Context",
+			tls:        true,
+			extensions: "250 REQUIRETLS\n",
+			context:    true,
+			commands:   "MAIL FROM:<" + testFrom + "> REQUIRETLS\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := newLineSMTPConn(
+				"220 mx.example.com\n",
+				"250-mx.example.com\n"+tt.extensions,
+				"250 2.1.0 OK\n",
+				"250 2.1.5 OK\n",
+				"250 2.1.5 OK\n",
+				"354 Go ahead\n",
+				"250 2.0.0 Queued\n",
+			)
+			sc, err := newSMTPConn(conn, "mx.example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var c smtpClient = sc
+			if tt.tls {
+				c = tlsSMTPConn{sc}
+			}
+			d := NewDialer("localhost", testPort, "", "")
+			if tt.dkim {
+				signer, err := NewDKIMSigner(DKIMOptions{Domain: "example.com", Selector: "s", Signer: testEd25519Key})
+				if err != nil {
+					t.Fatal(err)
+				}
+				d.SendMiddlewares = SendMiddlewares{WithDKIM(signer)}
+			}
+			s := &smtpSender{smtpClient: c, conn: &contextConn{Conn: conn}, d: d}
+
+			ctx := context.Background()
+			m := NewMessage(SetRequireTLS(true))
+			if tt.context {
+				ctx = WithRequireTLS(ctx, true)
+				m = NewMessage()
+			}
+			m.SetHeader("From", testFrom)
+			m.SetHeader("To", testTo1, testTo2)
+			if tt.unencoded {
+				m.SetBody("text/plain", "Café", SetPartEncoding(Unencoded))
+			} else {
+				m.SetBody("text/plain", "Test message")
+			}
+
+			if err := Send(ctx, s, m); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			got := conn.commands()
+			if tt.wantErr != nil {
+				if strings.Contains(got, "MAIL") {
+					t.Errorf("the transaction is started:\n%s", got)
+				}
+				return
+			}
+			if !strings.Contains(got, tt.commands) {
+				t.Errorf("commands do not contain %q:\n%s", tt.commands, got)
+			}
+			if strings.Contains(got, "TLS-Required") {
+				t.Errorf("the TLS-Required header is written with REQUIRETLS:\n%s", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package gomail
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"strings"
+	"time"
+)
+
+// Resent describes the re-delivery of a message to new recipients. It is
+// written as a block of Resent-* header fields at the top of the message (RFC
+// 5322, section 3.6.6).
+type Resent struct {
+	// From is the mailbox of the author of the re-delivery. It is required.
+	From Address
+	// Sender is the mailbox of the agent actually re-delivering the message
+	// when it differs from From. It is optional.
+	Sender Address
+	// To, Cc and Bcc are the new recipients. Bcc addresses receive the
+	// message but are not written in the header.
+	To, Cc, Bcc []Address
+	// Date is the date of the re-delivery. It defaults to the current time.
+	Date time.Time
+	// MessageID is the identifier of the re-delivery, without angle brackets.
+	// A random one is generated on the domain of From if it is empty.
+	MessageID string
+}
+
+// Resend prepares the message to be delivered again to the recipients of r:
+// it adds a Resent-From, Resent-To, Resent-Date and Resent-Message-ID block
+// to the header, and the message is then sent to the resent recipients
+// instead of those of the To, Cc and Bcc header fields. The original header
+// fields and content are kept unchanged.
+//
+// Resend can be called several times: each call adds a new block above the
+// previous ones, and the message is sent to the recipients of the last one.
+func (m *Message) Resend(r Resent) error {
+	if err := r.complete(); err != nil {
+		return err
+	}
+
+	m.resent = append([]*Resent{&r}, m.resent...)
+	return nil
+}
+
+// Resend sends again the raw message read from msg, such as an archived
+// message, to the recipients of r. The Resent-* block is written above the
+// original header, which is kept unchanged along with the content, so that
+// the existing DKIM signatures remain valid.
+func Resend(ctx context.Context, s Sender, msg io.Reader, r Resent) error {
+	if err := r.complete(); err != nil {
+		return err
+	}
+
+	from, err := r.from()
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, msg); err != nil {
+		return err
+	}
+
+	return s.Send(ctx, from, r.recipients(), &resentMessage{resent: &r, msg: buf.Bytes()})
+}
+
+// complete checks that r has a sender and recipients, and sets its default
+// date and message identifier.
+func (r *Resent) complete() error {
+	if r.From.Address == "" {
+		return ErrInvalidMessageFromAbsent
+	}
+	if len(r.recipients()) == 0 {
+		return ErrInvalidMessageRecipientAbsent
+	}
+	if r.Date.IsZero() {
+		r.Date = now()
+	}
+	if r.MessageID == "" {
+		id, err := newMessageID(r.From.Address)
+		if err != nil {
+			return err
+		}
+		r.MessageID = id
+	}
+
+	return nil
+}
+
+// from returns the address of the envelope sender.
+func (r *Resent) from() (string, error) {
+	if r.Sender.Address != "" {
+		return r.Sender.Address, nil
+	}
+	if r.From.Address != "" {
+		return r.From.Address, nil
+	}
+
+	return "", ErrInvalidMessageFromAbsent
+}
+
+// recipients returns the addresses of the envelope recipients.
+func (r *Resent) recipients() []string {
+	var list []string
+	for _, addresses := range [][]Address{r.To, r.Cc, r.Bcc} {
+		for _, a := range AddressList(addresses).Mailboxes() {
+			list = addAddress(list, a.Address)
+		}
+	}
+
+	return list
+}
+
+// newMessageID returns a random message identifier on the domain of address.
+func newMessageID(address string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	domain := "localhost"
+	if i := strings.LastIndexByte(address, '@'); i != -1 && i+1 < len(address) {
+		domain = address[i+1:]
+	}
+
+	return hex.EncodeToString(b) + "@" + domain, nil
+}
+
+// writeResent writes the Resent-* blocks of m, the most recent first.
+func (w *messageWriter) writeResent(m *Message) {
+	for _, r := range m.resent {
+		w.writeResentBlock(m, r)
+	}
+}
+
+func (w *messageWriter) writeResentBlock(m *Message, r *Resent) {
+	w.writeHeader("Resent-Date", m.FormatDate(r.Date))
+	w.writeHeader("Resent-From", m.formatAddress(r.From))
+	if r.Sender.Address != "" {
+		w.writeHeader("Resent-Sender", m.formatAddress(r.Sender))
+	}
+	for _, field := range []struct {
+		name      string
+		addresses []Address
+	}{
+		{"Resent-To", r.To},
+		{"Resent-Cc", r.Cc},
+	} {
+		if len(field.addresses) == 0 {
+			continue
+		}
+		values := make([]string, len(field.addresses))
+		for i, a := range field.addresses {
+			values[i] = m.formatAddress(a)
+		}
+		w.writeHeader(field.name, values...)
+	}
+	w.writeHeader("Resent-Message-ID", "<"+r.MessageID+">")
+}
+
+// A resentMessage is a raw message preceded by a Resent-* block.
+type resentMessage struct {
+	resent *Resent
+	msg    []byte
+}
+
+func (r *resentMessage) WriteTo(w io.Writer) (int64, error) {
+	mw := &messageWriter{w: w}
+	mw.writeResentBlock(NewMessage(), r.resent)
+	if mw.err != nil {
+		return mw.n, mw.err
+	}
+
+	n, err := w.Write(r.msg)
+	return mw.n + int64(n), err
+}
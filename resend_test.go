@@ -0,0 +1,177 @@
+package gomail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResend(t *testing.T) {
+	m := NewMessage()
+	m.SetHeader("From", "from@example.com")
+	m.SetHeader("To", "to@example.com")
+	m.SetHeader("Bcc", "bcc@example.com")
+	m.SetBody("text/plain", "Test message")
+	err := m.Resend(Resent{
+		From:      Address{Name: "Audit", Address: "audit@example.org"},
+		To:        []Address{{Address: "escalation@example.org"}},
+		Cc:        []Address{{Name: "Team", Members: []Address{{Address: "a@example.org"}}}},
+		Bcc:       []Address{{Address: "archive@example.org"}, {Address: "escalation@example.org"}},
+		MessageID: "1234@example.org",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &message{
+		from: "audit@example.org",
+		to:   []string{"escalation@example.org", "a@example.org", "archive@example.org"},
+		content: "Resent-Date: Wed, 25 Jun 2014 17:46:00 +0000\r\n" +
+			"Resent-From: \"Audit\" <audit@example.org>\r\n" +
+			"Resent-To: escalation@example.org\r\n" +
+			"Resent-Cc: \"Team\": a@example.org;\r\n" +
+			"Resent-Message-ID: <1234@example.org>\r\n" +
+			"From: from@example.com\r\n" +
+			"To: to@example.com\r\n" +
+			"Content-Type: text/plain; charset=UTF-8\r\n" +
+			"Content-Transfer-Encoding: quoted-printable\r\n" +
+			"\r\n" +
+			"Test message",
+	}
+
+	testMessage(t, m, 0, want)
+}
+
+func TestResendTwice(t *testing.T) {
+	m := NewMessage()
+	m.SetHeader("From", "from@example.com")
+	m.SetHeader("To", "to@example.com")
+	m.SetBody("text/plain", "Test message")
+	first := time.Date(2014, 6, 26, 8, 0, 0, 0, time.UTC)
+	if err := m.Resend(Resent{
+		From:      Address{Address: "first@example.org"},
+		To:        []Address{{Address: "first-to@example.org"}},
+		Date:      first,
+		MessageID: "1@example.org",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Resend(Resent{
+		From:      Address{Address: "second@example.org"},
+		Sender:    Address{Address: "bounce@example.org"},
+		To:        []Address{{Address: "second-to@example.org"}},
+		MessageID: "2@example.org",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	if _, err := m.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	wantStart := "Resent-Date: Wed, 25 Jun 2014 17:46:00 +0000\r\n" +
+		"Resent-From: second@example.org\r\n" +
+		"Resent-Sender: bounce@example.org\r\n" +
+		"Resent-To: second-to@example.org\r\n" +
+		"Resent-Message-ID: <2@example.org>\r\n" +
+		"Resent-Date: Thu, 26 Jun 2014 08:00:00 +0000\r\n" +
+		"Resent-From: first@example.org\r\n" +
+		"Resent-To: first-to@example.org\r\n" +
+		"Resent-Message-ID: <1@example.org>\r\n"
+	if got := buf.String(); !strings.HasPrefix(got, wantStart) {
+		t.Errorf("invalid resent blocks, got:\n%s\nwant:\n%s", got, wantStart)
+	}
+
+	from, err := m.getFrom()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from != "bounce@example.org" {
+		t.Errorf("invalid from, got %q, want %q", from, "bounce@example.org")
+	}
+
+	m.Reset()
+	if len(m.resent) != 0 {
+		t.Error("Reset should remove the resent blocks")
+	}
+}
+
+func TestResendMessageID(t *testing.T) {
+	m := NewMessage()
+	if err := m.Resend(Resent{From: Address{Address: "audit@example.org"}, Bcc: []Address{{Address: "to@example.org"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if id := m.resent[0].MessageID; !strings.HasSuffix(id, "@example.org") || len(id) != 32+len("@example.org") {
+		t.Errorf("invalid message ID %q", id)
+	}
+
+	if err := m.Resend(Resent{To: []Address{{Address: "to@example.org"}}}); !errors.Is(err, ErrInvalidMessageFromAbsent) {
+		t.Errorf("Resend without From, got error %v, want %v", err, ErrInvalidMessageFromAbsent)
+	}
+	if err := m.Resend(Resent{From: Address{Address: "audit@example.org"}}); !errors.Is(err, ErrInvalidMessageRecipientAbsent) {
+		t.Errorf("Resend without recipients, got error %v, want %v", err, ErrInvalidMessageRecipientAbsent)
+	}
+
+	s := SendFunc(func(context.Context, string, []string, io.WriterTo) error {
+		t.Error("the message was sent without recipients")
+		return nil
+	})
+	err := Resend(context.Background(), s, strings.NewReader("Subject: Test\r\n\r\n"), Resent{From: Address{Address: "audit@example.org"}})
+	if !errors.Is(err, ErrInvalidMessageRecipientAbsent) {
+		t.Errorf("Resend of a raw message without recipients, got error %v, want %v", err, ErrInvalidMessageRecipientAbsent)
+	}
+}
+
+func TestResendRaw(t *testing.T) {
+	raw := "From: from@example.com\r\n" +
+		"To: to@example.com\r\n" +
+		"Subject: Archived\r\n" +
+		"\r\n" +
+		"Test message"
+
+	var sent bool
+	s := SendFunc(func(ctx context.Context, from string, to []string, msg io.WriterTo) error {
+		sent = true
+		if from != "audit@example.org" {
+			t.Errorf("invalid from, got %q", from)
+		}
+		if want := []string{"escalation@example.org"}; !reflect.DeepEqual(to, want) {
+			t.Errorf("invalid recipients, got %q, want %q", to, want)
+		}
+
+		buf := new(bytes.Buffer)
+		n, err := msg.WriteTo(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != int64(buf.Len()) {
+			t.Errorf("invalid count, got %d, want %d", n, buf.Len())
+		}
+		want := "Resent-Date: Wed, 25 Jun 2014 17:46:00 +0000\r\n" +
+			"Resent-From: audit@example.org\r\n" +
+			"Resent-To: escalation@example.org\r\n" +
+			"Resent-Message-ID: <1234@example.org>\r\n" +
+			raw
+		if got := buf.String(); got != want {
+			t.Errorf("invalid message, got:\n%s\nwant:\n%s", got, want)
+		}
+		return nil
+	})
+
+	err := Resend(context.Background(), s, strings.NewReader(raw), Resent{
+		From:      Address{Address: "audit@example.org"},
+		To:        []Address{{Address: "escalation@example.org"}},
+		MessageID: "1234@example.org",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sent {
+		t.Error("the message was not sent")
+	}
+}
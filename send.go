@@ -2,8 +2,8 @@ package gomail
 
 import (
 	"context"
+	"errors"
 	"io"
-	stdmail "net/mail"
 )
 
 // Sender is the interface that wraps the Send method.
@@ -32,14 +32,65 @@ func (f SendFunc) Send(ctx context.Context, from string, to []string, msg io.Wri
 }
 
 // Send sends emails using the given Sender.
+//
+// A message sent to some of its recipients only, reported with a
+// RecipientsError whose Sent field is set, does not stop the sending of the
+// following messages: the SendError of the first of them is returned after
+// all the messages are sent. Any other error stops the sending, and is
+// returned with the index of the failed message.
 func Send(ctx context.Context, s Sender, msg ...*Message) error {
+	_, err := SendWithResults(ctx, s, msg...)
+	return err
+}
+
+// SendWithResults is like Send, and also returns the outcome of each message,
+// in the order of msg: the recipients accepted and rejected by the SMTP
+// server when the message was sent with a Dialer. The result of a message is
+// nil if it was not sent, or if s does not report it.
+func SendWithResults(ctx context.Context, s Sender, msg ...*Message) ([]*SendResult, error) {
+	results := make([]*SendResult, len(msg))
+	var partialErr error
 	for i, m := range msg {
-		if err := send(ctx, s, m); err != nil {
-			return &SendError{Cause: err, Index: uint(i)}
+		report := &sendReport{}
+		err := send(context.WithValue(ctx, sendReportKey{}, report), s, m)
+		if err == nil {
+			if report.reported {
+				results[i] = &report.result
+			}
+			continue
+		}
+
+		sendErr := &SendError{Cause: err, Index: uint(i)}
+		var recipientsErr *RecipientsError
+		if errors.As(err, &recipientsErr) {
+			sendErr.Result = &recipientsErr.Result
+		}
+		if recipientsErr == nil || !recipientsErr.Sent {
+			return results, sendErr
+		}
+		results[i] = sendErr.Result
+		if partialErr == nil {
+			partialErr = sendErr
 		}
 	}
 
-	return nil
+	return results, partialErr
+}
+
+type sendReportKey struct{}
+
+// A sendReport receives the outcome of a message sent successfully by Dialer.
+type sendReport struct {
+	result   SendResult
+	reported bool
+}
+
+// reportSendResult reports result to the sendReport of ctx, if any.
+func reportSendResult(ctx context.Context, result SendResult) {
+	if r, ok := ctx.Value(sendReportKey{}).(*sendReport); ok {
+		r.result = result
+		r.reported = true
+	}
 }
 
 func send(ctx context.Context, s Sender, m *Message) error {
@@ -61,39 +112,90 @@ func send(ctx context.Context, s Sender, m *Message) error {
 }
 
 func (m *Message) getFrom() (string, error) {
-	from := m.header["Sender"]
-	if len(from) == 0 {
-		from = m.header["From"]
-		if len(from) == 0 {
-			return "", ErrInvalidMessageFromAbsent
-		}
+	if m.nullReturnPath {
+		return "", nil
+	}
+	if len(m.resent) > 0 {
+		return m.resent[0].from()
+	}
+
+	field := "Sender"
+	if len(m.header[field]) == 0 {
+		field = "From"
+	}
+
+	return m.getFirstAddress(field)
+}
+
+// getFirstAddress returns the address of the first mailbox of the given
+// header field.
+func (m *Message) getFirstAddress(field string) (string, error) {
+	list, err := m.GetAddresses(field)
+	if err != nil {
+		return "", err
+	}
+	mailboxes := list.Mailboxes()
+	if len(mailboxes) == 0 {
+		return "", &MissingAddressError{Field: field}
 	}
 
-	return parseAddress(from[0])
+	return mailboxes[0].Address, nil
 }
 
 func (m *Message) getRecipients() ([]string, error) {
-	n := 0
+	if len(m.resent) > 0 {
+		return m.resent[0].recipients(), nil
+	}
+
+	var list []string
 	for _, field := range []string{"To", "Cc", "Bcc"} {
-		if addresses, ok := m.header[field]; ok {
-			n += len(addresses)
+		addresses, err := m.GetAddresses(field)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range addresses.Mailboxes() {
+			list = addAddress(list, a.Address)
 		}
 	}
-	list := make([]string, 0, n)
 
-	for _, field := range []string{"To", "Cc", "Bcc"} {
-		if addresses, ok := m.header[field]; ok {
-			for _, a := range addresses {
-				addr, err := parseAddress(a)
-				if err != nil {
-					return nil, err
-				}
-				list = addAddress(list, addr)
+	return list, nil
+}
+
+// getTokenRecipient returns the recipient for whom the per-recipient tokens
+// of m, such as the unsubscribe and tracking ones, are signed. As the tokens
+// are visible to every recipient, m must have a single recipient, which is
+// not a Bcc one.
+func (m *Message) getTokenRecipient() (string, error) {
+	to, err := m.getRecipients()
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case len(to) == 0:
+		return "", ErrInvalidMessageRecipientAbsent
+	case len(to) > 1:
+		return "", ErrSeveralRecipients
+	}
+
+	var visible AddressList
+	if len(m.resent) > 0 {
+		visible = append(append(visible, m.resent[0].To...), m.resent[0].Cc...)
+	} else {
+		for _, field := range []string{"To", "Cc"} {
+			addresses, err := m.GetAddresses(field)
+			if err != nil {
+				return "", err
 			}
+			visible = append(visible, addresses...)
+		}
+	}
+	for _, a := range visible.Mailboxes() {
+		if a.Address == to[0] {
+			return to[0], nil
 		}
 	}
 
-	return list, nil
+	return "", ErrBccRecipient
 }
 
 func addAddress(list []string, addr string) []string {
@@ -105,11 +207,3 @@ func addAddress(list []string, addr string) []string {
 
 	return append(list, addr)
 }
-
-func parseAddress(field string) (string, error) {
-	addr, err := stdmail.ParseAddress(field)
-	if err != nil {
-		return "", &InvalidAddress{field, err}
-	}
-	return addr.Address, nil
-}
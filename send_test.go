@@ -64,6 +64,55 @@ func TestSendError(t *testing.T) {
 	}
 }
 
+func TestSendPartiallySent(t *testing.T) {
+	partialErr := &RecipientsError{Sent: true}
+	tests := []struct {
+		name      string
+		errs      []error
+		calls     int
+		wantIndex uint
+	}{
+		{"Continue", []error{partialErr, nil, partialErr}, 3, 0},
+		{"Stop", []error{partialErr, errors.New("kaboom"), nil}, 2, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			s := mockSender(func(context.Context, string, []string, io.WriterTo) error {
+				calls++
+				return tt.errs[calls-1]
+			})
+
+			results, err := SendWithResults(context.Background(), s, getTestMessage(), getTestMessage(), getTestMessage())
+			if calls != tt.calls {
+				t.Errorf("got %d messages sent, want %d", calls, tt.calls)
+			}
+			var sendErr *SendError
+			if !errors.As(err, &sendErr) || sendErr.Index != tt.wantIndex {
+				t.Fatalf("got error %v, want a SendError for message %d", err, tt.wantIndex)
+			}
+			if results[0] == nil || results[1] != nil {
+				t.Errorf("got results %v, want the result of the first message only", results)
+			}
+		})
+	}
+}
+
+func TestSendMissingSender(t *testing.T) {
+	m := getTestMessage()
+	m.SetHeader("Sender", "undisclosed-recipients:;")
+
+	err := Send(context.Background(), stubSend(t, testFrom, []string{testTo1, testTo2}, testMsg), m)
+	var missingErr *MissingAddressError
+	if !errors.As(err, &missingErr) || missingErr.Field != "Sender" {
+		t.Fatalf("got error %v, want a MissingAddressError for Sender", err)
+	}
+	if !errors.Is(err, ErrInvalidMessageFromAbsent) {
+		t.Errorf("error %v does not match %v", err, ErrInvalidMessageFromAbsent)
+	}
+}
+
 func getTestMessage() *Message {
 	m := NewMessage()
 	m.SetHeader("From", testFrom)
@@ -0,0 +1,143 @@
+package gomail
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// Size returns the size in bytes of the message as it is sent, encoding
+// overhead included.
+//
+// The content of the attachments and embedded files whose size is known is
+// not read: it is the case of files added by name and of readers reporting
+// their length, such as *bytes.Reader. The content of other readers is read
+// in memory, so that it can still be sent afterwards. When the message is
+// signed or encrypted, the whole message is rendered, and the size can differ
+// by a few bytes from the one of the message sent as ECDSA signatures do not
+// have a fixed length.
+func (m *Message) Size() (int64, error) {
+	return m.size(false)
+}
+
+// size returns the size of the message, with 7-bit transfer encodings only
+// when sevenBit is set.
+func (m *Message) size(sevenBit bool) (int64, error) {
+	if m.isSigned() {
+		for _, list := range [][]*file{m.embedded, m.attachments} {
+			for _, f := range list {
+				if f.buffer == nil {
+					continue
+				}
+				if err := f.buffer(); err != nil {
+					return 0, err
+				}
+			}
+		}
+
+		return m.writeTo(io.Discard, sevenBit)
+	}
+
+	mw := &messageWriter{w: io.Discard, sizeOnly: true, sevenBit: sevenBit}
+	mw.writeMessage(m)
+	return mw.n, mw.err
+}
+
+// fileCopier returns the function writing the content of f, encoded with enc.
+// When only the size of the message is computed and the size of the encoded
+// content only depends on its length, it writes as many zeros as f holds
+// bytes. Otherwise, the content of a reader is read in memory so that it can
+// still be sent afterwards.
+func (w *messageWriter) fileCopier(f *file, enc Encoding) (func(io.Writer) error, error) {
+	if !w.sizeOnly {
+		return f.CopyFunc, nil
+	}
+	if f.size == nil || !sizedByLength(enc) {
+		if f.buffer != nil {
+			if err := f.buffer(); err != nil {
+				return nil, err
+			}
+		}
+		return f.CopyFunc, nil
+	}
+
+	n, err := f.size()
+	if err != nil {
+		return nil, err
+	}
+
+	return func(w io.Writer) error {
+		var zeros [4096]byte
+		for n > 0 {
+			chunk := int64(len(zeros))
+			if n < chunk {
+				chunk = n
+			}
+			if _, err := w.Write(zeros[:chunk]); err != nil {
+				return err
+			}
+			n -= chunk
+		}
+		return nil
+	}, nil
+}
+
+// sizedByLength reports whether the size of content encoded with enc only
+// depends on its length, as it does with base64, unlike quoted-printable.
+func sizedByLength(enc Encoding) bool {
+	return enc == Base64 || enc == Binary
+}
+
+func filenameSize(name string) func() (int64, error) {
+	return func() (int64, error) {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return 0, err
+		}
+		return fi.Size(), nil
+	}
+}
+
+// readerContent is the content of a file added with an io.Reader. It can be
+// read in memory so that it can be written several times.
+type readerContent struct {
+	r        io.Reader
+	data     []byte
+	buffered bool
+}
+
+func (c *readerContent) copy(w io.Writer) error {
+	if c.buffered {
+		_, err := w.Write(c.data)
+		return err
+	}
+
+	_, err := io.Copy(w, c.r)
+	return err
+}
+
+func (c *readerContent) size() (int64, error) {
+	if !c.buffered {
+		if l, ok := c.r.(interface{ Len() int }); ok {
+			return int64(l.Len()), nil
+		}
+		if err := c.buffer(); err != nil {
+			return 0, err
+		}
+	}
+
+	return int64(len(c.data)), nil
+}
+
+func (c *readerContent) buffer() error {
+	if c.buffered {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, c.r); err != nil {
+		return err
+	}
+	c.data, c.buffered = buf.Bytes(), true
+	return nil
+}
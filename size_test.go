@@ -0,0 +1,114 @@
+package gomail
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSize(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "file.bin")
+	if err := os.WriteFile(filename, bytes.Repeat([]byte("x"), 1000), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		m    func() *Message
+	}{
+		{
+			name: "single part",
+			m:    getTestMessage,
+		},
+		{
+			name: "body only",
+			m: func() *Message {
+				m := getTestMessage()
+				m.AddAlternative("text/html", "<p>"+strings.Repeat("é", 300)+"</p>")
+				return m
+			},
+		},
+		{
+			name: "files",
+			m: func() *Message {
+				m := getTestMessage()
+				m.Attach(filename)
+				m.Embed(filename, Rename("embedded.bin"))
+				m.AttachReader("reader.txt", strings.NewReader(strings.Repeat("abc", 100)))
+				m.AttachReader("stream.txt", io.LimitReader(strings.NewReader(strings.Repeat("def", 100)), 200))
+				return m
+			},
+		},
+		{
+			name: "quoted-printable files",
+			m: func() *Message {
+				m := getTestMessage()
+				m.Attach(filename, SetFileEncoding(QuotedPrintable))
+				m.AttachReader("reader.txt", strings.NewReader("Café\r\n"+strings.Repeat("=", 100)), SetFileEncoding(QuotedPrintable))
+				m.AttachReader("stream.txt", io.LimitReader(strings.NewReader(strings.Repeat("é", 100)), 150), SetFileEncoding(QuotedPrintable))
+				m.AttachReader("notes", strings.NewReader("Hello, world!"), SetFileEncoding(QuotedPrintable))
+				return m
+			},
+		},
+		{
+			name: "copy func",
+			m: func() *Message {
+				m := getTestMessage()
+				m.Attach("test.pdf", SetCopyFunc(func(w io.Writer) error {
+					_, err := w.Write([]byte("Content of test.pdf"))
+					return err
+				}))
+				return m
+			},
+		},
+		{
+			name: "DKIM",
+			m: func() *Message {
+				s, err := NewDKIMSigner(DKIMOptions{Domain: "example.com", Selector: "s", Signer: testEd25519Key})
+				if err != nil {
+					t.Fatal(err)
+				}
+				m := getTestMessage()
+				SetDKIM(s)(m)
+				m.AttachReader("stream.txt", io.LimitReader(strings.NewReader(strings.Repeat("def", 100)), 200))
+				return m
+			},
+		},
+	}
+
+	for _, test := range tests {
+		m := test.m()
+		size, err := m.Size()
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		buf := new(bytes.Buffer)
+		if _, err := m.WriteTo(buf); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if size != int64(buf.Len()) {
+			t.Errorf("%s: invalid size, got %d, want %d", test.name, size, buf.Len())
+		}
+	}
+}
+
+func TestSizeKeepsReaderContent(t *testing.T) {
+	m := getTestMessage()
+	m.AttachReader("stream.txt", io.LimitReader(strings.NewReader("Content of the stream"), 100))
+
+	if _, err := m.Size(); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	if _, err := m.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "Q29udGVudCBvZiB0aGUgc3RyZWFt") {
+		t.Errorf("the content of the reader is missing:\n%s", buf.String())
+	}
+}
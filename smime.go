@@ -0,0 +1,216 @@
+package gomail
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"mime/multipart"
+	"strings"
+)
+
+// An SMIMESigner signs messages with S/MIME (RFC 8551). The signed message is
+// a multipart/signed entity holding the original MIME body and a detached
+// CMS signature.
+type SMIMESigner struct {
+	key   crypto.Signer
+	certs []*x509.Certificate
+}
+
+// NewSMIMESigner returns an SMIMESigner using the given private key. certs is
+// the certificate chain: the first certificate must be the certificate of
+// key, the others are the intermediate certificates sent along with the
+// signature so that clients can build the chain.
+func NewSMIMESigner(key crypto.Signer, certs ...*x509.Certificate) (*SMIMESigner, error) {
+	if key == nil || len(certs) == 0 {
+		return nil, ErrInvalidSMIMESigner
+	}
+	if _, err := cmsSignatureAlgorithm(key); err != nil {
+		return nil, err
+	}
+
+	return &SMIMESigner{key: key, certs: certs}, nil
+}
+
+// SetSMIMESigning is a message setting to sign the message with s when it is
+// written. When the message is also signed with DKIM, the DKIM signature is
+// computed over the S/MIME signed message.
+func SetSMIMESigning(s *SMIMESigner) MessageSetting {
+	return func(m *Message) {
+		m.smimeSigner = s
+	}
+}
+
+// Sign returns msg, a complete RFC 5322 message, with its MIME body replaced
+// by a multipart/signed entity. Header fields not describing the content, such
+// as From or Subject, are kept at the top level.
+func (s *SMIMESigner) Sign(msg []byte) ([]byte, error) {
+	header, entity := splitEntity(normalizeCRLF(msg))
+
+	sig, err := cmsSignDetached(entity, s.key, s.certs, now())
+	if err != nil {
+		return nil, err
+	}
+
+	boundary := multipart.NewWriter(nil).Boundary()
+
+	var buf bytes.Buffer
+	for _, f := range header {
+		buf.WriteString(f)
+	}
+	buf.WriteString("Content-Type: multipart/signed; protocol=\"application/pkcs7-signature\";\r\n" +
+		" micalg=sha-256; boundary=" + boundary + "\r\n\r\n")
+	buf.WriteString("--" + boundary + "\r\n")
+	buf.Write(entity)
+	buf.WriteString("\r\n--" + boundary + "\r\n")
+	buf.WriteString("Content-Type: application/pkcs7-signature; name=\"smime.p7s\"\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"Content-Disposition: attachment; filename=\"smime.p7s\"\r\n\r\n")
+	writeBase64Lines(&buf, sig)
+	buf.WriteString("\r\n--" + boundary + "--\r\n")
+
+	return buf.Bytes(), nil
+}
+
+// SMIMEContentEncryption represents the algorithm used to encrypt the content
+// of S/MIME encrypted messages.
+type SMIMEContentEncryption int
+
+const (
+	// AES256GCM encrypts the content with AES-256 in GCM mode. Messages are
+	// authEnveloped-data (RFC 5083) which protects them from tampering.
+	AES256GCM SMIMEContentEncryption = iota
+	// AES256CBC encrypts the content with AES-256 in CBC mode. Messages are
+	// enveloped-data, which is supported by older clients.
+	AES256CBC
+)
+
+// An SMIMEEncrypter encrypts messages with S/MIME (RFC 8551) for their
+// recipients. The content encryption key is encrypted with RSAES-OAEP for
+// recipients with an RSA certificate, and with ECDH for recipients with an EC
+// certificate.
+type SMIMEEncrypter struct {
+	// ContentEncryption is the content encryption algorithm. It defaults to
+	// AES256GCM.
+	ContentEncryption SMIMEContentEncryption
+
+	certs map[string]*x509.Certificate
+	extra []*x509.Certificate
+}
+
+// NewSMIMEEncrypter returns an SMIMEEncrypter encrypting messages for the
+// owners of the given certificates. The recipients of a message are matched
+// against the email addresses of the certificates.
+func NewSMIMEEncrypter(certs ...*x509.Certificate) (*SMIMEEncrypter, error) {
+	e := &SMIMEEncrypter{certs: make(map[string]*x509.Certificate)}
+	for _, c := range certs {
+		switch c.PublicKey.(type) {
+		case *rsa.PublicKey, *ecdsa.PublicKey:
+		default:
+			return nil, ErrUnsupportedSMIMEKey
+		}
+		for _, addr := range c.EmailAddresses {
+			e.certs[strings.ToLower(addr)] = c
+		}
+	}
+
+	return e, nil
+}
+
+// AddCertificate adds a certificate the messages are always encrypted for,
+// whatever their recipients are. It is typically the certificate of the
+// sender so that the sent messages can be read again.
+func (e *SMIMEEncrypter) AddCertificate(cert *x509.Certificate) {
+	e.extra = append(e.extra, cert)
+}
+
+// SetSMIMEEncryption is a message setting to encrypt the message with e when
+// it is written. Writing the message fails with a MissingCertificateError
+// when the certificate of a recipient is unknown. When the message is also
+// signed with SetSMIMESigning, it is signed before being encrypted.
+func SetSMIMEEncryption(e *SMIMEEncrypter) MessageSetting {
+	return func(m *Message) {
+		m.smimeEncrypter = e
+	}
+}
+
+// Encrypt returns msg, a complete RFC 5322 message, with its MIME body
+// replaced by an encrypted application/pkcs7-mime entity readable by the
+// given recipients.
+func (e *SMIMEEncrypter) Encrypt(msg []byte, recipients []string) ([]byte, error) {
+	certs := make([]*x509.Certificate, 0, len(recipients)+len(e.extra))
+	for _, addr := range recipients {
+		c, ok := e.certs[strings.ToLower(addr)]
+		if !ok {
+			return nil, &MissingCertificateError{Address: addr}
+		}
+		certs = addCertificate(certs, c)
+	}
+	for _, c := range e.extra {
+		certs = addCertificate(certs, c)
+	}
+
+	header, entity := splitEntity(normalizeCRLF(msg))
+
+	gcm := e.ContentEncryption == AES256GCM
+	der, err := cmsEncrypt(entity, certs, gcm)
+	if err != nil {
+		return nil, err
+	}
+
+	smimeType := "enveloped-data"
+	if gcm {
+		smimeType = "authEnveloped-data"
+	}
+
+	var buf bytes.Buffer
+	for _, f := range header {
+		buf.WriteString(f)
+	}
+	buf.WriteString("Content-Type: application/pkcs7-mime; smime-type=" + smimeType + ";\r\n" +
+		" name=\"smime.p7m\"\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"Content-Disposition: attachment; filename=\"smime.p7m\"\r\n\r\n")
+	writeBase64Lines(&buf, der)
+	buf.WriteString("\r\n")
+
+	return buf.Bytes(), nil
+}
+
+func addCertificate(list []*x509.Certificate, c *x509.Certificate) []*x509.Certificate {
+	for _, l := range list {
+		if l == c {
+			return list
+		}
+	}
+
+	return append(list, c)
+}
+
+// splitEntity splits a message into its top level header fields and its MIME
+// entity, made of the Content-* header fields and the body.
+func splitEntity(msg []byte) ([]string, []byte) {
+	fields, body := splitMessage(msg)
+
+	var header []string
+	var entity bytes.Buffer
+	for _, f := range fields {
+		if strings.HasPrefix(strings.ToLower(fieldName(f)), "content-") {
+			entity.WriteString(f)
+		} else {
+			header = append(header, f)
+		}
+	}
+	entity.WriteString("\r\n")
+	entity.Write(body)
+
+	return header, entity.Bytes()
+}
+
+func writeBase64Lines(buf *bytes.Buffer, b []byte) {
+	w := base64.NewEncoder(base64.StdEncoding, newBase64LineWriter(buf))
+	w.Write(b)
+	w.Close()
+}
@@ -0,0 +1,402 @@
+package gomail
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"io"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"testing"
+	"time"
+)
+
+func testCertificate(t *testing.T, key crypto.Signer, email string) *x509.Certificate {
+	t.Helper()
+
+	tpl := &x509.Certificate{
+		SerialNumber:   big.NewInt(42),
+		Subject:        pkix.Name{CommonName: email},
+		EmailAddresses: []string{email},
+		NotBefore:      now().Add(-time.Hour),
+		NotAfter:       now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert
+}
+
+func TestSMIMESigning(t *testing.T) {
+	cert := testCertificate(t, testRSAKey, testFrom)
+	s, err := NewSMIMESigner(testRSAKey, cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewMessage(SetSMIMESigning(s))
+	m.SetHeader("From", testFrom)
+	m.SetHeader("To", testTo1)
+	m.SetHeader("Subject", "Hello!")
+	m.SetBody("text/plain", "¡Hola, señor!")
+	m.AddAlternative("text/html", "¡<b>Hola</b>, <i>señor</i>!</h1>")
+	m.Attach(mockCopyFile("/tmp/test.pdf"))
+
+	buf := new(bytes.Buffer)
+	if _, err := m.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := mail.ReadMessage(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := msg.Header.Get("Subject"); got != "Hello!" {
+		t.Errorf("invalid Subject, got %q", got)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mediaType != "multipart/signed" || params["protocol"] != "application/pkcs7-signature" || params["micalg"] != "sha-256" {
+		t.Fatalf("invalid Content-Type: %q", msg.Header.Get("Content-Type"))
+	}
+
+	body, err := io.ReadAll(msg.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The signed entity is everything between the first boundary and the
+	// CRLF preceding the second one.
+	delim := []byte("--" + params["boundary"] + "\r\n")
+	start := bytes.Index(body, delim) + len(delim)
+	end := start + bytes.Index(body[start:], []byte("\r\n--"+params["boundary"]))
+	entity := body[start:end]
+	if !bytes.HasPrefix(entity, []byte("Content-Type: multipart/mixed")) {
+		t.Errorf("invalid signed entity:\n%s", entity)
+	}
+
+	r := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	if _, err := r.NextPart(); err != nil {
+		t.Fatal(err)
+	}
+	sigPart, err := r.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := sigPart.Header.Get("Content-Type"); ct != `application/pkcs7-signature; name="smime.p7s"` {
+		t.Errorf("invalid signature Content-Type: %q", ct)
+	}
+	b64, _ := io.ReadAll(sigPart)
+	der, err := base64.StdEncoding.DecodeString(string(b64))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkCMSSignature(t, der, entity, cert)
+}
+
+func TestSMIMESignerInvalid(t *testing.T) {
+	if _, err := NewSMIMESigner(testRSAKey); err != ErrInvalidSMIMESigner {
+		t.Errorf("expected ErrInvalidSMIMESigner, got %v", err)
+	}
+	cert := testCertificate(t, testRSAKey, testFrom)
+	if _, err := NewSMIMESigner(testEd25519Key, cert); err != ErrUnsupportedSMIMEKey {
+		t.Errorf("expected ErrUnsupportedSMIMEKey, got %v", err)
+	}
+}
+
+// checkCMSSignature verifies a detached CMS signature of content.
+func checkCMSSignature(t *testing.T, der, content []byte, cert *x509.Certificate) {
+	t.Helper()
+
+	var ci cmsContentInfo
+	if _, err := asn1.Unmarshal(der, &ci); err != nil {
+		t.Fatal(err)
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		t.Fatalf("invalid content type %v", ci.ContentType)
+	}
+	var sd cmsSignedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		t.Fatal(err)
+	}
+	if len(sd.SignerInfos) != 1 {
+		t.Fatalf("invalid signer count, got %d", len(sd.SignerInfos))
+	}
+	si := sd.SignerInfos[0]
+	if si.SID.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+		t.Errorf("invalid signer serial number %v", si.SID.SerialNumber)
+	}
+
+	digest := sha256.Sum256(content)
+	var attrs []cmsAttribute
+	rest := si.SignedAttrs.Bytes
+	for len(rest) > 0 {
+		var a cmsAttribute
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &a); err != nil {
+			t.Fatal(err)
+		}
+		attrs = append(attrs, a)
+	}
+	found := false
+	for _, a := range attrs {
+		if a.Type.Equal(oidAttrMessageDigest) {
+			found = true
+			var got []byte
+			if _, err := asn1.Unmarshal(a.Values[0].FullBytes, &got); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, digest[:]) {
+				t.Error("message digest does not match the signed content")
+			}
+		}
+	}
+	if !found {
+		t.Error("message digest attribute not found")
+	}
+
+	set := append([]byte{}, si.SignedAttrs.FullBytes...)
+	set[0] = 0x31
+	if err := cert.CheckSignature(x509.SHA256WithRSA, set, si.Signature); err != nil {
+		t.Errorf("invalid signature: %v", err)
+	}
+}
+
+func TestSMIMEEncryption(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key crypto.Signer
+		alg SMIMEContentEncryption
+	}{
+		{testRSAKey, AES256GCM},
+		{testRSAKey, AES256CBC},
+		{ecKey, AES256GCM},
+		{ecKey, AES256CBC},
+	}
+
+	for _, test := range tests {
+		cert := testCertificate(t, test.key, testTo1)
+		e, err := NewSMIMEEncrypter(cert)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e.ContentEncryption = test.alg
+
+		m := NewMessage(SetSMIMEEncryption(e))
+		m.SetHeader("From", testFrom)
+		m.SetHeader("To", testTo1)
+		m.SetHeader("Subject", "Hello!")
+		m.SetBody("text/plain", testBody)
+
+		buf := new(bytes.Buffer)
+		if _, err := m.WriteTo(buf); err != nil {
+			t.Fatal(err)
+		}
+
+		msg, err := mail.ReadMessage(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantType := "authEnveloped-data"
+		if test.alg == AES256CBC {
+			wantType = "enveloped-data"
+		}
+		if mediaType != "application/pkcs7-mime" || params["smime-type"] != wantType {
+			t.Errorf("invalid Content-Type: %q", msg.Header.Get("Content-Type"))
+		}
+
+		b64, _ := io.ReadAll(msg.Body)
+		der, err := base64.StdEncoding.DecodeString(string(b64))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := decryptCMS(t, der, test.key)
+		want := "Content-Type: text/plain; charset=UTF-8\r\n" +
+			"Content-Transfer-Encoding: quoted-printable\r\n" +
+			"\r\n" +
+			testBody
+		compareBodies(t, string(got), want)
+	}
+}
+
+func TestSMIMEEncryptionMissingCertificate(t *testing.T) {
+	e, err := NewSMIMEEncrypter(testCertificate(t, testRSAKey, testTo1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewMessage(SetSMIMEEncryption(e))
+	m.SetHeader("From", testFrom)
+	m.SetHeader("To", testTo1)
+	m.SetHeader("Bcc", testTo2)
+	m.SetBody("text/plain", testBody)
+
+	_, err = m.WriteTo(io.Discard)
+	var missing *MissingCertificateError
+	if !errors.As(err, &missing) || missing.Address != testTo2 {
+		t.Errorf("expected MissingCertificateError for %q, got %v", testTo2, err)
+	}
+}
+
+// decryptCMS decrypts an EnvelopedData or AuthEnvelopedData structure with
+// the key of its only recipient.
+func decryptCMS(t *testing.T, der []byte, key crypto.Signer) []byte {
+	t.Helper()
+
+	var ci cmsContentInfo
+	if _, err := asn1.Unmarshal(der, &ci); err != nil {
+		t.Fatal(err)
+	}
+
+	var recipients []asn1.RawValue
+	var eci cmsEncryptedContentInfo
+	var mac []byte
+	switch {
+	case ci.ContentType.Equal(oidEnvelopedData):
+		var ed cmsEnvelopedData
+		if _, err := asn1.Unmarshal(ci.Content.Bytes, &ed); err != nil {
+			t.Fatal(err)
+		}
+		recipients, eci = ed.RecipientInfos, ed.EncryptedContentInfo
+	case ci.ContentType.Equal(oidAuthEnvelopedData):
+		var ed cmsAuthEnvelopedData
+		if _, err := asn1.Unmarshal(ci.Content.Bytes, &ed); err != nil {
+			t.Fatal(err)
+		}
+		recipients, eci, mac = ed.RecipientInfos, ed.AuthEncryptedContentInfo, ed.MAC
+	default:
+		t.Fatalf("invalid content type %v", ci.ContentType)
+	}
+	if len(recipients) != 1 {
+		t.Fatalf("invalid recipient count, got %d", len(recipients))
+	}
+
+	var cek []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		var ktri cmsKeyTransRecipientInfo
+		if _, err := asn1.Unmarshal(recipients[0].FullBytes, &ktri); err != nil {
+			t.Fatal(err)
+		}
+		var err error
+		cek, err = rsa.DecryptOAEP(sha256.New(), nil, k, ktri.EncryptedKey, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		var kari cmsKeyAgreeRecipientInfo
+		if _, err := asn1.UnmarshalWithParams(recipients[0].FullBytes, &kari, "tag:1"); err != nil {
+			t.Fatal(err)
+		}
+		var orig cmsOriginatorPublicKey
+		if _, err := asn1.UnmarshalWithParams(kari.Originator.Bytes, &orig, "tag:1"); err != nil {
+			t.Fatal(err)
+		}
+		priv, err := k.ECDH()
+		if err != nil {
+			t.Fatal(err)
+		}
+		remote, err := priv.Curve().NewPublicKey(orig.PublicKey.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		z, err := priv.ECDH(remote)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sharedInfo, _ := asn1.Marshal(cmsECCSharedInfo{
+			KeyInfo:     pkix.AlgorithmIdentifier{Algorithm: oidAES256Wrap},
+			SuppPubInfo: []byte{0, 0, 1, 0},
+		})
+		h := sha256.New()
+		h.Write(z)
+		h.Write([]byte{0, 0, 0, 1})
+		h.Write(sharedInfo)
+		cek = aesKeyUnwrap(t, h.Sum(nil), kari.RecipientEncryptedKeys[0].EncryptedKey)
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mac != nil {
+		var params cmsGCMParameters
+		if _, err := asn1.Unmarshal(eci.ContentEncryptionAlgorithm.Parameters.FullBytes, &params); err != nil {
+			t.Fatal(err)
+		}
+		aead, _ := cipher.NewGCM(block)
+		plain, err := aead.Open(nil, params.Nonce, append(eci.EncryptedContent, mac...), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return plain
+	}
+
+	var iv []byte
+	if _, err := asn1.Unmarshal(eci.ContentEncryptionAlgorithm.Parameters.FullBytes, &iv); err != nil {
+		t.Fatal(err)
+	}
+	plain := make([]byte, len(eci.EncryptedContent))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, eci.EncryptedContent)
+	return plain[:len(plain)-int(plain[len(plain)-1])]
+}
+
+func aesKeyUnwrap(t *testing.T, kek, wrapped []byte) []byte {
+	t.Helper()
+
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := len(wrapped)/8 - 1
+	a := append([]byte{}, wrapped[:8]...)
+	r := append([]byte{}, wrapped[8:]...)
+	b := make([]byte, 16)
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			copy(b, a)
+			tt := uint64(n*j + i)
+			for k := 7; k >= 0; k-- {
+				b[k] ^= byte(tt)
+				tt >>= 8
+			}
+			copy(b[8:], r[(i-1)*8:i*8])
+			block.Decrypt(b, b)
+			copy(a, b[:8])
+			copy(r[(i-1)*8:], b[8:])
+		}
+	}
+	if !bytes.Equal(a, []byte{0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6}) {
+		t.Fatal("invalid key wrap integrity check")
+	}
+	return r
+}
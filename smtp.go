@@ -3,10 +3,12 @@ package gomail
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/smtp"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -38,8 +40,9 @@ type Dialer struct {
 	//
 	// This option has no effect if SSL is set to true.
 	StartTLSPolicy StartTLSPolicy
-	// Timeout to use for read/write operations. Defaults to 10 seconds, can
-	// be set to 0 to disable timeouts.
+	// Timeout to use for the connection and for each read/write operation of
+	// the SMTP session. Defaults to 10 seconds, can be set to 0 to disable
+	// timeouts.
 	Timeout time.Duration
 	// KeepAlive specifies the interval between keep-alive probes for
 	// an active network connection, can be set to 0 to disable timeouts.
@@ -47,10 +50,27 @@ type Dialer struct {
 	// Whether we should retry mailing if the connection returned an error,
 	// defaults to true.
 	RetryFailure bool
+	// RecipientPolicy decides whether a message is sent when the SMTP server
+	// rejects some of its recipients. It defaults to AllRecipients. The
+	// rejected recipients are reported with a RecipientsError in both cases.
+	RecipientPolicy RecipientPolicy
+	// MaxRecipientsPerMessage is the maximum number of recipients of a mail
+	// transaction. The messages with more recipients are sent in several
+	// transactions on the same connection, as they are when the server
+	// replies "452 too many recipients". A Message is written again for each
+	// transaction, while the content of other messages is kept in memory to
+	// be sent again. It defaults to 0, which means no limit.
+	MaxRecipientsPerMessage int
 	// SSL defines whether an SSL connection is used. It should be false in
 	// most cases since the authentication mechanism should use the STARTTLS
 	// extension instead.
 	SSL bool
+	// MaxSize is the maximum size in bytes of the messages sent, as computed
+	// by Message.Size. Larger messages fail with a MessageTooLargeError before
+	// the MAIL command is sent. It defaults to 0, which means no limit. The
+	// limit advertised by the server with the SIZE extension is enforced the
+	// same way.
+	MaxSize int64
 
 	DialMiddlewares DialMiddlewares
 	SendMiddlewares SendMiddlewares
@@ -87,12 +107,40 @@ var dialContext = func(ctx context.Context, d *Dialer) (net.Conn, error) {
 
 // Dial dials and authenticates to an SMTP server. The returned SendCloser
 // should be closed when done using it.
+//
+// The reads and writes of the session time out after Timeout or at the
+// deadline of ctx. When ctx is cancelled, the connection is closed and the
+// error returned wraps ctx.Err().
 func (d *Dialer) Dial(ctx context.Context) (SendCloser, error) {
-	conn, err := dialContext(ctx, d)
+	s, err := d.dial(ctx)
 	if err != nil {
 		return nil, err
 	}
+	return s, nil
+}
+
+func (d *Dialer) dial(ctx context.Context) (*smtpSender, error) {
+	netConn, err := dialContext(ctx, d)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &contextConn{Conn: netConn, timeout: d.Timeout}
+	var c smtpClient
+	err = conn.with(ctx, func() error {
+		c, err = d.hello(conn)
+		return err
+	})
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return &smtpSender{smtpClient: c, conn: conn, d: d}, nil
+}
 
+// hello starts the SMTP session on conn, then authenticates.
+func (d *Dialer) hello(conn net.Conn) (smtpClient, error) {
 	if d.SSL {
 		conn = tlsClient(conn, d.tlsConfig())
 	}
@@ -148,7 +196,7 @@ func (d *Dialer) Dial(ctx context.Context) (SendCloser, error) {
 		}
 	}
 
-	return &smtpSender{c, conn, d}, nil
+	return c, nil
 }
 
 func (d *Dialer) tlsConfig() *tls.Config {
@@ -219,8 +267,11 @@ func (d *Dialer) DialAndSend(ctx context.Context, m ...*Message) error {
 
 type smtpSender struct {
 	smtpClient
-	conn net.Conn
+	conn *contextConn
 	d    *Dialer
+	// aborted reports that the connection has been closed to abort a
+	// transaction, in which case the next message is sent on a new one.
+	aborted bool
 }
 
 var _ SendCloser = (*smtpSender)(nil)
@@ -229,50 +280,80 @@ func (c *smtpSender) retryError(err error) bool {
 	if !c.d.RetryFailure {
 		return false
 	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
 
-	if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+	var nerr net.Error
+	if errors.As(err, &nerr) && nerr.Timeout() {
 		return true
 	}
 
-	return err == io.EOF
+	return errors.Is(err, io.EOF)
+}
+
+// networkError reports whether err is a failure of the connection, after
+// which the state of the SMTP session is unknown.
+func networkError(err error) bool {
+	var nerr net.Error
+	return errors.As(err, &nerr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// messageSize returns the size of msg, if it is able to report it, as a
+// Message or a *bytes.Reader do.
+func messageSize(msg io.WriterTo) (size int64, ok bool, err error) {
+	switch m := msg.(type) {
+	case interface{ Size() (int64, error) }:
+		size, err = m.Size()
+		return size, err == nil, err
+	case interface{ Len() int }:
+		return int64(m.Len()), true, nil
+	default:
+		return 0, false, nil
+	}
+}
+
+// setSize checks the size of the message against Dialer.MaxSize and the
+// limit advertised by the server with the SIZE extension (RFC 1870), and
+// declares it on the MAIL command when the server supports SIZE. Only messages
+// able to report their size are checked.
+func (c *smtpSender) setSize(t *transaction) error {
+	size, ok, err := messageSize(t.msg)
+	if !ok {
+		return err
+	}
+
+	if c.d.MaxSize > 0 && size > c.d.MaxSize {
+		return &MessageTooLargeError{Size: size, MaxSize: c.d.MaxSize}
+	}
+
+	ok, param := c.Extension("SIZE")
+	if !ok {
+		return nil
+	}
+	// The limit is optional, and 0 means that there is none.
+	if limit, err := strconv.ParseInt(param, 10, 64); err == nil && limit > 0 && size > limit {
+		return &MessageTooLargeError{Size: size, MaxSize: limit, Server: true}
+	}
+	t.mailParams = append(t.mailParams, "SIZE="+strconv.FormatInt(size, 10))
+	return nil
 }
 
 func (c *smtpSender) Send(ctx context.Context, from string, to []string, msg io.WriterTo) error {
+	// The settings of a Message are read before the middlewares may replace
+	// it, as WithDKIM does with the signed content.
+	ctx = withMessageSettings(ctx, msg)
+	requireTLS := requireTLSFor(ctx)
 	return invokeSend(
 		ctx,
 		c.d.SendMiddlewares,
 		func(ctx context.Context, from string, to []string, msg io.WriterTo) error {
-			if err := c.Mail(from); err != nil {
-				if c.retryError(err) {
-					// This is probably due to a timeout, so reconnect and try again.
-					if sc, derr := c.d.Dial(ctx); derr == nil {
-						if s, ok := sc.(*smtpSender); ok {
-							*c = *s
-							return c.Send(ctx, from, to, msg)
-						}
-					}
-				}
-
-				return err
-			}
-
-			for _, addr := range to {
-				if err := c.Rcpt(addr); err != nil {
-					return err
-				}
+			ctx = withMessageSettings(ctx, msg)
+			if requireTLS {
+				// A middleware cannot lift the requirement.
+				ctx = WithRequireTLS(ctx, true)
 			}
-
-			w, err := c.Data()
-			if err != nil {
-				return err
-			}
-
-			if _, err = msg.WriteTo(w); err != nil {
-				_ = w.Close()
-				return err
-			}
-
-			return w.Close()
+			return c.send(ctx, from, to, msg)
 		},
 		from,
 		to,
@@ -280,7 +361,374 @@ func (c *smtpSender) Send(ctx context.Context, from string, to []string, msg io.
 	)
 }
 
+// send sends msg, as passed on by the send middlewares, on the connection of
+// c. The message is sent again on a new connection when the server closed the
+// idle one, without running the middlewares again.
+func (c *smtpSender) send(ctx context.Context, from string, to []string, msg io.WriterTo) error {
+	if c.aborted {
+		if err := c.redial(ctx); err != nil {
+			return err
+		}
+	}
+
+	conn := c.conn
+	mailFailed := false
+	err := conn.with(ctx, func() error {
+		var err error
+		mailFailed, err = c.transaction(ctx, from, to, msg)
+		return err
+	})
+	if err != nil && !c.aborted && networkError(err) {
+		// The session cannot be resumed: the next message is sent on a new
+		// connection.
+		c.aborted = true
+		_ = conn.Close()
+	}
+	if mailFailed && c.retryError(err) {
+		// This is probably due to a timeout, so reconnect and try again.
+		if s, derr := c.d.dial(ctx); derr == nil {
+			_ = conn.Close()
+			*c = *s
+			return c.send(ctx, from, to, msg)
+		}
+	}
+
+	return err
+}
+
+// redial replaces the connection of c with a new one.
+func (c *smtpSender) redial(ctx context.Context) error {
+	s, err := c.d.dial(ctx)
+	if err != nil {
+		return err
+	}
+	_ = c.conn.Close()
+	*c = *s
+	return nil
+}
+
+// pipeliner is implemented by the clients able to pipeline the commands of a
+// mail transaction.
+type pipeliner interface {
+	pipeline(t *transaction) (*pipelineReplies, error)
+}
+
+// chunker is implemented by the clients able to send messages with BDAT.
+type chunker interface {
+	bdat(binary bool) (io.WriteCloser, error)
+}
+
+// A transaction is the envelope and the content of a message sent on an SMTP
+// connection.
+type transaction struct {
+	from       string
+	mailParams []string
+	to         []string
+	// dsn holds the delivery status notification parameters of the RCPT
+	// commands. It is nil when there are none.
+	dsn *DSN
+	msg io.WriterTo
+	// chunking reports that the content is sent with BDAT rather than DATA.
+	chunking bool
+	// binary reports that the content is sent with BODY=BINARYMIME, and
+	// must not be altered.
+	binary bool
+	// more reports that other recipients are sent the message in following
+	// transactions.
+	more bool
+}
+
+// transaction sends msg from from to the recipients to, with the commands of
+// the transaction pipelined and the content sent with BDAT when the server
+// supports it. It reports whether the MAIL command failed.
+//
+// The recipients are split into several transactions when they exceed
+// Dialer.MaxRecipientsPerMessage or when the server replies that there are
+// too many of them.
+func (c *smtpSender) transaction(ctx context.Context, from string, to []string, msg io.WriterTo) (mailFailed bool, err error) {
+	t := &transaction{from: from, to: to, msg: msg}
+	if _, ok := c.smtpClient.(chunker); ok {
+		t.chunking, _ = c.Extension("CHUNKING")
+	}
+	if err := c.setBody(t, bodyFor(ctx)); err != nil {
+		return false, err
+	}
+	if err := c.setSize(t); err != nil {
+		return false, err
+	}
+	if err := c.setDSN(t, dsnFor(ctx)); err != nil {
+		return false, err
+	}
+	if err := c.setRequireTLS(t, requireTLSFor(ctx)); err != nil {
+		return false, err
+	}
+	if len(to) > 1 && !replayable(t.msg) {
+		// The content may have to be sent again in other transactions.
+		t.msg = newReplayableMessage(t.msg)
+	}
+
+	var result SendResult
+	remaining := to
+	for {
+		n := len(remaining)
+		if limit := c.d.MaxRecipientsPerMessage; limit > 0 && n > limit {
+			n = limit
+		}
+		batch := *t
+		batch.to = remaining[:n]
+		batch.more = n < len(remaining)
+
+		var batchResult SendResult
+		var deferred []string
+		batchResult, deferred, mailFailed, err = c.sendBatch(&batch)
+		remaining = append(deferred[:len(deferred):len(deferred)], remaining[n:]...)
+
+		result.Accepted = append(result.Accepted, batchResult.Accepted...)
+		result.Rejected = append(result.Rejected, batchResult.Rejected...)
+		result.Batches = append(result.Batches, BatchResult{
+			Recipients: without(batch.to, deferred),
+			Err:        err,
+		})
+
+		var recipientsErr *RecipientsError
+		if err != nil && !(errors.As(err, &recipientsErr) && recipientsErr.Sent) || c.aborted {
+			remaining = append(batchResult.untried(without(batch.to, deferred)), remaining...)
+			break
+		}
+		if len(remaining) == 0 {
+			break
+		}
+	}
+
+	if len(result.Batches) == 1 && (mailFailed || len(remaining) == 0) {
+		if err == nil {
+			result.Batches = nil
+			reportSendResult(ctx, result)
+		}
+		return mailFailed, err
+	}
+	err = c.batchesError(result, remaining)
+	if err == nil {
+		reportSendResult(ctx, result)
+	}
+	return false, err
+}
+
+// sendBatch sends the message in a single transaction to the recipients of t.
+// It returns the recipients deferred to another transaction as the server
+// replied that there are too many of them. The transaction is reset when the
+// server rejects it, so that the connection can send other messages.
+func (c *smtpSender) sendBatch(t *transaction) (result SendResult, deferred []string, mailFailed bool, err error) {
+	if p, ok := c.smtpClient.(pipeliner); ok {
+		if ok, _ := c.Extension("PIPELINING"); ok {
+			result, deferred, mailFailed, err = c.sendPipelined(p, t)
+		} else {
+			result, deferred, mailFailed, err = c.sendLockStep(t)
+		}
+	} else {
+		result, deferred, mailFailed, err = c.sendLockStep(t)
+	}
+
+	if r, ok := c.smtpClient.(interface{ Reset() error }); ok && !c.aborted {
+		var smtpErr *SMTPError
+		var recipientsErr *RecipientsError
+		complete := errors.As(err, &recipientsErr) && recipientsErr.Sent
+		if !complete && errors.As(err, &smtpErr) {
+			_ = r.Reset()
+		}
+	}
+
+	return result, deferred, mailFailed, err
+}
+
+type bodyKey struct{}
+
+// bodyFor returns the encoding of the body of the Message sent with ctx, or
+// an empty string if it is unknown.
+func bodyFor(ctx context.Context) Encoding {
+	body, _ := ctx.Value(bodyKey{}).(Encoding)
+	return body
+}
+
+// setBody sets the BODY parameter of the MAIL command from the encodings of
+// the message (RFC 6152 and RFC 3030). The Unencoded parts of a Message are
+// downgraded to 7-bit encodings when the server does not support 8BITMIME.
+// Other messages, such as a Message replaced by a send middleware, are
+// declared from body: Binary as BINARYMIME, and Unencoded or unknown bodies
+// as 8BITMIME when the server supports it. As their Unencoded parts cannot be
+// downgraded anymore, they fail with Err8BitMIMEUnsupported otherwise.
+func (c *smtpSender) setBody(t *transaction, body Encoding) error {
+	m, ok := t.msg.(*Message)
+	if ok {
+		body = m.bodyEncoding()
+	}
+	switch {
+	case body == Binary:
+		// Binary content cannot be sent with DATA (RFC 3030, section 3).
+		if ok, _ := c.Extension("BINARYMIME"); !ok || !t.chunking {
+			return ErrBinaryMIMEUnsupported
+		}
+		t.mailParams = append(t.mailParams, "BODY=BINARYMIME")
+		t.binary = true
+	case body == Unencoded || body == "":
+		if ok, _ := c.Extension("8BITMIME"); ok {
+			t.mailParams = append(t.mailParams, "BODY=8BITMIME")
+		} else if m != nil {
+			t.msg = downgradedMessage{m}
+		} else if body == Unencoded {
+			return Err8BitMIMEUnsupported
+		}
+	}
+	return nil
+}
+
+// setDSN adds the delivery status notification parameters dsn to the MAIL
+// and RCPT commands when the server supports the DSN extension (RFC 3461).
+func (c *smtpSender) setDSN(t *transaction, dsn *DSN) error {
+	if dsn == nil {
+		return nil
+	}
+	if err := dsn.validate(); err != nil {
+		return err
+	}
+	if ok, _ := c.Extension("DSN"); !ok {
+		if dsn.Required {
+			return ErrDSNUnsupported
+		}
+		return nil
+	}
+
+	t.mailParams = append(t.mailParams, dsn.mailParams()...)
+	t.dsn = dsn
+	return nil
+}
+
+// rcptParams returns the parameters of the RCPT command of the recipient to.
+func (t *transaction) rcptParams(to string) []string {
+	if t.dsn == nil {
+		return nil
+	}
+	return t.dsn.rcptParams(to)
+}
+
+// data returns the writer of the content of t.
+func (c *smtpSender) data(t *transaction) (io.WriteCloser, error) {
+	if t.chunking {
+		return c.smtpClient.(chunker).bdat(t.binary)
+	}
+	return c.Data()
+}
+
+// sendLockStep sends the commands of the transaction one by one, waiting for
+// the reply to each of them. The recipients following one deferred by the
+// server are deferred too.
+func (c *smtpSender) sendLockStep(t *transaction) (result SendResult, deferred []string, mailFailed bool, err error) {
+	if err := c.Mail(t.from, t.mailParams...); err != nil {
+		return result, nil, true, err
+	}
+	for i, addr := range t.to {
+		err := c.Rcpt(addr, t.rcptParams(addr)...)
+		if tooManyRecipients(err) && len(result.Accepted) > 0 {
+			deferred = t.to[i:]
+			break
+		}
+		if err := result.add(addr, err); err != nil {
+			return result, nil, false, err
+		}
+	}
+	recipientsErr := c.recipientsError(result)
+	if recipientsErr != nil && !recipientsErr.Sent {
+		return result, deferred, false, recipientsErr
+	}
+
+	w, err := c.data(t)
+	if err != nil {
+		return result, deferred, false, err
+	}
+	return result, deferred, false, sendResult(writeData(w, t.content(deferred)), recipientsErr)
+}
+
+// sendPipelined sends the MAIL, RCPT and DATA commands of the transaction in
+// a single group and then matches their replies. As in lock-step mode, the
+// message is only sent to the accepted recipients if the RecipientPolicy of
+// the Dialer allows it.
+func (c *smtpSender) sendPipelined(p pipeliner, t *transaction) (result SendResult, deferred []string, mailFailed bool, err error) {
+	r, err := p.pipeline(t)
+	if r == nil {
+		return result, nil, true, err
+	}
+	if err != nil {
+		return result, nil, false, err
+	}
+	if r.mail != nil {
+		c.abortData(r)
+		return result, nil, true, r.mail
+	}
+
+	accepted := 0
+	for _, err := range r.rcpt {
+		if err == nil {
+			accepted++
+		}
+	}
+	for i, addr := range t.to {
+		if tooManyRecipients(r.rcpt[i]) && accepted > 0 {
+			deferred = append(deferred, addr)
+			continue
+		}
+		_ = result.add(addr, r.rcpt[i])
+	}
+	recipientsErr := c.recipientsError(result)
+	if recipientsErr != nil && !recipientsErr.Sent {
+		c.abortData(r)
+		return result, deferred, false, recipientsErr
+	}
+	if r.data != nil {
+		return result, deferred, false, r.data
+	}
+
+	w := r.w
+	if t.chunking {
+		if w, err = c.data(t); err != nil {
+			return result, deferred, false, err
+		}
+	}
+	return result, deferred, false, sendResult(writeData(w, t.content(deferred)), recipientsErr)
+}
+
+// abortData aborts the transaction of r if DATA has been accepted: it cannot
+// be cancelled anymore without sending the message to the accepted
+// recipients, so the connection is closed.
+func (c *smtpSender) abortData(r *pipelineReplies) {
+	if r.w != nil {
+		c.aborted = true
+		_ = c.smtpClient.Close()
+	}
+}
+
+// sendResult returns the error of a message sent to the accepted recipients:
+// err if the message could not be sent, recipientsErr otherwise.
+func sendResult(err error, recipientsErr *RecipientsError) error {
+	if err != nil || recipientsErr == nil {
+		return err
+	}
+	return recipientsErr
+}
+
+// writeData writes msg to the writer returned by the DATA or BDAT command.
+func writeData(w io.WriteCloser, msg io.WriterTo) error {
+	if _, err := msg.WriteTo(w); err != nil {
+		_ = w.Close()
+		return err
+	}
+
+	return w.Close()
+}
+
 func (c *smtpSender) Close() error {
+	if c.aborted {
+		return nil
+	}
 	return c.Quit()
 }
 
@@ -288,7 +736,7 @@ func (c *smtpSender) Close() error {
 var (
 	tlsClient     = tls.Client
 	smtpNewClient = func(conn net.Conn, host string) (smtpClient, error) {
-		return smtp.NewClient(conn, host)
+		return newSMTPConn(conn, host)
 	}
 )
 
@@ -297,9 +745,111 @@ type smtpClient interface {
 	Extension(string) (bool, string)
 	StartTLS(*tls.Config) error
 	Auth(smtp.Auth) error
-	Mail(string) error
-	Rcpt(string) error
+	Mail(from string, params ...string) error
+	Rcpt(to string, params ...string) error
 	Data() (io.WriteCloser, error)
 	Quit() error
 	Close() error
 }
+
+// A contextConn is a connection whose reads and writes time out after timeout
+// or at the deadline of the context of the current operation. It is closed
+// when this context is cancelled.
+type contextConn struct {
+	net.Conn
+	timeout time.Duration
+	ctx     context.Context
+}
+
+// with runs fn with ctx as the context of the operations on c. The errors
+// caused by the cancellation or the deadline of ctx wrap ctx.Err().
+func (c *contextConn) with(ctx context.Context, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return contextError(ctx, err)
+	}
+
+	c.ctx = ctx
+	defer func() {
+		c.ctx = nil
+	}()
+
+	if ctx.Done() != nil {
+		stop := make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			select {
+			case <-ctx.Done():
+				// Unblock the pending reads and writes.
+				_ = c.Conn.Close()
+			case <-stop:
+			}
+		}()
+		defer func() {
+			close(stop)
+			<-done
+		}()
+	}
+
+	err := fn()
+	if err != nil && c.contextDone(ctx) {
+		// The session is interrupted in the middle of a command.
+		_ = c.Conn.Close()
+		return contextError(ctx, err)
+	}
+	return err
+}
+
+// contextDone reports whether ctx is cancelled or past its deadline. The
+// deadline is checked too as the connection can time out just before ctx
+// does.
+func (c *contextConn) contextDone(ctx context.Context) bool {
+	if ctx.Err() != nil {
+		return true
+	}
+	deadline, ok := ctx.Deadline()
+	return ok && !time.Now().Before(deadline)
+}
+
+func contextError(ctx context.Context, err error) error {
+	ctxErr := ctx.Err()
+	if ctxErr == nil {
+		ctxErr = context.DeadlineExceeded
+	}
+	if err == ctxErr {
+		return fmt.Errorf("gomail: %w", ctxErr)
+	}
+	return fmt.Errorf("gomail: %w: %v", ctxErr, err)
+}
+
+func (c *contextConn) Read(p []byte) (int, error) {
+	if err := c.setDeadline(); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(p)
+}
+
+func (c *contextConn) Write(p []byte) (int, error) {
+	if err := c.setDeadline(); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(p)
+}
+
+// setDeadline sets the deadline of the next read or write.
+func (c *contextConn) setDeadline() error {
+	var deadline time.Time
+	if c.timeout > 0 {
+		deadline = time.Now().Add(c.timeout)
+	}
+	if c.ctx != nil {
+		if err := c.ctx.Err(); err != nil {
+			return err
+		}
+		if d, ok := c.ctx.Deadline(); ok && (deadline.IsZero() || d.Before(deadline)) {
+			deadline = d
+		}
+	}
+
+	return c.Conn.SetDeadline(deadline)
+}
@@ -1,14 +1,18 @@
 package gomail
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"crypto/tls"
+	"errors"
 	"io"
 	"net"
 	"net/smtp"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 const (
@@ -263,7 +267,6 @@ func TestDialerTimeoutNoRetry(t *testing.T) {
 		"Extension STARTTLS",
 		"StartTLS",
 		"Mail " + testFrom,
-		"Quit",
 	})
 
 	if err.Error() != "gomail: could not send email 1: EOF" {
@@ -287,6 +290,10 @@ func (c *mockClient) Hello(localName string) error {
 }
 
 func (c *mockClient) Extension(ext string) (bool, string) {
+	// The server only advertises STARTTLS and AUTH.
+	if ext != "STARTTLS" && ext != "AUTH" {
+		return false, ""
+	}
 	c.do("Extension " + ext)
 	ok := true
 	if ext == "STARTTLS" {
@@ -309,8 +316,8 @@ func (c *mockClient) Auth(a smtp.Auth) error {
 	return nil
 }
 
-func (c *mockClient) Mail(from string) error {
-	c.do("Mail " + from)
+func (c *mockClient) Mail(from string, params ...string) error {
+	c.do(strings.Join(append([]string{"Mail " + from}, params...), " "))
 	if c.timeout {
 		c.timeout = false
 		return io.EOF
@@ -318,8 +325,8 @@ func (c *mockClient) Mail(from string) error {
 	return nil
 }
 
-func (c *mockClient) Rcpt(to string) error {
-	c.do("Rcpt " + to)
+func (c *mockClient) Rcpt(to string, params ...string) error {
+	c.do(strings.Join(append([]string{"Rcpt " + to}, params...), " "))
 	return nil
 }
 
@@ -419,7 +426,7 @@ func doTestSendMail(t *testing.T, d *Dialer, testClient *mockClient, want []stri
 	}
 
 	tlsClient = func(conn net.Conn, config *tls.Config) *tls.Conn {
-		if conn != testConn {
+		if c, ok := conn.(*contextConn); !ok || c.Conn != testConn {
 			t.Errorf("Invalid conn, got %#v, want %#v", conn, testConn)
 		}
 		assertConfig(t, config, testClient.config)
@@ -447,3 +454,142 @@ func assertConfig(t *testing.T, got, want *tls.Config) {
 		t.Errorf("Invalid field InsecureSkipVerify in config, got %v, want %v", got.InsecureSkipVerify, want.InsecureSkipVerify)
 	}
 }
+
+func TestDialerMaxSize(t *testing.T) {
+	d := NewDialer(testHost, testPort, "user", "pwd")
+	d.MaxSize = 10
+	testClient := &mockClient{
+		t:        t,
+		addr:     addr(d.Host, d.Port),
+		config:   d.TLSConfig,
+		startTLS: true,
+	}
+
+	err := doTestSendMail(t, d, testClient, []string{
+		"Extension STARTTLS",
+		"StartTLS",
+		"Extension AUTH",
+		"Auth",
+		"Quit",
+	})
+
+	var tooLarge *MessageTooLargeError
+	if !errors.As(err, &tooLarge) {
+		t.Fatalf("expected a MessageTooLargeError, got %v", err)
+	}
+	size, _ := getTestMessage().Size()
+	if tooLarge.Size != size || tooLarge.MaxSize != 10 {
+		t.Errorf("invalid error, got %+v, want size %d and max size 10", tooLarge, size)
+	}
+}
+
+// stalledSMTPServer serves conn, replying to the first commands with replies,
+// then reading the next ones without ever replying. closed is closed when the
+// client closes the connection.
+func stalledSMTPServer(conn net.Conn, replies ...string) (closed chan struct{}) {
+	closed = make(chan struct{})
+	go func() {
+		defer close(closed)
+		r := bufio.NewReader(conn)
+		io.WriteString(conn, "220 mx.example.com\r\n")
+		for _, reply := range replies {
+			if _, err := r.ReadString('\n'); err != nil {
+				return
+			}
+			io.WriteString(conn, reply+"\r\n")
+		}
+		io.Copy(io.Discard, r)
+	}()
+	return closed
+}
+
+func testStalledDialer(t *testing.T, replies ...string) (*Dialer, chan struct{}) {
+	client, server := net.Pipe()
+	closed := stalledSMTPServer(server, replies...)
+
+	origDial, origClient := dialContext, smtpNewClient
+	t.Cleanup(func() {
+		dialContext, smtpNewClient = origDial, origClient
+		client.Close()
+		server.Close()
+	})
+	dialContext = func(ctx context.Context, d *Dialer) (net.Conn, error) {
+		return client, nil
+	}
+	smtpNewClient = func(conn net.Conn, host string) (smtpClient, error) {
+		return newSMTPConn(conn, host)
+	}
+
+	return &Dialer{Host: testHost, Port: testPort, LocalName: "client", StartTLSPolicy: NoStartTLS}, closed
+}
+
+func TestDialerContextCancel(t *testing.T) {
+	d, closed := testStalledDialer(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	_, err := d.Dial(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want %v", err, context.Canceled)
+	}
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Error("the connection should be closed")
+	}
+}
+
+func TestDialerReadTimeout(t *testing.T) {
+	d, _ := testStalledDialer(t)
+	d.Timeout = 50 * time.Millisecond
+
+	_, err := d.Dial(context.Background())
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("got error %v, want a timeout", err)
+	}
+}
+
+func TestSendContextDeadline(t *testing.T) {
+	d, closed := testStalledDialer(t, "250 mx.example.com")
+	d.Timeout = time.Minute
+	d.RetryFailure = true
+
+	s, err := d.Dial(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = Send(ctx, s, getTestMessage())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Send returned after %v", elapsed)
+	}
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Error("the connection should be closed")
+	}
+}
+
+func TestSendContextCancelled(t *testing.T) {
+	d, _ := testStalledDialer(t, "250 mx.example.com")
+
+	s, err := d.Dial(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := Send(ctx, s, getTestMessage()); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,629 @@
+package gomail
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+)
+
+// An SMTPError is a negative reply of an SMTP server.
+type SMTPError struct {
+	// Code is the three digits reply code, such as 550.
+	Code int
+	// EnhancedCode is the enhanced status code of the reply (RFC 3463), such
+	// as "5.1.1". It is empty when the server does not send one.
+	EnhancedCode string
+	// Message is the text of the reply, without the codes. The lines of
+	// multi-line replies are separated by "\n".
+	Message string
+}
+
+func (e *SMTPError) Error() string {
+	if e.EnhancedCode != "" {
+		return fmt.Sprintf("gomail: SMTP error %d %s: %s", e.Code, e.EnhancedCode, e.Message)
+	}
+	return fmt.Sprintf("gomail: SMTP error %d: %s", e.Code, e.Message)
+}
+
+// Temporary reports whether the error is transient (4xx), in which case the
+// command can be retried later.
+func (e *SMTPError) Temporary() bool {
+	return e.Code >= 400 && e.Code < 500
+}
+
+func (*SMTPError) Is(err error) bool {
+	if _, ok := err.(*SMTPError); ok {
+		return true
+	}
+	return false
+}
+
+// smtpConn is the SMTP client (RFC 5321) used by Dialer. Unlike
+// net/smtp.Client, it reports the reply codes and enhanced status codes of
+// the server, and sends parameters with the MAIL and RCPT commands.
+type smtpConn struct {
+	conn net.Conn
+	text *textproto.Conn
+	// serverName is the name of the server used to authenticate.
+	serverName string
+	localName  string
+	// ext holds the extensions advertised by the server, keyed by their
+	// upper case keyword, with their parameters.
+	ext   map[string]string
+	auth  []string
+	tls   bool
+	hello bool
+}
+
+var _ smtpClient = (*smtpConn)(nil)
+
+// newSMTPConn returns a client using the connection conn to the server
+// serverName, after reading the greeting of the server.
+func newSMTPConn(conn net.Conn, serverName string) (*smtpConn, error) {
+	c := &smtpConn{
+		conn:       conn,
+		text:       textproto.NewConn(conn),
+		serverName: serverName,
+		localName:  "localhost",
+	}
+	_, c.tls = conn.(*tls.Conn)
+
+	if _, err := c.readReply(220); err != nil {
+		_ = c.text.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Hello sends EHLO, or HELO if the server does not support EHLO, with the
+// given host name. It is optional: "localhost" is sent before the first
+// command otherwise.
+func (c *smtpConn) Hello(localName string) error {
+	if err := validateLine(localName); err != nil {
+		return err
+	}
+	if c.hello {
+		return ErrSMTPHelloSent
+	}
+
+	c.localName = localName
+	return c.sendHello()
+}
+
+func (c *smtpConn) ensureHello() error {
+	if c.hello {
+		return nil
+	}
+	return c.sendHello()
+}
+
+func (c *smtpConn) sendHello() error {
+	c.hello = true
+	c.ext, c.auth = nil, nil
+
+	msg, err := c.cmd(250, "EHLO %s", c.localName)
+	if err != nil {
+		var smtpErr *SMTPError
+		if !errors.As(err, &smtpErr) || smtpErr.Code/100 != 5 {
+			return err
+		}
+		// The server does not support ESMTP.
+		_, err = c.cmd(250, "HELO %s", c.localName)
+		return err
+	}
+
+	c.ext = make(map[string]string)
+	lines := strings.Split(msg, "\n")
+	for _, line := range lines[1:] {
+		keyword, params, _ := strings.Cut(line, " ")
+		keyword = strings.ToUpper(keyword)
+		// Some old servers advertise AUTH=LOGIN rather than AUTH LOGIN.
+		if strings.HasPrefix(keyword, "AUTH=") {
+			params = strings.TrimSpace(line[len("AUTH="):] + " " + params)
+			keyword = "AUTH"
+		}
+		if keyword == "AUTH" {
+			for _, mech := range strings.Fields(strings.ToUpper(params)) {
+				if !hasParam(c.auth, mech) {
+					c.auth = append(c.auth, mech)
+				}
+			}
+			params = strings.Join(c.auth, " ")
+		}
+		c.ext[keyword] = params
+	}
+
+	return nil
+}
+
+// Extension reports whether the server supports the given extension, and
+// returns its parameters.
+func (c *smtpConn) Extension(ext string) (bool, string) {
+	if err := c.ensureHello(); err != nil {
+		return false, ""
+	}
+	params, ok := c.ext[strings.ToUpper(ext)]
+	return ok, params
+}
+
+// StartTLS upgrades the connection to TLS with the STARTTLS command (RFC 3207)
+// and sends EHLO again, as the server forgets what was negotiated before.
+func (c *smtpConn) StartTLS(config *tls.Config) error {
+	if err := c.ensureHello(); err != nil {
+		return err
+	}
+	if _, err := c.cmd(220, "STARTTLS"); err != nil {
+		return err
+	}
+
+	c.conn = tls.Client(c.conn, config)
+	c.text = textproto.NewConn(c.conn)
+	c.tls = true
+	return c.sendHello()
+}
+
+// Auth authenticates with the given SASL mechanism (RFC 4954).
+func (c *smtpConn) Auth(a smtp.Auth) error {
+	if err := c.ensureHello(); err != nil {
+		return err
+	}
+
+	encoding := base64.StdEncoding
+	mech, resp, err := a.Start(&smtp.ServerInfo{Name: c.serverName, TLS: c.tls, Auth: c.auth})
+	if err != nil {
+		return err
+	}
+
+	command := "AUTH " + mech
+	switch {
+	case resp == nil:
+	case len(resp) == 0:
+		// An empty initial response is sent as "=".
+		command += " ="
+	default:
+		command += " " + encoding.EncodeToString(resp)
+	}
+
+	code, msg, err := c.cmdReply(command)
+	for err == nil {
+		var challenge []byte
+		switch code {
+		case 334:
+			challenge, err = encoding.DecodeString(msg)
+		case 235:
+			_, err = a.Next([]byte(msg), false)
+			return err
+		default:
+			return c.replyError(code, msg)
+		}
+		if err == nil {
+			resp, err = a.Next(challenge, true)
+		}
+		if err != nil {
+			// Cancel the exchange.
+			_, _, _ = c.cmdReply("*")
+			return err
+		}
+		code, msg, err = c.cmdReply(encoding.EncodeToString(resp))
+	}
+
+	return err
+}
+
+// Mail starts a mail transaction with the MAIL command. The parameters, such
+// as "SIZE=1000", are added to the command. SMTPUTF8 is added when the server
+// supports it and it is not given, as net/smtp does.
+func (c *smtpConn) Mail(from string, params ...string) error {
+	if err := c.ensureHello(); err != nil {
+		return err
+	}
+	command, err := c.mailCommand(from, params)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.cmd(250, "%s", command)
+	return err
+}
+
+func (c *smtpConn) mailCommand(from string, params []string) (string, error) {
+	if err := validateLine(from); err != nil {
+		return "", err
+	}
+
+	command := "MAIL FROM:<" + from + ">"
+	if _, ok := c.ext["SMTPUTF8"]; ok && !hasParam(params, "SMTPUTF8") {
+		command += " SMTPUTF8"
+	}
+	return appendParams(command, params)
+}
+
+// Rcpt adds a recipient to the mail transaction with the RCPT command, with
+// the given parameters.
+func (c *smtpConn) Rcpt(to string, params ...string) error {
+	command, err := rcptCommand(to, params)
+	if err != nil {
+		return err
+	}
+
+	// 251 means that the server forwards the message.
+	_, err = c.cmd(25, "%s", command)
+	return err
+}
+
+func rcptCommand(to string, params []string) (string, error) {
+	if err := validateLine(to); err != nil {
+		return "", err
+	}
+	return appendParams("RCPT TO:<"+to+">", params)
+}
+
+func appendParams(command string, params []string) (string, error) {
+	for _, p := range params {
+		if err := validateLine(p); err != nil {
+			return "", err
+		}
+		command += " " + p
+	}
+	return command, nil
+}
+
+// pipelineReplies holds the replies to the commands of a pipelined mail
+// transaction.
+type pipelineReplies struct {
+	// mail is the error of the MAIL command.
+	mail error
+	// rcpt holds the errors of the RCPT commands, in the order of the
+	// recipients.
+	rcpt []error
+	// data is the error of the DATA command.
+	data error
+	// w is the writer of the message, when DATA is accepted.
+	w io.WriteCloser
+}
+
+// pipeline sends the MAIL command, the RCPT commands of the recipients and the
+// DATA command of t in a single group, as allowed by the PIPELINING extension
+// (RFC 2920), then reads their replies in order. DATA is left out when the
+// content is sent with BDAT.
+//
+// The negative replies are returned in the pipelineReplies; an error is only
+// returned when the commands cannot be sent or the replies cannot be read. The
+// replies are nil if the reply to MAIL could not be read.
+func (c *smtpConn) pipeline(t *transaction) (*pipelineReplies, error) {
+	if err := c.ensureHello(); err != nil {
+		return nil, err
+	}
+
+	mail, err := c.mailCommand(t.from, t.mailParams)
+	if err != nil {
+		return nil, err
+	}
+	commands := []string{mail}
+	for _, addr := range t.to {
+		rcpt, err := rcptCommand(addr, t.rcptParams(addr))
+		if err != nil {
+			return nil, err
+		}
+		commands = append(commands, rcpt)
+	}
+	data := !t.chunking
+	if data {
+		commands = append(commands, "DATA")
+	}
+
+	for _, command := range commands {
+		if _, err := c.text.W.WriteString(command + "\r\n"); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.text.W.Flush(); err != nil {
+		return nil, err
+	}
+
+	r := &pipelineReplies{rcpt: make([]error, len(t.to))}
+	if r.mail, err = c.readNegativeReply(250); err != nil {
+		return nil, err
+	}
+	for i := range t.to {
+		if r.rcpt[i], err = c.readNegativeReply(25); err != nil {
+			return r, err
+		}
+	}
+	if !data {
+		return r, nil
+	}
+	if r.data, err = c.readNegativeReply(354); err != nil {
+		return r, err
+	}
+	if r.data == nil {
+		r.w = &smtpDataWriter{c: c, WriteCloser: c.text.DotWriter()}
+	}
+
+	return r, nil
+}
+
+// readNegativeReply reads a reply as readReply does, but returns the
+// SMTPError of a negative reply as its first result rather than as an error.
+func (c *smtpConn) readNegativeReply(expectCode int) (replyErr, err error) {
+	_, err = c.readReply(expectCode)
+	if _, ok := err.(*SMTPError); ok {
+		return err, nil
+	}
+	return nil, err
+}
+
+// Data sends the DATA command and returns a writer to write the message to.
+// The message is sent when the writer is closed, which returns the reply of
+// the server.
+func (c *smtpConn) Data() (io.WriteCloser, error) {
+	if _, err := c.cmd(354, "DATA"); err != nil {
+		return nil, err
+	}
+	return &smtpDataWriter{c: c, WriteCloser: c.text.DotWriter()}, nil
+}
+
+type smtpDataWriter struct {
+	io.WriteCloser
+	c *smtpConn
+}
+
+func (w *smtpDataWriter) Close() error {
+	if err := w.WriteCloser.Close(); err != nil {
+		return err
+	}
+	_, err := w.c.readReply(250)
+	return err
+}
+
+// bdatChunkSize is the size of the chunks of the messages sent with BDAT.
+const bdatChunkSize = 1 << 20
+
+// bdat returns a writer sending the message with BDAT commands, as allowed by
+// the CHUNKING extension (RFC 3030), instead of DATA. The message is sent in
+// chunks of bdatChunkSize bytes as it is written, without dot-stuffing, and
+// the last chunk is sent when the writer is closed. As with DATA, bare line
+// feeds are converted to CRLF and a line break is added at the end of the
+// message if it is missing. Binary messages, sent with BODY=BINARYMIME, are
+// sent as they are.
+func (c *smtpConn) bdat(binary bool) (io.WriteCloser, error) {
+	if err := c.ensureHello(); err != nil {
+		return nil, err
+	}
+	return &bdatWriter{c: c, binary: binary}, nil
+}
+
+type bdatWriter struct {
+	c      *smtpConn
+	binary bool
+	buf    []byte
+	err    error
+	// inLine reports that the last line written has no line break yet, and
+	// cr that its last byte is a carriage return.
+	inLine bool
+	cr     bool
+	closed bool
+}
+
+func (w *bdatWriter) Write(p []byte) (int, error) {
+	if w.binary {
+		w.write(p)
+		if w.err != nil {
+			return 0, w.err
+		}
+		return len(p), nil
+	}
+
+	n := 0
+	for len(p) > 0 && w.err == nil {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			w.write(p)
+			n += len(p)
+			break
+		}
+		w.write(p[:i])
+		if !w.cr {
+			w.write(crlf[:1])
+		}
+		w.write(p[i : i+1])
+		n += i + 1
+		p = p[i+1:]
+	}
+	return n, w.err
+}
+
+var crlf = []byte("\r\n")
+
+// write buffers p, sending a chunk each time the buffer is full.
+func (w *bdatWriter) write(p []byte) {
+	if w.buf == nil {
+		w.buf = make([]byte, 0, bdatChunkSize)
+	}
+
+	for len(p) > 0 && w.err == nil {
+		if len(w.buf) == cap(w.buf) {
+			w.err = w.sendChunk(false)
+			continue
+		}
+		k := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+k]
+		w.inLine = p[k-1] != '\n'
+		w.cr = p[k-1] == '\r'
+		p = p[k:]
+	}
+}
+
+func (w *bdatWriter) Close() error {
+	if !w.closed {
+		w.closed = true
+		switch {
+		case w.cr && !w.binary:
+			w.buf = append(w.buf, '\n')
+		case w.inLine:
+			w.buf = append(w.buf, crlf...)
+		}
+		if w.err == nil {
+			w.err = w.sendChunk(true)
+		}
+	}
+	return w.err
+}
+
+// sendChunk sends the buffered content in a BDAT command.
+func (w *bdatWriter) sendChunk(last bool) error {
+	command := fmt.Sprintf("BDAT %d", len(w.buf))
+	if last {
+		command += " LAST"
+	}
+	bw := w.c.text.W
+	if _, err := bw.WriteString(command + "\r\n"); err != nil {
+		return err
+	}
+	if _, err := bw.Write(w.buf); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	w.buf = w.buf[:0]
+
+	_, err := w.c.readReply(250)
+	return err
+}
+
+// Reset aborts the current mail transaction with the RSET command.
+func (c *smtpConn) Reset() error {
+	if err := c.ensureHello(); err != nil {
+		return err
+	}
+	_, err := c.cmd(250, "RSET")
+	return err
+}
+
+// Noop sends the NOOP command, to check that the connection is alive.
+func (c *smtpConn) Noop() error {
+	if err := c.ensureHello(); err != nil {
+		return err
+	}
+	_, err := c.cmd(250, "NOOP")
+	return err
+}
+
+// Quit sends the QUIT command and closes the connection.
+func (c *smtpConn) Quit() error {
+	if err := c.ensureHello(); err != nil {
+		_ = c.text.Close()
+		return err
+	}
+	if _, err := c.cmd(221, "QUIT"); err != nil {
+		_ = c.text.Close()
+		return err
+	}
+	return c.text.Close()
+}
+
+// Close closes the connection without sending QUIT.
+func (c *smtpConn) Close() error {
+	return c.text.Close()
+}
+
+// cmd sends a command and reads its reply, whose code must start with
+// expectCode. It returns the text of the reply.
+func (c *smtpConn) cmd(expectCode int, format string, args ...interface{}) (string, error) {
+	if err := c.text.PrintfLine(format, args...); err != nil {
+		return "", err
+	}
+	return c.readReply(expectCode)
+}
+
+// cmdReply sends a command and returns the code and the text of its reply,
+// whatever the code.
+func (c *smtpConn) cmdReply(line string) (int, string, error) {
+	if err := c.text.PrintfLine("%s", line); err != nil {
+		return 0, "", err
+	}
+	return c.text.ReadResponse(0)
+}
+
+// readReply reads a reply whose code must start with expectCode, as in
+// textproto.Reader.ReadResponse. An SMTPError is returned otherwise.
+func (c *smtpConn) readReply(expectCode int) (string, error) {
+	code, msg, err := c.text.ReadResponse(0)
+	if err != nil {
+		return "", err
+	}
+	if !codeMatches(code, expectCode) {
+		return "", c.replyError(code, msg)
+	}
+	return msg, nil
+}
+
+func codeMatches(code, expectCode int) bool {
+	switch {
+	case expectCode < 10:
+		return code/100 == expectCode
+	case expectCode < 100:
+		return code/10 == expectCode
+	default:
+		return code == expectCode
+	}
+}
+
+// replyError returns the SMTPError of a reply, extracting the enhanced status
+// codes from its lines.
+func (c *smtpConn) replyError(code int, msg string) error {
+	e := &SMTPError{Code: code}
+	lines := strings.Split(msg, "\n")
+	for i, line := range lines {
+		if enhanced, text, ok := cutEnhancedCode(line, code); ok {
+			e.EnhancedCode = enhanced
+			lines[i] = text
+		}
+	}
+	e.Message = strings.Join(lines, "\n")
+	return e
+}
+
+// cutEnhancedCode splits the enhanced status code at the start of line, whose
+// class must match the one of code.
+func cutEnhancedCode(line string, code int) (string, string, bool) {
+	enhanced, text, _ := strings.Cut(line, " ")
+	parts := strings.Split(enhanced, ".")
+	if len(parts) != 3 || parts[0] != fmt.Sprint(code/100) {
+		return "", "", false
+	}
+	for _, p := range parts[1:] {
+		if p == "" || len(p) > 3 || strings.Trim(p, "0123456789") != "" {
+			return "", "", false
+		}
+	}
+	return enhanced, text, true
+}
+
+func hasParam(params []string, keyword string) bool {
+	for _, p := range params {
+		name, _, _ := strings.Cut(p, "=")
+		if strings.EqualFold(name, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateLine checks that a command argument does not contain line breaks,
+// which would inject other commands.
+func validateLine(line string) error {
+	if strings.ContainsAny(line, "\r\n") {
+		return ErrInvalidSMTPLine
+	}
+	return nil
+}
@@ -0,0 +1,1097 @@
+package gomail
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSMTPConn is a connection reading the replies of a server from a string
+// and recording the commands of the client.
+type fakeSMTPConn struct {
+	net.Conn
+	r   io.Reader
+	buf bytes.Buffer
+}
+
+func newFakeSMTPConn(server string) *fakeSMTPConn {
+	return &fakeSMTPConn{r: strings.NewReader(strings.ReplaceAll(server, "\n", "\r\n"))}
+}
+
+func (c *fakeSMTPConn) Read(p []byte) (int, error)  { return c.r.Read(p) }
+func (c *fakeSMTPConn) Write(p []byte) (int, error) { return c.buf.Write(p) }
+func (c *fakeSMTPConn) Close() error                { return nil }
+
+func (c *fakeSMTPConn) SetDeadline(time.Time) error { return nil }
+
+func (c *fakeSMTPConn) commands() string {
+	return strings.ReplaceAll(c.buf.String(), "\r\n", "\n")
+}
+
+func TestSMTPConn(t *testing.T) {
+	conn := newFakeSMTPConn(`220 mx.example.com ESMTP ready
+250-mx.example.com greets localhost
+250-PIPELINING
+250-SIZE 35882577
+250-8BITMIME
+250-AUTH=LOGIN
+250-AUTH PLAIN CRAM-MD5
+250 ENHANCEDSTATUSCODES
+235 2.7.0 Authentication successful
+250 2.1.0 Sender OK
+250 2.1.5 Recipient OK
+251 2.1.5 User not local; will forward
+354 Start mail input
+250 2.0.0 Queued as 1234
+221 2.0.0 Bye
+`)
+
+	c, err := newSMTPConn(conn, "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, params := c.Extension("size"); !ok || params != "35882577" {
+		t.Errorf("invalid SIZE extension, got %v %q", ok, params)
+	}
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		t.Error("STARTTLS should not be supported")
+	}
+	if want := []string{"LOGIN", "PLAIN", "CRAM-MD5"}; strings.Join(c.auth, " ") != strings.Join(want, " ") {
+		t.Errorf("invalid AUTH mechanisms, got %q, want %q", c.auth, want)
+	}
+
+	if err := c.Auth(smtp.PlainAuth("", "user", "pwd", "localhost")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Mail("from@example.com", "BODY=8BITMIME", "SIZE=42"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Rcpt("to1@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Rcpt("to2@example.com", "NOTIFY=NEVER"); err != nil {
+		t.Fatal(err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "Subject: Test\r\n\r\n.Hello\r\n")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Quit(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `EHLO localhost
+AUTH PLAIN AHVzZXIAcHdk
+MAIL FROM:<from@example.com> BODY=8BITMIME SIZE=42
+RCPT TO:<to1@example.com>
+RCPT TO:<to2@example.com> NOTIFY=NEVER
+DATA
+Subject: Test
+
+..Hello
+.
+QUIT
+`
+	if got := conn.commands(); got != want {
+		t.Errorf("invalid commands, got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestSMTPConnHelo(t *testing.T) {
+	conn := newFakeSMTPConn(`220 mx.example.com
+502 Command not implemented
+250 mx.example.com
+250 OK
+`)
+
+	c, err := newSMTPConn(conn, "mx.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Hello("client.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := c.Extension("8BITMIME"); ok {
+		t.Error("no extension should be supported")
+	}
+	if err := c.Mail("from@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Hello("other"); !errors.Is(err, ErrSMTPHelloSent) {
+		t.Errorf("got error %v, want %v", err, ErrSMTPHelloSent)
+	}
+
+	want := "EHLO client.example.com\nHELO client.example.com\nMAIL FROM:<from@example.com>\n"
+	if got := conn.commands(); got != want {
+		t.Errorf("invalid commands, got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestSMTPConnErrors(t *testing.T) {
+	conn := newFakeSMTPConn(`220 mx.example.com
+250 mx.example.com
+250 OK
+550-5.1.1 The email account that you tried to reach does not exist.
+550 5.1.1 Please check the address.
+452 4.5.3 Too many recipients
+`)
+
+	c, err := newSMTPConn(conn, "mx.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Mail("from@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Rcpt("unknown@example.com")
+	var smtpErr *SMTPError
+	if !errors.As(err, &smtpErr) {
+		t.Fatalf("got error %v, want an SMTPError", err)
+	}
+	want := SMTPError{
+		Code:         550,
+		EnhancedCode: "5.1.1",
+		Message:      "The email account that you tried to reach does not exist.\nPlease check the address.",
+	}
+	if *smtpErr != want {
+		t.Errorf("invalid error, got %#v, want %#v", *smtpErr, want)
+	}
+	if smtpErr.Temporary() {
+		t.Error("a 550 reply is not temporary")
+	}
+
+	err = c.Rcpt("other@example.com")
+	if !errors.As(err, &smtpErr) || !smtpErr.Temporary() || smtpErr.EnhancedCode != "4.5.3" {
+		t.Errorf("got error %v, want a temporary SMTPError", err)
+	}
+	if got, want := err.Error(), "gomail: SMTP error 452 4.5.3: Too many recipients"; got != want {
+		t.Errorf("invalid message, got %q, want %q", got, want)
+	}
+
+	if err := c.Rcpt("to@example.com>\r\nRSET"); !errors.Is(err, ErrInvalidSMTPLine) {
+		t.Errorf("got error %v, want %v", err, ErrInvalidSMTPLine)
+	}
+}
+
+func TestSMTPConnGreetingRejected(t *testing.T) {
+	conn := newFakeSMTPConn("554 5.7.1 No SMTP service here\n")
+	if _, err := newSMTPConn(conn, "mx.example.com"); !errors.Is(err, &SMTPError{}) {
+		t.Errorf("got error %v, want an SMTPError", err)
+	}
+}
+
+func TestSMTPConnLoginAuth(t *testing.T) {
+	conn := newFakeSMTPConn(`220 mx.example.com
+250-mx.example.com
+250 AUTH LOGIN
+334 VXNlcm5hbWU6
+334 UGFzc3dvcmQ6
+235 2.7.0 Accepted
+`)
+
+	c, err := newSMTPConn(conn, "mx.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Auth(&loginAuth{username: "user", password: "pwd", host: "mx.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "EHLO localhost\nAUTH LOGIN\ndXNlcg==\ncHdk\n"
+	if got := conn.commands(); got != want {
+		t.Errorf("invalid commands, got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestSMTPConnAuthFailure(t *testing.T) {
+	conn := newFakeSMTPConn(`220 mx.example.com
+250-mx.example.com
+250 AUTH LOGIN
+334 VXNlcm5hbWU6
+334 T3RoZXI6
+501 5.7.0 Cancelled
+`)
+
+	c, err := newSMTPConn(conn, "mx.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Auth(&loginAuth{username: "user", password: "pwd", host: "mx.example.com"})
+	if !errors.Is(err, &UnexpectedServerChallengeError{}) {
+		t.Fatalf("got error %v, want an UnexpectedServerChallengeError", err)
+	}
+
+	want := "EHLO localhost\nAUTH LOGIN\ndXNlcg==\n*\n"
+	if got := conn.commands(); got != want {
+		t.Errorf("invalid commands, got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestSMTPConnStartTLS(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := testCertificate(t, key, "mx.example.com")
+
+	client, server := net.Pipe()
+	defer client.Close()
+	done := make(chan error, 1)
+	go func() {
+		done <- func() error {
+			r := bufio.NewReader(server)
+			var conn net.Conn = server
+			reply := func(want, lines string) error {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return err
+				}
+				if line != want+"\r\n" {
+					return errors.New("unexpected command " + line)
+				}
+				_, err = io.WriteString(conn, strings.ReplaceAll(lines, "\n", "\r\n"))
+				return err
+			}
+
+			io.WriteString(conn, "220 mx.example.com\r\n")
+			if err := reply("EHLO localhost", "250-mx.example.com\n250 STARTTLS\n"); err != nil {
+				return err
+			}
+			if err := reply("STARTTLS", "220 Go ahead\n"); err != nil {
+				return err
+			}
+			conn = tls.Server(server, &tls.Config{Certificates: []tls.Certificate{{
+				Certificate: [][]byte{cert.Raw},
+				PrivateKey:  key,
+			}}})
+			r = bufio.NewReader(conn)
+			if err := reply("EHLO localhost", "250-mx.example.com\n250 AUTH PLAIN\n"); err != nil {
+				return err
+			}
+			if err := reply("QUIT", "221 Bye\n"); err != nil {
+				return err
+			}
+			_, err := io.Copy(io.Discard, conn)
+			return err
+		}()
+	}()
+
+	c, err := newSMTPConn(client, "mx.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.StartTLS(&tls.Config{InsecureSkipVerify: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !c.tls {
+		t.Error("the connection should be encrypted")
+	}
+	if _, ok := c.TLSConnectionState(); !ok {
+		t.Error("TLSConnectionState should report the TLS session")
+	}
+	if ok, params := c.Extension("AUTH"); !ok || params != "PLAIN" {
+		t.Errorf("the extensions should be read again, got %v %q", ok, params)
+	}
+	if err := c.Quit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDialerSMTPConn(t *testing.T) {
+	conn := newFakeSMTPConn(`220 mx.example.com
+250-mx.example.com
+250 AUTH PLAIN
+235 2.7.0 Accepted
+250 2.1.0 OK
+250 2.1.5 OK
+250 2.1.5 OK
+354 Go ahead
+250 2.0.0 Queued
+221 2.0.0 Bye
+`)
+
+	origDial, origClient := dialContext, smtpNewClient
+	defer func() { dialContext, smtpNewClient = origDial, origClient }()
+	dialContext = func(ctx context.Context, d *Dialer) (net.Conn, error) {
+		return conn, nil
+	}
+	smtpNewClient = func(conn net.Conn, host string) (smtpClient, error) {
+		return newSMTPConn(conn, host)
+	}
+
+	d := NewDialer("localhost", testPort, "user", "pwd")
+	d.StartTLSPolicy = NoStartTLS
+	if err := d.DialAndSend(context.Background(), getTestMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	got := conn.commands()
+	for _, want := range []string{
+		"EHLO localhost\nAUTH PLAIN AHVzZXIAcHdk\n",
+		"MAIL FROM:<" + testFrom + ">\nRCPT TO:<" + testTo1 + ">\nRCPT TO:<" + testTo2 + ">\nDATA\n",
+		"\n.\nQUIT\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("commands do not contain %q:\n%s", want, got)
+		}
+	}
+}
+
+// lineSMTPConn is a fakeSMTPConn returning the replies of the server one read
+// at a time, and recording the commands received before each read.
+type lineSMTPConn struct {
+	*fakeSMTPConn
+	replies []string
+	reads   []string
+	closed  bool
+}
+
+func newLineSMTPConn(replies ...string) *lineSMTPConn {
+	return &lineSMTPConn{fakeSMTPConn: newFakeSMTPConn(""), replies: replies}
+}
+
+func (c *lineSMTPConn) Read(p []byte) (int, error) {
+	if len(c.replies) == 0 {
+		return 0, io.EOF
+	}
+	c.reads = append(c.reads, c.commands())
+	reply := strings.ReplaceAll(c.replies[0], "\n", "\r\n")
+	c.replies = c.replies[1:]
+	return copy(p, reply), nil
+}
+
+func (c *lineSMTPConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+// timeoutSMTPConn is a lineSMTPConn whose reads time out once all the replies
+// are read.
+type timeoutSMTPConn struct {
+	*lineSMTPConn
+}
+
+func (c timeoutSMTPConn) Read(p []byte) (int, error) {
+	if len(c.replies) == 0 {
+		return 0, os.ErrDeadlineExceeded
+	}
+	return c.lineSMTPConn.Read(p)
+}
+
+func TestSMTPConnPipeline(t *testing.T) {
+	conn := newLineSMTPConn(
+		"220 mx.example.com\n",
+		"250-mx.example.com\n250 PIPELINING\n",
+		"250 2.1.0 OK\n",
+		"250 2.1.5 OK\n",
+		"550 5.1.1 No such user\n",
+		"250 2.1.5 OK\n",
+		"354 Go ahead\n",
+		"250 2.0.0 Queued\n",
+	)
+
+	c, err := newSMTPConn(conn, "mx.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := c.pipeline(&transaction{from: "from@example.com", to: []string{"a@example.com", "b@example.com", "c@example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commands := "EHLO localhost\n" +
+		"MAIL FROM:<from@example.com>\n" +
+		"RCPT TO:<a@example.com>\n" +
+		"RCPT TO:<b@example.com>\n" +
+		"RCPT TO:<c@example.com>\n" +
+		"DATA\n"
+	// All the commands are sent before the reply to MAIL is read.
+	if got := conn.reads[2]; got != commands {
+		t.Errorf("invalid commands before the reply to MAIL, got:\n%s\nwant:\n%s", got, commands)
+	}
+
+	if r.mail != nil || r.data != nil {
+		t.Errorf("got MAIL error %v and DATA error %v, want none", r.mail, r.data)
+	}
+	if len(r.rcpt) != 3 || r.rcpt[0] != nil || r.rcpt[2] != nil {
+		t.Fatalf("invalid recipient errors %v", r.rcpt)
+	}
+	var smtpErr *SMTPError
+	if !errors.As(r.rcpt[1], &smtpErr) || smtpErr.Code != 550 {
+		t.Errorf("got error %v for the second recipient, want a 550 SMTPError", r.rcpt[1])
+	}
+
+	if _, err := io.WriteString(r.w, "Subject: test\r\n\r\nHello\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSMTPConnPipelineMailRejected(t *testing.T) {
+	conn := newLineSMTPConn(
+		"220 mx.example.com\n",
+		"250-mx.example.com\n250 PIPELINING\n",
+		"553 5.1.8 Sender rejected\n",
+		"503 5.5.1 No sender\n",
+		"554 5.5.1 No valid recipients\n",
+	)
+
+	c, err := newSMTPConn(conn, "mx.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := c.pipeline(&transaction{from: "from@example.com", to: []string{"to@example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(r.mail, &SMTPError{}) || !errors.Is(r.rcpt[0], &SMTPError{}) || !errors.Is(r.data, &SMTPError{}) {
+		t.Errorf("got errors %v, %v and %v, want SMTPErrors", r.mail, r.rcpt, r.data)
+	}
+	if r.w != nil {
+		t.Error("got a writer, want none as DATA is rejected")
+	}
+}
+
+func TestDialerPipelining(t *testing.T) {
+	tests := []struct {
+		name     string
+		replies  []string
+		commands string
+		wantErr  int
+		aborted  bool
+	}{
+		{
+			name: "Accepted",
+			replies: []string{
+				"250 2.1.0 OK\n",
+				"250 2.1.5 OK\n",
+				"250 2.1.5 OK\n",
+				"354 Go ahead\n",
+				"250 2.0.0 Queued\n",
+				"221 2.0.0 Bye\n",
+			},
+			commands: "\n.\nQUIT\n",
+		},
+		{
+			name: "RecipientRejected",
+			replies: []string{
+				"250 2.1.0 OK\n",
+				"250 2.1.5 OK\n",
+				"550 5.1.1 No such user\n",
+				"354 Go ahead\n",
+			},
+			commands: "RCPT TO:<" + testTo2 + ">\nDATA\n",
+			wantErr:  550,
+			aborted:  true,
+		},
+		{
+			name: "NoRecipient",
+			replies: []string{
+				"250 2.1.0 OK\n",
+				"550 5.1.1 No such user\n",
+				"550 5.1.1 No such user\n",
+				"554 5.5.1 No valid recipients\n",
+				"250 2.0.0 Reset\n",
+				"221 2.0.0 Bye\n",
+			},
+			commands: "DATA\nRSET\nQUIT\n",
+			wantErr:  550,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := newLineSMTPConn(append([]string{
+				"220 mx.example.com\n",
+				"250-mx.example.com\n250-PIPELINING\n250 8BITMIME\n",
+			}, tt.replies...)...)
+
+			origDial, origClient := dialContext, smtpNewClient
+			defer func() { dialContext, smtpNewClient = origDial, origClient }()
+			dialContext = func(ctx context.Context, d *Dialer) (net.Conn, error) {
+				return conn, nil
+			}
+			smtpNewClient = func(conn net.Conn, host string) (smtpClient, error) {
+				return newSMTPConn(conn, host)
+			}
+
+			d := NewDialer("localhost", testPort, "", "")
+			d.StartTLSPolicy = NoStartTLS
+			err := d.DialAndSend(context.Background(), getTestMessage())
+
+			var smtpErr *SMTPError
+			switch {
+			case tt.wantErr == 0 && err != nil:
+				t.Fatal(err)
+			case tt.wantErr != 0 && (!errors.As(err, &smtpErr) || smtpErr.Code != tt.wantErr):
+				t.Fatalf("got error %v, want a %d SMTPError", err, tt.wantErr)
+			}
+
+			got := conn.commands()
+			want := "EHLO localhost\n" +
+				"MAIL FROM:<" + testFrom + ">\n" +
+				"RCPT TO:<" + testTo1 + ">\n" +
+				"RCPT TO:<" + testTo2 + ">\n" +
+				"DATA\n"
+			if !strings.HasPrefix(got, want) {
+				t.Errorf("invalid commands, got:\n%s\nwant prefix:\n%s", got, want)
+			}
+			if got := conn.reads[2]; got != want {
+				t.Errorf("commands are not pipelined, got:\n%s\nwant:\n%s", got, want)
+			}
+			if !strings.HasSuffix(got, tt.commands) {
+				t.Errorf("invalid commands, got:\n%s\nwant suffix:\n%s", got, tt.commands)
+			}
+			if !conn.closed {
+				t.Error("the connection is not closed")
+			}
+			if tt.aborted && strings.Contains(got, "Subject:") {
+				t.Error("the message is sent despite the rejected recipient")
+			}
+		})
+	}
+}
+
+func TestSendAfterTimeout(t *testing.T) {
+	conns := []net.Conn{
+		timeoutSMTPConn{newLineSMTPConn(
+			"220 mx.example.com\n",
+			"250 mx.example.com\n",
+			"250 2.1.0 OK\n",
+			"250 2.1.5 OK\n",
+			"250 2.1.5 OK\n",
+			"354 Go ahead\n",
+		)},
+		newLineSMTPConn(
+			"220 mx.example.com\n",
+			"250 mx.example.com\n",
+			"250 2.1.0 OK\n",
+			"250 2.1.5 OK\n",
+			"250 2.1.5 OK\n",
+			"354 Go ahead\n",
+			"250 2.0.0 Queued\n",
+			"221 2.0.0 Bye\n",
+		),
+	}
+
+	origDial, origClient := dialContext, smtpNewClient
+	defer func() { dialContext, smtpNewClient = origDial, origClient }()
+	dials := 0
+	dialContext = func(ctx context.Context, d *Dialer) (net.Conn, error) {
+		conn := conns[dials]
+		dials++
+		return conn, nil
+	}
+	smtpNewClient = func(conn net.Conn, host string) (smtpClient, error) {
+		return newSMTPConn(conn, host)
+	}
+
+	d := NewDialer("localhost", testPort, "", "")
+	d.StartTLSPolicy = NoStartTLS
+	s, err := d.Dial(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Send(context.Background(), s, getTestMessage())
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("got error %v, want a timeout", err)
+	}
+	if !conns[0].(timeoutSMTPConn).closed {
+		t.Error("the connection of the interrupted transaction is not closed")
+	}
+
+	if err := Send(context.Background(), s, getTestMessage()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if dials != 2 {
+		t.Errorf("got %d dials, want 2", dials)
+	}
+}
+
+func TestRetryMiddlewares(t *testing.T) {
+	conns := []*lineSMTPConn{
+		newLineSMTPConn(
+			"220 mx.example.com\n",
+			"250 mx.example.com\n",
+		),
+		newLineSMTPConn(
+			"220 mx.example.com\n",
+			"250 mx.example.com\n",
+			"250 2.1.0 OK\n",
+			"250 2.1.5 OK\n",
+			"250 2.1.5 OK\n",
+			"354 Go ahead\n",
+			"250 2.0.0 Queued\n",
+			"221 2.0.0 Bye\n",
+		),
+	}
+
+	origDial, origClient := dialContext, smtpNewClient
+	defer func() { dialContext, smtpNewClient = origDial, origClient }()
+	dials := 0
+	dialContext = func(ctx context.Context, d *Dialer) (net.Conn, error) {
+		conn := conns[dials]
+		dials++
+		return conn, nil
+	}
+	smtpNewClient = func(conn net.Conn, host string) (smtpClient, error) {
+		return newSMTPConn(conn, host)
+	}
+
+	signer, err := NewDKIMSigner(DKIMOptions{Domain: "example.com", Selector: "s", Signer: testEd25519Key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewDialer("localhost", testPort, "", "")
+	d.StartTLSPolicy = NoStartTLS
+	d.RetryFailure = true
+	d.SendMiddlewares = SendMiddlewares{WithDKIM(signer)}
+	if err := d.DialAndSend(context.Background(), getTestMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	if dials != 2 {
+		t.Fatalf("got %d dials, want 2", dials)
+	}
+	if n := strings.Count(conns[1].commands(), "DKIM-Signature:"); n != 1 {
+		t.Errorf("got %d DKIM signatures, want 1:\n%s", n, conns[1].commands())
+	}
+}
+
+func TestRetryWrappedError(t *testing.T) {
+	c := &smtpSender{d: &Dialer{RetryFailure: true}}
+	for _, test := range []struct {
+		err  error
+		want bool
+	}{
+		{fmt.Errorf("read: %w", io.EOF), true},
+		{fmt.Errorf("read: %w", os.ErrDeadlineExceeded), true},
+		{&SMTPError{Code: 421}, false},
+		{fmt.Errorf("send: %w", context.DeadlineExceeded), false},
+	} {
+		if got := c.retryError(test.err); got != test.want {
+			t.Errorf("retryError(%v), got %v, want %v", test.err, got, test.want)
+		}
+	}
+}
+
+func TestSendAfterAbortedTransaction(t *testing.T) {
+	conns := []*lineSMTPConn{
+		newLineSMTPConn(
+			"220 mx.example.com\n",
+			"250-mx.example.com\n250 PIPELINING\n",
+			"250 2.1.0 OK\n",
+			"550 5.1.1 No such user\n",
+			"250 2.1.5 OK\n",
+			"354 Go ahead\n",
+		),
+		newLineSMTPConn(
+			"220 mx.example.com\n",
+			"250-mx.example.com\n250 PIPELINING\n",
+			"250 2.1.0 OK\n",
+			"250 2.1.5 OK\n",
+			"250 2.1.5 OK\n",
+			"354 Go ahead\n",
+			"250 2.0.0 Queued\n",
+			"221 2.0.0 Bye\n",
+		),
+	}
+
+	origDial, origClient := dialContext, smtpNewClient
+	defer func() { dialContext, smtpNewClient = origDial, origClient }()
+	dials := 0
+	dialContext = func(ctx context.Context, d *Dialer) (net.Conn, error) {
+		conn := conns[dials]
+		dials++
+		return conn, nil
+	}
+	smtpNewClient = func(conn net.Conn, host string) (smtpClient, error) {
+		return newSMTPConn(conn, host)
+	}
+
+	d := NewDialer("localhost", testPort, "", "")
+	d.StartTLSPolicy = NoStartTLS
+	s, err := d.Dial(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Send(context.Background(), s, getTestMessage()); !errors.Is(err, &SMTPError{}) {
+		t.Fatalf("got error %v, want an SMTPError", err)
+	}
+	if !conns[0].closed {
+		t.Error("the connection of the aborted transaction is not closed")
+	}
+
+	if err := Send(context.Background(), s, getTestMessage()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if dials != 2 {
+		t.Errorf("got %d dials, want 2", dials)
+	}
+	if got := conns[1].commands(); !strings.HasSuffix(got, "\n.\nQUIT\n") {
+		t.Errorf("the message is not sent on the new connection:\n%s", got)
+	}
+}
+
+func TestSMTPConnBDAT(t *testing.T) {
+	conn := newFakeSMTPConn(`220 mx.example.com
+250-mx.example.com
+250 CHUNKING
+250 2.0.0 Chunk received
+250 2.0.0 Queued
+`)
+
+	c, err := newSMTPConn(conn, "mx.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := c.bdat(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := strings.Repeat("a", bdatChunkSize) + "\r\n.\r\n"
+	if _, err := io.WriteString(w, content); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "EHLO localhost\r\n" +
+		fmt.Sprintf("BDAT %d\r\n", bdatChunkSize) + strings.Repeat("a", bdatChunkSize) +
+		"BDAT 5 LAST\r\n\r\n.\r\n"
+	if got := conn.buf.String(); got != want {
+		t.Errorf("invalid commands, got %q, want %q", got[len(got)-30:], want[len(want)-30:])
+	}
+}
+
+func TestSMTPConnBDATLineEndings(t *testing.T) {
+	tests := []struct {
+		binary bool
+		writes []string
+		want   string
+	}{
+		{writes: []string{"a\nb\r", "\nc\n\n"}, want: "a\r\nb\r\nc\r\n\r\n"},
+		{writes: []string{"a\r", "b\n", "c"}, want: "a\rb\r\nc\r\n"},
+		{writes: []string{"a\r"}, want: "a\r\n"},
+		{binary: true, writes: []string{"a\nb\r", "\n"}, want: "a\nb\r\n"},
+	}
+
+	for _, test := range tests {
+		conn := newFakeSMTPConn(`220 mx.example.com
+250-mx.example.com
+250 CHUNKING
+250 2.0.0 Queued
+`)
+		c, err := newSMTPConn(conn, "mx.example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, err := c.bdat(test.binary)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range test.writes {
+			if _, err := io.WriteString(w, s); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		want := "EHLO localhost\r\n" + fmt.Sprintf("BDAT %d LAST\r\n", len(test.want)) + test.want
+		if got := conn.buf.String(); got != want {
+			t.Errorf("%q: got %q, want %q", test.writes, got, want)
+		}
+	}
+}
+
+func TestDialerChunkingLineEndings(t *testing.T) {
+	conn := newLineSMTPConn(
+		"220 mx.example.com\n",
+		"250-mx.example.com\n250-8BITMIME\n250 CHUNKING\n",
+		"250 2.1.0 OK\n", "250 2.1.5 OK\n", "250 2.0.0 Queued\n", "221 2.0.0 Bye\n",
+	)
+	useSMTPConn(t, conn)
+
+	m := NewMessage()
+	m.SetHeader("From", testFrom)
+	m.SetHeader("To", testTo1)
+	m.SetBody("text/plain", "line1\nline2\n", SetPartEncoding(Unencoded))
+
+	d := NewDialer("localhost", testPort, "", "")
+	d.StartTLSPolicy = NoStartTLS
+	if err := d.DialAndSend(context.Background(), m); err != nil {
+		t.Fatal(err)
+	}
+	got := conn.buf.String()
+	if !strings.Contains(got, "\r\n\r\nline1\r\nline2\r\n") {
+		t.Errorf("the body is not sent with CRLF line endings: %q", got)
+	}
+}
+
+func TestSMTPConnBDATRejected(t *testing.T) {
+	conn := newFakeSMTPConn(`220 mx.example.com
+250-mx.example.com
+250 CHUNKING
+552 5.3.4 Message too big
+`)
+
+	c, err := newSMTPConn(conn, "mx.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := c.bdat(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := io.WriteString(w, strings.Repeat("a", bdatChunkSize+1)); !errors.Is(err, &SMTPError{}) {
+		t.Fatalf("got error %v, want an SMTPError", err)
+	}
+	if _, err := io.WriteString(w, "a"); !errors.Is(err, &SMTPError{}) {
+		t.Errorf("got error %v after a rejected chunk, want an SMTPError", err)
+	}
+	if err := w.Close(); !errors.Is(err, &SMTPError{}) {
+		t.Errorf("got error %v on close, want an SMTPError", err)
+	}
+}
+
+func TestDialerChunking(t *testing.T) {
+	tests := []struct {
+		name       string
+		extensions string
+		replies    []string
+		binary     bool
+		dkim       bool
+		commands   []string
+		wantErr    error
+	}{
+		{
+			name:       "Chunking",
+			extensions: "250-PIPELINING\n250-8BITMIME\n250 CHUNKING\n",
+			replies:    []string{"250 2.1.0 OK\n", "250 2.1.5 OK\n", "250 2.1.5 OK\n", "250 2.0.0 Queued\n", "221 2.0.0 Bye\n"},
+			commands: []string{
+				"MAIL FROM:<" + testFrom + ">\nRCPT TO:<" + testTo1 + ">\nRCPT TO:<" + testTo2 + ">\nBDAT ",
+				" LAST\nMIME-Version: 1.0\n",
+				"\nQUIT\n",
+			},
+		},
+		{
+			name:       "BinaryMIME",
+			extensions: "250-CHUNKING\n250 BINARYMIME\n",
+			replies:    []string{"250 2.1.0 OK\n", "250 2.1.5 OK\n", "250 2.1.5 OK\n", "250 2.0.0 Queued\n", "221 2.0.0 Bye\n"},
+			binary:     true,
+			commands: []string{
+				"MAIL FROM:<" + testFrom + "> BODY=BINARYMIME\n",
+				"Content-Transfer-Encoding: binary\nContent-Type: application/octet-stream; name=\"data.bin\"\n\n\x00\xff\nline\n--",
+				"\nQUIT\n",
+			},
+		},
+		{
+			name:       "BinaryMIMEMiddleware",
+			extensions: "250-CHUNKING\n250 BINARYMIME\n",
+			replies:    []string{"250 2.1.0 OK\n", "250 2.1.5 OK\n", "250 2.1.5 OK\n", "250 2.0.0 Queued\n", "221 2.0.0 Bye\n"},
+			binary:     true,
+			dkim:       true,
+			commands: []string{
+				"MAIL FROM:<" + testFrom + "> BODY=BINARYMIME\n",
+				"DKIM-Signature: ",
+				"\nQUIT\n",
+			},
+		},
+		{
+			name:       "BinaryMIMEMiddlewareUnsupported",
+			extensions: "250-8BITMIME\n250 CHUNKING\n",
+			replies:    []string{"221 2.0.0 Bye\n"},
+			binary:     true,
+			dkim:       true,
+			commands:   []string{"EHLO localhost\nQUIT\n"},
+			wantErr:    ErrBinaryMIMEUnsupported,
+		},
+		{
+			name:       "BinaryMIMEUnsupported",
+			extensions: "250-PIPELINING\n250 CHUNKING\n",
+			replies:    []string{"221 2.0.0 Bye\n"},
+			binary:     true,
+			commands:   []string{"EHLO localhost\nQUIT\n"},
+			wantErr:    ErrBinaryMIMEUnsupported,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := newLineSMTPConn(append([]string{
+				"220 mx.example.com\n",
+				"250-mx.example.com\n" + tt.extensions,
+			}, tt.replies...)...)
+
+			origDial, origClient := dialContext, smtpNewClient
+			defer func() { dialContext, smtpNewClient = origDial, origClient }()
+			dialContext = func(ctx context.Context, d *Dialer) (net.Conn, error) {
+				return conn, nil
+			}
+			smtpNewClient = func(conn net.Conn, host string) (smtpClient, error) {
+				return newSMTPConn(conn, host)
+			}
+
+			m := getTestMessage()
+			if tt.binary {
+				m.AttachReader("data.bin", strings.NewReader("\x00\xff\r\nline"), SetFileEncoding(Binary))
+			}
+
+			d := NewDialer("localhost", testPort, "", "")
+			d.StartTLSPolicy = NoStartTLS
+			if tt.dkim {
+				signer, err := NewDKIMSigner(DKIMOptions{Domain: "example.com", Selector: "s", Signer: testEd25519Key})
+				if err != nil {
+					t.Fatal(err)
+				}
+				d.SendMiddlewares = SendMiddlewares{WithDKIM(signer)}
+			}
+			if err := d.DialAndSend(context.Background(), m); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			got := conn.commands()
+			if strings.Contains(got, "DATA") {
+				t.Errorf("DATA sent despite CHUNKING:\n%s", got)
+			}
+			for _, want := range tt.commands {
+				if !strings.Contains(got, want) {
+					t.Errorf("commands do not contain %q:\n%s", want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestDialerSizeExtension(t *testing.T) {
+	size, err := getTestMessage().Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		size    string
+		mail    string
+		maxSize int64
+	}{
+		{
+			name: "Declared",
+			size: "250 SIZE 35882577\n",
+			mail: fmt.Sprintf("MAIL FROM:<%s> SIZE=%d\n", testFrom, size),
+		},
+		{
+			name: "NoLimit",
+			size: "250 SIZE\n",
+			mail: fmt.Sprintf("MAIL FROM:<%s> SIZE=%d\n", testFrom, size),
+		},
+		{
+			name:    "TooLarge",
+			size:    "250 SIZE 100\n",
+			maxSize: 100,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := newLineSMTPConn(
+				"220 mx.example.com\n",
+				"250-mx.example.com\n"+tt.size,
+				"250 2.1.0 OK\n",
+				"250 2.1.5 OK\n",
+				"250 2.1.5 OK\n",
+				"354 Go ahead\n",
+				"250 2.0.0 Queued\n",
+				"221 2.0.0 Bye\n",
+			)
+
+			origDial, origClient := dialContext, smtpNewClient
+			defer func() { dialContext, smtpNewClient = origDial, origClient }()
+			dialContext = func(ctx context.Context, d *Dialer) (net.Conn, error) {
+				return conn, nil
+			}
+			smtpNewClient = func(conn net.Conn, host string) (smtpClient, error) {
+				return newSMTPConn(conn, host)
+			}
+
+			d := NewDialer("localhost", testPort, "", "")
+			d.StartTLSPolicy = NoStartTLS
+			err := d.DialAndSend(context.Background(), getTestMessage())
+
+			got := conn.commands()
+			if tt.maxSize == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !strings.Contains(got, tt.mail) {
+					t.Errorf("commands do not contain %q:\n%s", tt.mail, got)
+				}
+				return
+			}
+
+			var tooLarge *MessageTooLargeError
+			if !errors.As(err, &tooLarge) {
+				t.Fatalf("got error %v, want a MessageTooLargeError", err)
+			}
+			want := MessageTooLargeError{Size: size, MaxSize: tt.maxSize, Server: true}
+			if *tooLarge != want {
+				t.Errorf("invalid error, got %+v, want %+v", *tooLarge, want)
+			}
+			if tooLarge.Temporary() {
+				t.Error("a MessageTooLargeError is not temporary")
+			}
+			if strings.Contains(got, "MAIL") {
+				t.Errorf("the transaction is started despite the size limit:\n%s", got)
+			}
+		})
+	}
+}
+
+// useSMTPConn makes Dialer use the in-package SMTP client on conn for the
+// duration of the test.
+func useSMTPConn(t *testing.T, conn net.Conn) {
+	origDial, origClient := dialContext, smtpNewClient
+	t.Cleanup(func() { dialContext, smtpNewClient = origDial, origClient })
+	dialContext = func(ctx context.Context, d *Dialer) (net.Conn, error) {
+		return conn, nil
+	}
+	smtpNewClient = func(conn net.Conn, host string) (smtpClient, error) {
+		return newSMTPConn(conn, host)
+	}
+}
@@ -0,0 +1,317 @@
+package gomail
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// sniffLen is the number of bytes read to detect the media type of a file. It
+// is larger than the 512 bytes used by http.DetectContentType so that the
+// entries of Office Open XML archives can be found.
+const sniffLen = 8192
+
+// SetContentTypeDetection is a message setting to detect the media type of
+// the attachments and embedded files from their content rather than from the
+// extension of their name. See DetectContentType.
+func SetContentTypeDetection(enabled bool) MessageSetting {
+	return func(m *Message) {
+		m.detectContentType = enabled
+	}
+}
+
+// DetectContentType is a file setting to detect the media type of the file
+// from its first bytes rather than from the extension of its name, as
+// http.DetectContentType does, with the additional signatures of Office
+// documents, OpenDocument and EPUB files, archives and images. Text files get
+// a charset parameter when their encoding is recognized.
+//
+// The extension is still used when the content is not recognized, or when it
+// refines the detected type, for example text/csv for text content. It has
+// no effect if the Content-Type header of the file is set.
+func DetectContentType() FileSetting {
+	return func(f *file) {
+		f.detectType = true
+	}
+}
+
+// writeDetectedFile writes f, whose Content-Type header is determined from
+// the first bytes of its content. The content is read only once: its first
+// bytes are kept until the header is written.
+func (w *messageWriter) writeDetectedFile(f *file, enc Encoding) {
+	if w.sizeOnly && f.size != nil && sizedByLength(enc) {
+		w.writeDetectedFileSize(f, enc)
+		return
+	}
+	if w.sizeOnly && f.buffer != nil {
+		// The content of a reader is kept to be sent afterwards.
+		if err := f.buffer(); err != nil {
+			w.err = err
+			return
+		}
+	}
+
+	sw := &sniffWriter{start: func(head []byte) (io.WriteCloser, error) {
+		f.setHeader("Content-Type", detectMediaType(head, f.Name)+`; name="`+f.Name+`"`)
+		w.writeHeaders(fileHeader(f, enc))
+		if w.err != nil {
+			return nil, w.err
+		}
+		return w.bodyWriter(enc), nil
+	}}
+	err := f.CopyFunc(sw)
+	if closeErr := sw.Close(); err == nil {
+		err = closeErr
+	}
+	if w.err == nil && err != nil {
+		w.err = err
+	}
+}
+
+// writeDetectedFileSize writes f when only the size of the message is
+// computed. Only the first bytes of the content are read to detect its media
+// type, as the size of the encoded content only depends on its length.
+func (w *messageWriter) writeDetectedFileSize(f *file, enc Encoding) {
+	if f.buffer != nil {
+		// The first bytes of a reader cannot be read twice.
+		if err := f.buffer(); err != nil {
+			w.err = err
+			return
+		}
+	}
+
+	head := &headWriter{}
+	if err := f.CopyFunc(head); err != nil && err != errSniffed {
+		w.err = err
+		return
+	}
+	f.setHeader("Content-Type", detectMediaType(head.buf.Bytes(), f.Name)+`; name="`+f.Name+`"`)
+
+	copier, err := w.fileCopier(f, enc)
+	if err != nil {
+		w.err = err
+		return
+	}
+	w.writeHeaders(fileHeader(f, enc))
+	w.writeBody(copier, enc)
+}
+
+// errSniffed stops the copy of a file once its first bytes are read.
+var errSniffed = errors.New("gomail: first bytes read")
+
+// A headWriter keeps the first sniffLen bytes written, then fails with
+// errSniffed.
+type headWriter struct {
+	buf bytes.Buffer
+}
+
+func (h *headWriter) Write(p []byte) (int, error) {
+	n := sniffLen - h.buf.Len()
+	if len(p) < n {
+		return h.buf.Write(p)
+	}
+	h.buf.Write(p[:n])
+	return n, errSniffed
+}
+
+// A sniffWriter keeps the first sniffLen bytes written, then calls start with
+// them and writes everything to the writer returned by start.
+type sniffWriter struct {
+	start func(head []byte) (io.WriteCloser, error)
+	head  bytes.Buffer
+	w     io.WriteCloser
+	err   error
+}
+
+func (s *sniffWriter) Write(p []byte) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	if s.w != nil {
+		return s.w.Write(p)
+	}
+
+	n := sniffLen - s.head.Len()
+	if len(p) < n {
+		return s.head.Write(p)
+	}
+	s.head.Write(p[:n])
+	if err := s.flush(); err != nil {
+		return 0, err
+	}
+	m, err := s.w.Write(p[n:])
+	return n + m, err
+}
+
+func (s *sniffWriter) flush() error {
+	if s.w, s.err = s.start(s.head.Bytes()); s.err != nil {
+		return s.err
+	}
+	_, err := s.w.Write(s.head.Bytes())
+	return err
+}
+
+func (s *sniffWriter) Close() error {
+	if s.err != nil {
+		return s.err
+	}
+	if s.w == nil {
+		if err := s.flush(); err != nil {
+			return err
+		}
+	}
+	return s.w.Close()
+}
+
+// detectMediaType returns the media type of a file named name starting with
+// head.
+func detectMediaType(head []byte, name string) string {
+	detected := sniffSignature(head)
+	if detected == "" {
+		detected = http.DetectContentType(head)
+	}
+	byExt := mime.TypeByExtension(filepath.Ext(name))
+	mediaType, params, _ := mime.ParseMediaType(detected)
+
+	switch {
+	case mediaType == "application/octet-stream":
+		if byExt != "" {
+			return byExt
+		}
+	case mediaType == "text/plain":
+		charset := params["charset"]
+		if charset == "utf-8" && !validUTF8Prefix(head) {
+			charset = ""
+		}
+		if extType, _, err := mime.ParseMediaType(byExt); err == nil && strings.HasPrefix(extType, "text/") {
+			mediaType = extType
+		}
+		if charset != "" {
+			return mediaType + "; charset=" + charset
+		}
+		return mediaType
+	case mediaType == "application/zip":
+		// Many formats are ZIP archives: the extension is more specific.
+		if strings.HasPrefix(byExt, "application/") && byExt != "application/x-zip-compressed" {
+			return byExt
+		}
+	}
+
+	return detected
+}
+
+// validUTF8Prefix reports whether head is valid UTF-8, ignoring a rune cut at
+// the end.
+func validUTF8Prefix(head []byte) bool {
+	if len(head) == sniffLen {
+		for i := 0; i < utf8.UTFMax-1; i++ {
+			if r, size := utf8.DecodeLastRune(head); r != utf8.RuneError || size != 1 {
+				break
+			}
+			head = head[:len(head)-1]
+		}
+	}
+	return utf8.Valid(head)
+}
+
+var magicSignatures = []struct {
+	offset    int
+	signature string
+	mediaType string
+}{
+	{0, "\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1", "application/x-ole-storage"},
+	{0, "7z\xbc\xaf\x27\x1c", "application/x-7z-compressed"},
+	{0, "\xfd7zXZ\x00", "application/x-xz"},
+	{0, "BZh", "application/x-bzip2"},
+	{0, "\x28\xb5\x2f\xfd", "application/zstd"},
+	{257, "ustar", "application/x-tar"},
+	{0, "II*\x00", "image/tiff"},
+	{0, "MM\x00*", "image/tiff"},
+	{4, "ftypheic", "image/heic"},
+	{4, "ftypavif", "image/avif"},
+	{0, "{\\rtf", "application/rtf"},
+	{0, "BEGIN:VCALENDAR", "text/calendar"},
+	{0, "BEGIN:VCARD", "text/vcard"},
+}
+
+// sniffSignature returns the media type of the formats not recognized by
+// http.DetectContentType, or an empty string.
+func sniffSignature(head []byte) string {
+	for _, s := range magicSignatures {
+		if len(head) >= s.offset+len(s.signature) &&
+			string(head[s.offset:s.offset+len(s.signature)]) == s.signature {
+			if strings.HasPrefix(s.mediaType, "text/") {
+				return s.mediaType + "; charset=utf-8"
+			}
+			return s.mediaType
+		}
+	}
+
+	if bytes.HasPrefix(head, []byte("PK\x03\x04")) {
+		return sniffZip(head)
+	}
+
+	trimmed := bytes.TrimLeft(head, " \t\r\n\ufeff")
+	if bytes.HasPrefix(trimmed, []byte("<svg")) ||
+		(bytes.HasPrefix(trimmed, []byte("<?xml")) && bytes.Contains(head, []byte("<svg"))) {
+		return "image/svg+xml"
+	}
+
+	return ""
+}
+
+var zipLocalHeader = []byte("PK\x03\x04")
+
+// sniffZip returns the media type of a ZIP archive from the names of the
+// entries whose local file headers are in head.
+func sniffZip(head []byte) string {
+	for first := true; len(head) >= 30 && bytes.HasPrefix(head, zipLocalHeader); first = false {
+		flags := binary.LittleEndian.Uint16(head[6:8])
+		size := int(binary.LittleEndian.Uint32(head[18:22]))
+		nameLen := int(binary.LittleEndian.Uint16(head[26:28]))
+		extraLen := int(binary.LittleEndian.Uint16(head[28:30]))
+		start := 30 + nameLen + extraLen
+		if start > len(head) {
+			break
+		}
+
+		name := string(head[30 : 30+nameLen])
+		switch {
+		case first && name == "mimetype" && start+size <= len(head):
+			// OpenDocument and EPUB files start with an uncompressed entry
+			// named mimetype holding their media type.
+			if mediaType := string(head[start : start+size]); strings.HasPrefix(mediaType, "application/") {
+				return mediaType
+			}
+		case strings.HasPrefix(name, "word/"):
+			return "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+		case strings.HasPrefix(name, "xl/"):
+			return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+		case strings.HasPrefix(name, "ppt/"):
+			return "application/vnd.openxmlformats-officedocument.presentationml.presentation"
+		}
+
+		if flags&0x08 != 0 {
+			// The size of the entry is only known after its data, so the
+			// next entry is searched for.
+			i := bytes.Index(head[start:], zipLocalHeader)
+			if i < 0 {
+				break
+			}
+			head = head[start+i:]
+		} else {
+			if start+size > len(head) {
+				break
+			}
+			head = head[start+size:]
+		}
+	}
+
+	return "application/zip"
+}
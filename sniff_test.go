@@ -0,0 +1,238 @@
+package gomail
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testZip(t *testing.T, entries ...string) []byte {
+	t.Helper()
+
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for i := 0; i < len(entries); i += 2 {
+		var w io.Writer
+		var err error
+		if entries[i] == "mimetype" {
+			// The mimetype entry of OpenDocument files is stored, with its
+			// size in the local file header.
+			w, err = zw.CreateRaw(&zip.FileHeader{
+				Name:               entries[i],
+				Method:             zip.Store,
+				CRC32:              crc32.ChecksumIEEE([]byte(entries[i+1])),
+				CompressedSize64:   uint64(len(entries[i+1])),
+				UncompressedSize64: uint64(len(entries[i+1])),
+			})
+		} else {
+			w, err = zw.Create(entries[i])
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(entries[i+1]))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestDetectMediaType(t *testing.T) {
+	tests := []struct {
+		name    string
+		content []byte
+		want    string
+	}{
+		{"report", []byte("%PDF-1.7\n..."), "application/pdf"},
+		{"image.txt", []byte("\x89PNG\r\n\x1a\n\x00\x00"), "image/png"},
+		{"photo", []byte("\x00\x00\x00\x1cftypheic\x00\x00"), "image/heic"},
+		{"notes", []byte("Hello, world! é"), "text/plain; charset=utf-8"},
+		{"data.csv", []byte("a,b\n1,2\n"), "text/csv; charset=utf-8"},
+		{"latin1", []byte("Caf\xe9 cr\xe8me"), "text/plain"},
+		{"invite", []byte("BEGIN:VCALENDAR\r\nVERSION:2.0\r\n"), "text/calendar; charset=utf-8"},
+		{"logo", []byte(`<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg"></svg>`), "image/svg+xml"},
+		{"archive", []byte("7z\xbc\xaf\x27\x1c\x00\x04"), "application/x-7z-compressed"},
+		{"legacy", []byte("\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1\x00"), "application/x-ole-storage"},
+		{"unknown.pdf", []byte("\x00\x01\x02\x03"), "application/pdf"},
+		{"unknown", []byte("\x00\x01\x02\x03"), "application/octet-stream"},
+		{
+			"letter",
+			testZip(t, "[Content_Types].xml", "<Types/>", "word/document.xml", "<document/>"),
+			"application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+		},
+		{
+			"sheet",
+			testZip(t, "[Content_Types].xml", "<Types/>", "xl/workbook.xml", "<workbook/>"),
+			"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+		},
+		{
+			"text",
+			testZip(t, "mimetype", "application/vnd.oasis.opendocument.text", "content.xml", "<content/>"),
+			"application/vnd.oasis.opendocument.text",
+		},
+		{"files", testZip(t, "a.txt", "a"), "application/zip"},
+		{"names", testZip(t, "keyword/a.txt", "a", "pixl/b.txt", "b"), "application/zip"},
+		{"content", testZip(t, "a.txt", "see word/document.xml"), "application/zip"},
+		{
+			"slides",
+			testZip(t, "[Content_Types].xml", "<Types/>", "_rels/.rels", "<Relationships/>", "ppt/presentation.xml", "<presentation/>"),
+			"application/vnd.openxmlformats-officedocument.presentationml.presentation",
+		},
+	}
+
+	for _, test := range tests {
+		if got := detectMediaType(test.content, test.name); got != test.want {
+			t.Errorf("detectMediaType(%q), got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestValidUTF8Prefix(t *testing.T) {
+	head := []byte(strings.Repeat("a", sniffLen-1) + "é")[:sniffLen]
+	if !validUTF8Prefix(head) {
+		t.Error("a rune cut at the end should be ignored")
+	}
+	if validUTF8Prefix([]byte("a\xe9b")) {
+		t.Error("invalid UTF-8 should be detected")
+	}
+}
+
+func TestAttachmentDetectContentType(t *testing.T) {
+	pdf := "%PDF-1.7\n" + strings.Repeat("x", 2*sniffLen)
+
+	m := NewMessage(SetContentTypeDetection(true))
+	m.SetHeader("From", "from@example.com")
+	m.SetHeader("To", "to@example.com")
+	m.SetBody("text/plain", "Test")
+	m.AttachReader("report", strings.NewReader(pdf))
+	m.Attach("notes", SetCopyFunc(func(w io.Writer) error {
+		_, err := io.WriteString(w, "Hello")
+		return err
+	}))
+	m.Attach("data.bin", SetCopyFunc(func(w io.Writer) error {
+		_, err := io.WriteString(w, "%PDF-1.7")
+		return err
+	}), SetHeader(map[string][]string{"Content-Type": {"application/octet-stream"}}))
+
+	size, err := m.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if _, err := m.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(buf.Len()) {
+		t.Errorf("invalid size, got %d, want %d", size, buf.Len())
+	}
+
+	msg := buf.String()
+	for _, want := range []string{
+		"Content-Type: application/pdf; name=\"report\"\r\n",
+		"Content-Type: text/plain; charset=utf-8; name=\"notes\"\r\n",
+		"Content-Type: application/octet-stream\r\n",
+		base64.StdEncoding.EncodeToString([]byte(pdf))[:76] + "\r\n",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("message does not contain %q:\n%s", want, msg[:1024])
+		}
+	}
+}
+
+func TestFileDetectContentType(t *testing.T) {
+	m := NewMessage()
+	m.SetHeader("From", "from@example.com")
+	m.SetHeader("To", "to@example.com")
+	m.AttachReader("image.txt", strings.NewReader("\x89PNG\r\n\x1a\n"), DetectContentType())
+	m.AttachReader("other.txt", strings.NewReader("\x89PNG\r\n\x1a\n"))
+
+	buf := new(bytes.Buffer)
+	if _, err := m.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	msg := buf.String()
+	for _, want := range []string{
+		"Content-Type: image/png; name=\"image.txt\"\r\n",
+		"Content-Type: text/plain; charset=utf-8; name=\"other.txt\"\r\n",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("message does not contain %q:\n%s", want, msg)
+		}
+	}
+}
+
+func TestDetectContentTypeSizeReadsHead(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "report.bin")
+	content := append([]byte("%PDF-1.7\n"), bytes.Repeat([]byte("x"), 1<<20)...)
+	if err := os.WriteFile(name, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewMessage(SetContentTypeDetection(true))
+	m.SetHeader("From", "from@example.com")
+	m.SetHeader("To", "to@example.com")
+	m.SetBody("text/plain", "Test")
+	m.Attach(name)
+	f := m.attachments[0]
+	read := 0
+	copyFunc := f.CopyFunc
+	f.CopyFunc = func(w io.Writer) error {
+		return copyFunc(writerFunc(func(p []byte) (int, error) {
+			read += len(p)
+			return w.Write(p)
+		}))
+	}
+
+	size, err := m.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read >= len(content) {
+		t.Errorf("the whole file was read to compute the size: %d bytes", read)
+	}
+
+	buf := new(bytes.Buffer)
+	if _, err := m.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(buf.Len()) {
+		t.Errorf("invalid size, got %d, want %d", size, buf.Len())
+	}
+	if want := "Content-Type: application/pdf; name=\"report.bin\"\r\n"; !strings.Contains(buf.String(), want) {
+		t.Errorf("message does not contain %q", want)
+	}
+}
+
+func TestDetectContentTypeHeaderError(t *testing.T) {
+	m := NewMessage(SetContentTypeDetection(true))
+	m.SetHeader("From", "from@example.com")
+	m.SetHeader("To", "to@example.com")
+	m.SetBody("text/plain", "Test")
+	m.AttachReader("report", strings.NewReader("%PDF-1.7"))
+
+	errWrite := errors.New("write error")
+	w := writerFunc(func(p []byte) (int, error) {
+		if bytes.Contains(p, []byte(`name="report"`)) {
+			return 0, errWrite
+		}
+		return len(p), nil
+	})
+	if _, err := m.WriteTo(w); !errors.Is(err, errWrite) {
+		t.Errorf("got error %v, want %v", err, errWrite)
+	}
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
@@ -0,0 +1,69 @@
+package gomail
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+)
+
+// A TokenKey is a secret used to sign the unsubscribe and tracking tokens.
+type TokenKey struct {
+	// ID identifies the key inside tokens.
+	ID string
+	// Secret is the HMAC-SHA256 secret.
+	Secret []byte
+}
+
+// signToken returns a token holding the ID of k and fields, signed with k.
+func signToken(k TokenKey, fields ...string) string {
+	parts := make([]string, 0, len(fields)+1)
+	parts = append(parts, encodeTokenPart(k.ID))
+	for _, f := range fields {
+		parts = append(parts, encodeTokenPart(f))
+	}
+
+	payload := strings.Join(parts, ".")
+	return payload + "." + encodeTokenPart(string(tokenMAC(k.Secret, payload)))
+}
+
+// verifyToken checks the signature of a token holding n fields with the key
+// of keys it names, and returns its fields and the ID of the key.
+func verifyToken(keys []TokenKey, token string, n int) ([]string, string, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != n+2 {
+		return nil, "", false
+	}
+
+	fields := make([]string, len(parts))
+	for i, p := range parts {
+		b, err := base64.RawURLEncoding.DecodeString(p)
+		if err != nil {
+			return nil, "", false
+		}
+		fields[i] = string(b)
+	}
+
+	payload := strings.Join(parts[:n+1], ".")
+	for _, k := range keys {
+		if k.ID != fields[0] {
+			continue
+		}
+		if !hmac.Equal(tokenMAC(k.Secret, payload), []byte(fields[n+1])) {
+			break
+		}
+		return fields[1 : n+1], k.ID, true
+	}
+
+	return nil, "", false
+}
+
+func encodeTokenPart(s string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+func tokenMAC(secret []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
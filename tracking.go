@@ -0,0 +1,457 @@
+package gomail
+
+import (
+	"bytes"
+	"context"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Tracking describes how the opens and clicks of the recipients are tracked.
+// It is used with SetTracking to rewrite the HTML parts of messages, and with
+// Handler to serve the tracking requests.
+type Tracking struct {
+	// URL is the endpoint serving Handler. The signed token is added to it as
+	// the "t" query parameter.
+	URL string
+	// Campaign identifies the mailing. It is part of the signed token and is
+	// reported with the events.
+	Campaign string
+	// Opens injects a transparent pixel loaded from URL at the end of the
+	// HTML parts.
+	Opens bool
+	// Clicks rewrites the HTTP and HTTPS links of the HTML parts to redirect
+	// through URL.
+	Clicks bool
+	// UTM holds the Urchin Tracking Module parameters added to the links
+	// which do not already have them. It is optional.
+	UTM *UTM
+	// Keys are used to sign and verify tokens. The first key signs new
+	// tokens while all of them are accepted when verifying, so keys can be
+	// rotated by prepending a new one.
+	Keys []TokenKey
+}
+
+// UTM holds the Urchin Tracking Module parameters added to links. Empty
+// parameters are not added.
+type UTM struct {
+	Source   string
+	Medium   string
+	Campaign string
+	Term     string
+	Content  string
+}
+
+// A TrackingEventType is the type of a TrackingEvent.
+type TrackingEventType string
+
+const (
+	// TrackingOpen is the event of a recipient opening a message.
+	TrackingOpen TrackingEventType = "open"
+	// TrackingClick is the event of a recipient clicking a link.
+	TrackingClick TrackingEventType = "click"
+)
+
+// A TrackingEvent is the content of a verified tracking token.
+type TrackingEvent struct {
+	Type      TrackingEventType
+	Campaign  string
+	Recipient string
+	// URL is the destination of the link clicked. It is empty for opens.
+	URL string
+	// KeyID is the ID of the key that signed the token.
+	KeyID string
+}
+
+// SetTracking is a message setting to track the opens and clicks of the
+// text/html parts of the message. The tokens are signed for the recipient of
+// the message when it is written, so the setting survives Message.Reset and
+// can be used for bulk sending.
+//
+// As the tokens identify their recipient, each recipient needs their own
+// message: writing the message fails with ErrSeveralRecipients if it has
+// several recipients, and with ErrBccRecipient if its recipient is only in
+// the Bcc field.
+//
+// mailto: links, links to the List-Unsubscribe URL of the message and links
+// containing "unsubscribe" are left untouched.
+func SetTracking(t *Tracking) MessageSetting {
+	return func(m *Message) {
+		m.tracking = t
+	}
+}
+
+// Token returns a signed token for the given event. link is the destination
+// of clicks and is empty for opens.
+func (t *Tracking) Token(typ TrackingEventType, recipient, link string) (string, error) {
+	if len(t.Keys) == 0 {
+		return "", ErrTrackingKeyAbsent
+	}
+
+	return signToken(t.Keys[0], t.Campaign, recipient, string(typ), link), nil
+}
+
+// Verify checks the signature of a token and returns its content.
+func (t *Tracking) Verify(token string) (*TrackingEvent, error) {
+	fields, keyID, ok := verifyToken(t.Keys, token, 4)
+	if !ok || fields[0] != t.Campaign {
+		return nil, ErrInvalidTrackingToken
+	}
+
+	e := &TrackingEvent{
+		Type:      TrackingEventType(fields[2]),
+		Campaign:  fields[0],
+		Recipient: fields[1],
+		URL:       fields[3],
+		KeyID:     keyID,
+	}
+	if (e.Type != TrackingOpen || e.URL != "") && (e.Type != TrackingClick || e.URL == "") {
+		return nil, ErrInvalidTrackingToken
+	}
+	return e, nil
+}
+
+// trackingURL returns URL with the given token.
+func (t *Tracking) trackingURL(token string) (string, error) {
+	if t.URL == "" {
+		return "", ErrTrackingURLAbsent
+	}
+
+	link, err := url.Parse(t.URL)
+	if err != nil {
+		return "", err
+	}
+	q := link.Query()
+	q.Set("t", token)
+	link.RawQuery = q.Encode()
+
+	return link.String(), nil
+}
+
+// trackingPixel is a transparent 1x1 GIF image.
+var trackingPixel = []byte("GIF89a\x01\x00\x01\x00\x80\x00\x00\x00\x00\x00\x00\x00\x00" +
+	"!\xf9\x04\x01\x00\x00\x00\x00,\x00\x00\x00\x00\x01\x00\x01\x00\x00\x02\x02D\x01\x00;")
+
+// Handler returns an http.Handler serving the tracking requests sent to URL.
+// It verifies the token and calls fn, then redirects clicks to their
+// destination and responds to opens with a transparent pixel. As the
+// recipients must not be affected by the failure to record an event, fn
+// returns no error and has to handle its failures itself. HEAD requests, sent
+// by link scanners and prefetchers rather than by recipients, are answered
+// without calling fn.
+func (t *Tracking) Handler(fn func(ctx context.Context, e *TrackingEvent)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", http.MethodGet+", "+http.MethodHead)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		e, err := t.Verify(r.URL.Query().Get("t"))
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		if r.Method != http.MethodHead {
+			fn(r.Context(), e)
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		if e.Type == TrackingClick {
+			http.Redirect(w, r, e.URL, http.StatusFound)
+			return
+		}
+		w.Header().Set("Content-Type", "image/gif")
+		_, _ = w.Write(trackingPixel)
+	})
+}
+
+// tracker rewrites the HTML parts of a message for a recipient.
+type tracker struct {
+	t           *Tracking
+	recipient   string
+	unsubscribe string
+}
+
+func (m *Message) newTracker() (*tracker, error) {
+	to, err := m.getTokenRecipient()
+	if err != nil {
+		return nil, err
+	}
+
+	tr := &tracker{t: m.tracking, recipient: to}
+	if m.unsubscribe != nil {
+		tr.unsubscribe = m.unsubscribe.URL
+	}
+	return tr, nil
+}
+
+// appliesTo reports whether a part of the given content type is tracked.
+func (tr *tracker) appliesTo(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "text/html"
+}
+
+// copier returns a copier writing the HTML written by copier with its links
+// rewritten and the tracking pixel added.
+func (tr *tracker) copier(copier func(io.Writer) error) func(io.Writer) error {
+	return func(w io.Writer) error {
+		var buf bytes.Buffer
+		if err := copier(&buf); err != nil {
+			return err
+		}
+
+		body, err := tr.rewrite(buf.String())
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, body)
+		return err
+	}
+}
+
+func (tr *tracker) rewrite(body string) (string, error) {
+	if tr.t.Clicks || tr.t.UTM != nil {
+		var err error
+		if body, err = rewriteLinks(body, tr.link); err != nil {
+			return "", err
+		}
+	}
+
+	if !tr.t.Opens {
+		return body, nil
+	}
+
+	token, err := tr.t.Token(TrackingOpen, tr.recipient, "")
+	if err != nil {
+		return "", err
+	}
+	src, err := tr.t.trackingURL(token)
+	if err != nil {
+		return "", err
+	}
+	pixel := `<img src="` + html.EscapeString(src) + `" width="1" height="1" alt="" ` +
+		`style="display:block;width:1px;height:1px;border:0">`
+
+	if i := strings.LastIndex(strings.ToLower(body), "</body"); i != -1 {
+		return body[:i] + pixel + body[i:], nil
+	}
+	return body + pixel, nil
+}
+
+// link returns the tracked version of link, or link itself when it is not
+// tracked.
+func (tr *tracker) link(link string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(link))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return link, nil
+	}
+	if tr.isExcluded(u.String()) {
+		return link, nil
+	}
+
+	dest := u.String()
+	if utm := tr.t.UTM; utm != nil {
+		dest = utm.add(u)
+	}
+	if !tr.t.Clicks {
+		return dest, nil
+	}
+
+	token, err := tr.t.Token(TrackingClick, tr.recipient, dest)
+	if err != nil {
+		return "", err
+	}
+	return tr.t.trackingURL(token)
+}
+
+func (tr *tracker) isExcluded(link string) bool {
+	if tr.unsubscribe != "" && strings.HasPrefix(link, tr.unsubscribe) {
+		return true
+	}
+	if tr.t.URL != "" && strings.HasPrefix(link, tr.t.URL) {
+		return true
+	}
+	return strings.Contains(strings.ToLower(link), "unsubscribe")
+}
+
+// add returns the link u with the UTM parameters it does not already have.
+// The existing query is kept as it is.
+func (utm *UTM) add(u *url.URL) string {
+	q := u.Query()
+	var extra []string
+	for _, p := range [][2]string{
+		{"utm_source", utm.Source},
+		{"utm_medium", utm.Medium},
+		{"utm_campaign", utm.Campaign},
+		{"utm_term", utm.Term},
+		{"utm_content", utm.Content},
+	} {
+		if p[1] != "" && !q.Has(p[0]) {
+			extra = append(extra, p[0]+"="+url.QueryEscape(p[1]))
+		}
+	}
+	if len(extra) == 0 {
+		return u.String()
+	}
+
+	tagged := *u
+	if tagged.RawQuery != "" {
+		tagged.RawQuery += "&"
+	}
+	tagged.RawQuery += strings.Join(extra, "&")
+	return tagged.String()
+}
+
+// rewriteLinks calls fn with the href attribute of the a and area elements of
+// body and replaces it with the value returned.
+func rewriteLinks(body string, fn func(link string) (string, error)) (string, error) {
+	var sb strings.Builder
+	last := 0
+	err := scanTags(body, func(name string, attrs map[string][2]int) error {
+		if name != "a" && name != "area" {
+			return nil
+		}
+		href, ok := attrs["href"]
+		if !ok || href[0] < 0 {
+			return nil
+		}
+
+		link, err := fn(html.UnescapeString(body[href[0]:href[1]]))
+		if err != nil {
+			return err
+		}
+		quoted := href[0] > 0 && (body[href[0]-1] == '"' || body[href[0]-1] == '\'')
+		sb.WriteString(body[last:href[0]])
+		if !quoted {
+			sb.WriteByte('"')
+		}
+		sb.WriteString(html.EscapeString(link))
+		if !quoted {
+			sb.WriteByte('"')
+		}
+		last = href[1]
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	sb.WriteString(body[last:])
+
+	return sb.String(), nil
+}
+
+// scanTags calls fn with the lower case name and the attributes of the start
+// tags of body, in order. See parseTag.
+func scanTags(body string, fn func(name string, attrs map[string][2]int) error) error {
+	for i := 0; i < len(body); i++ {
+		if body[i] != '<' {
+			continue
+		}
+		if strings.HasPrefix(body[i:], "<!--") {
+			// Comments are skipped, except for conditional comments whose
+			// content is parsed by some clients.
+			if end := strings.Index(body[i:], "-->"); end != -1 && !strings.HasPrefix(body[i:], "<!--[if") {
+				i += end + 2
+			}
+			continue
+		}
+
+		name, attrs, end := parseTag(body, i+1)
+		i = end - 1
+		if name == "" {
+			continue
+		}
+		if err := fn(name, attrs); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseTag parses the tag starting at body[start], just after "<". It returns
+// its lower case name, the offsets of the values of its attributes, and the
+// offset following the tag. The offsets of attributes without value are -1.
+func parseTag(body string, start int) (string, map[string][2]int, int) {
+	i := start
+	for i < len(body) && isTagNameChar(body[i]) {
+		i++
+	}
+	name := strings.ToLower(body[start:i])
+	if name == "" {
+		return "", nil, start
+	}
+
+	attrs := make(map[string][2]int)
+	for i < len(body) {
+		for i < len(body) && isHTMLSpace(body[i]) {
+			i++
+		}
+		if i >= len(body) || body[i] == '>' {
+			break
+		}
+		if body[i] == '/' {
+			i++
+			continue
+		}
+
+		nameStart := i
+		for i < len(body) && !isHTMLSpace(body[i]) && body[i] != '=' && body[i] != '>' && body[i] != '/' {
+			i++
+		}
+		attr := strings.ToLower(body[nameStart:i])
+		for i < len(body) && isHTMLSpace(body[i]) {
+			i++
+		}
+		if i >= len(body) || body[i] != '=' {
+			if _, ok := attrs[attr]; !ok && attr != "" {
+				attrs[attr] = [2]int{-1, -1}
+			}
+			continue
+		}
+		i++
+		for i < len(body) && isHTMLSpace(body[i]) {
+			i++
+		}
+
+		var value [2]int
+		if i < len(body) && (body[i] == '"' || body[i] == '\'') {
+			quote := body[i]
+			i++
+			value[0] = i
+			for i < len(body) && body[i] != quote {
+				i++
+			}
+			value[1] = i
+			i++
+		} else {
+			value[0] = i
+			for i < len(body) && !isHTMLSpace(body[i]) && body[i] != '>' {
+				i++
+			}
+			value[1] = i
+		}
+		if _, ok := attrs[attr]; !ok {
+			attrs[attr] = value
+		}
+	}
+	if i > len(body) {
+		i = len(body)
+	}
+
+	return name, attrs, i
+}
+
+func isTagNameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == ':'
+}
+
+func isHTMLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
@@ -0,0 +1,237 @@
+package gomail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func testTracking() *Tracking {
+	return &Tracking{
+		URL:      "https://t.example.com/t",
+		Campaign: "spring",
+		Opens:    true,
+		Clicks:   true,
+		Keys: []TokenKey{
+			{ID: "k1", Secret: []byte("secret")},
+		},
+	}
+}
+
+func TestTrackingToken(t *testing.T) {
+	tr := testTracking()
+	token, err := tr.Token(TrackingClick, "to@example.com", "https://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := tr.Verify(token)
+	if err != nil {
+		t.Fatalf("Verify(): %v", err)
+	}
+	want := TrackingEvent{
+		Type:      TrackingClick,
+		Campaign:  "spring",
+		Recipient: "to@example.com",
+		URL:       "https://example.com/",
+		KeyID:     "k1",
+	}
+	if *e != want {
+		t.Errorf("invalid event, got %+v, want %+v", e, want)
+	}
+
+	if _, err := tr.Verify(token[:len(token)-2]); !errors.Is(err, ErrInvalidTrackingToken) {
+		t.Errorf("expected ErrInvalidTrackingToken for a tampered token, got %v", err)
+	}
+
+	other := testTracking()
+	other.Campaign = "summer"
+	if _, err := other.Verify(token); !errors.Is(err, ErrInvalidTrackingToken) {
+		t.Errorf("expected ErrInvalidTrackingToken for another campaign, got %v", err)
+	}
+
+	token, err = tr.Token(TrackingOpen, "to@example.com", "https://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tr.Verify(token); !errors.Is(err, ErrInvalidTrackingToken) {
+		t.Errorf("expected ErrInvalidTrackingToken for an open with a URL, got %v", err)
+	}
+
+	tr.Keys = nil
+	if _, err := tr.Token(TrackingOpen, "to@example.com", ""); !errors.Is(err, ErrTrackingKeyAbsent) {
+		t.Errorf("expected ErrTrackingKeyAbsent, got %v", err)
+	}
+}
+
+func TestRewriteLinks(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{`<a href="https://a.example.com/">A</a>`, `<a href="[https://a.example.com/]">A</a>`},
+		{`<A class=x HREF='https://a.example.com/?a=1&amp;b=2'>`, `<A class=x HREF='[https://a.example.com/?a=1&amp;b=2]'>`},
+		{`<area shape=rect href=https://a.example.com/>`, `<area shape=rect href="[https://a.example.com/]">`},
+		{`<link href="style.css"><img src="https://a.example.com/">`, `<link href="style.css"><img src="https://a.example.com/">`},
+		{`<!-- <a href="x"> --><a title="<" href="y">`, `<!-- <a href="x"> --><a title="<" href="[y]">`},
+		{`<!--[if mso]><a href="x"><![endif]-->`, `<!--[if mso]><a href="[x]"><![endif]-->`},
+		{`<a name="top">`, `<a name="top">`},
+		{`<a href="x`, `<a href="[x]`},
+	}
+
+	for _, test := range tests {
+		got, err := rewriteLinks(test.in, func(link string) (string, error) {
+			return "[" + link + "]", nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("rewriteLinks(%q), got %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+var trackingTokenRegexp = regexp.MustCompile(`https://t\.example\.com/t\?t=([A-Za-z0-9_.-]+)`)
+
+func TestSetTracking(t *testing.T) {
+	tr := testTracking()
+	tr.UTM = &UTM{Source: "newsletter", Medium: "email", Campaign: "spring"}
+
+	m := NewMessage(SetTracking(tr), SetListUnsubscribe(testListUnsubscribe()))
+	m.SetHeader("From", "from@example.com")
+	m.SetHeader("To", "to@example.com")
+	m.SetBody("text/plain", "Visit https://example.com/")
+	m.AddAlternative("text/html", `<html><body>`+
+		`<a href="https://example.com/shop?id=1&amp;utm_source=web">Shop</a>`+
+		`<a href="mailto:help@example.com">Help</a>`+
+		`<a href="https://example.com/unsubscribe?list=news">Unsubscribe</a>`+
+		`<a href="https://example.com/account/unsubscribe">Leave</a>`+
+		`<a href="#top">Top</a>`+
+		`</BODY></html>`, SetPartEncoding(Unencoded))
+
+	buf := new(bytes.Buffer)
+	if _, err := m.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	msg := buf.String()
+
+	for _, want := range []string{
+		"Visit https://example.com/\r\n",
+		`<a href="mailto:help@example.com">Help</a>`,
+		`<a href="https://example.com/unsubscribe?list=news">Unsubscribe</a>`,
+		`<a href="https://example.com/account/unsubscribe">Leave</a>`,
+		`<a href="#top">Top</a>`,
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("message does not contain %q:\n%s", want, msg)
+		}
+	}
+	if !regexp.MustCompile(`<img src="https://t\.example\.com/t\?t=[^"]+" width="1" height="1" alt="" style="[^"]+"></BODY></html>`).MatchString(msg) {
+		t.Errorf("tracking pixel not found at the end of the body:\n%s", msg)
+	}
+
+	var events []TrackingEvent
+	for _, match := range trackingTokenRegexp.FindAllStringSubmatch(msg, -1) {
+		e, err := tr.Verify(match[1])
+		if err != nil {
+			t.Fatalf("Verify(%q): %v", match[1], err)
+		}
+		events = append(events, *e)
+	}
+	if len(events) != 2 {
+		t.Fatalf("invalid number of tracked URLs, got %d, want 2", len(events))
+	}
+	if want := "https://example.com/shop?id=1&utm_source=web&utm_medium=email&utm_campaign=spring"; events[0].Type != TrackingClick || events[0].URL != want {
+		t.Errorf("invalid click event, got %+v, want URL %q", events[0], want)
+	}
+	if events[1].Type != TrackingOpen || events[1].Recipient != "to@example.com" {
+		t.Errorf("invalid open event, got %+v", events[1])
+	}
+}
+
+func TestSetTrackingRecipients(t *testing.T) {
+	tests := []struct {
+		name   string
+		header map[string][]string
+		want   error
+	}{
+		{"Several", map[string][]string{"To": {"to@example.com"}, "Bcc": {"bcc@example.com"}}, ErrSeveralRecipients},
+		{"Bcc", map[string][]string{"Bcc": {"bcc@example.com"}}, ErrBccRecipient},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMessage(SetTracking(testTracking()))
+			m.SetHeader("From", "from@example.com")
+			m.SetHeaders(tt.header)
+			m.SetBody("text/html", `<a href="https://example.com/">Shop</a>`)
+
+			var buf bytes.Buffer
+			if _, err := m.WriteTo(&buf); !errors.Is(err, tt.want) {
+				t.Fatalf("got error %v, want %v", err, tt.want)
+			}
+			if strings.Contains(buf.String(), "bcc@example.com") {
+				t.Errorf("the Bcc recipient is written:\n%s", buf.String())
+			}
+		})
+	}
+}
+
+func TestTrackingHandler(t *testing.T) {
+	tr := testTracking()
+	click, err := tr.Token(TrackingClick, "to@example.com", "https://example.com/shop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	open, err := tr.Token(TrackingOpen, "to@example.com", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []TrackingEventType
+	h := tr.Handler(func(ctx context.Context, e *TrackingEvent) {
+		got = append(got, e.Type)
+	})
+
+	tests := []struct {
+		method string
+		token  string
+		want   int
+	}{
+		{http.MethodPost, click, http.StatusMethodNotAllowed},
+		{http.MethodGet, "invalid", http.StatusForbidden},
+		{http.MethodGet, click, http.StatusFound},
+		{http.MethodGet, open, http.StatusOK},
+		{http.MethodHead, click, http.StatusFound},
+		{http.MethodHead, open, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, "/t?t="+url.QueryEscape(test.token), nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != test.want {
+			t.Errorf("%s: invalid status, got %d, want %d", test.method, w.Code, test.want)
+		}
+		switch w.Code {
+		case http.StatusFound:
+			if loc := w.Header().Get("Location"); loc != "https://example.com/shop" {
+				t.Errorf("invalid redirection, got %q", loc)
+			}
+		case http.StatusOK:
+			if ct := w.Header().Get("Content-Type"); ct != "image/gif" || !bytes.Equal(w.Body.Bytes(), trackingPixel) {
+				t.Errorf("invalid pixel, got %q %q", ct, w.Body.Bytes())
+			}
+		}
+	}
+
+	if len(got) != 2 || got[0] != TrackingClick || got[1] != TrackingOpen {
+		t.Errorf("callback not called with the events, got %v", got)
+	}
+}
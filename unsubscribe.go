@@ -0,0 +1,172 @@
+package gomail
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ListUnsubscribe describes how recipients can unsubscribe from a mailing
+// list. It is used with SetListUnsubscribe to add the List-Unsubscribe (RFC
+// 2369) and List-Unsubscribe-Post (RFC 8058) headers, and with Handler to
+// process one-click unsubscribe requests.
+type ListUnsubscribe struct {
+	// List identifies the mailing list. It is part of the signed token so a
+	// token issued for a list cannot be used for another one.
+	List string
+	// Mailto is the address receiving unsubscribe emails. When set, a mailto
+	// URI whose subject contains the token is added to List-Unsubscribe.
+	Mailto string
+	// URL is the HTTPS endpoint serving Handler. When set, the token is added
+	// to it as the "token" query parameter.
+	URL string
+	// OneClick adds the List-Unsubscribe-Post header to advertise RFC 8058
+	// one-click unsubscription. It requires URL to be set.
+	OneClick bool
+	// Keys are used to sign and verify tokens. The first key signs new
+	// tokens while all of them are accepted when verifying, so keys can be
+	// rotated by prepending a new one.
+	Keys []TokenKey
+}
+
+// An UnsubscribeRequest is the content of a verified unsubscribe token.
+type UnsubscribeRequest struct {
+	List      string
+	Recipient string
+	// KeyID is the ID of the key that signed the token.
+	KeyID string
+}
+
+// SetListUnsubscribe is a message setting to add the List-Unsubscribe and
+// List-Unsubscribe-Post headers. The token is signed for the recipient of the
+// message when it is written, so the setting survives Message.Reset and can be
+// used for bulk sending.
+//
+// As the token unsubscribes its recipient, each recipient needs their own
+// message: writing the message fails with ErrSeveralRecipients if it has
+// several recipients, and with ErrBccRecipient if its recipient is only in
+// the Bcc field.
+//
+// Headers already set on the message are left untouched.
+func SetListUnsubscribe(u *ListUnsubscribe) MessageSetting {
+	return func(m *Message) {
+		m.unsubscribe = u
+	}
+}
+
+// Token returns a signed token for the given recipient.
+func (u *ListUnsubscribe) Token(recipient string) (string, error) {
+	if len(u.Keys) == 0 {
+		return "", ErrUnsubscribeKeyAbsent
+	}
+
+	return signToken(u.Keys[0], u.List, recipient), nil
+}
+
+// Verify checks the signature of a token and returns its content.
+func (u *ListUnsubscribe) Verify(token string) (*UnsubscribeRequest, error) {
+	fields, keyID, ok := verifyToken(u.Keys, token, 2)
+	if !ok || fields[0] != u.List {
+		return nil, ErrInvalidUnsubscribeToken
+	}
+
+	return &UnsubscribeRequest{
+		List:      fields[0],
+		Recipient: fields[1],
+		KeyID:     keyID,
+	}, nil
+}
+
+// Header returns the values of the List-Unsubscribe and List-Unsubscribe-Post
+// headers for the given recipient. The second value is empty when OneClick is
+// not set.
+func (u *ListUnsubscribe) Header(recipient string) (string, string, error) {
+	if u.OneClick && u.URL == "" {
+		return "", "", ErrOneClickURLAbsent
+	}
+
+	token, err := u.Token(recipient)
+	if err != nil {
+		return "", "", err
+	}
+
+	var uris []string
+	if u.Mailto != "" {
+		uris = append(uris, "<mailto:"+u.Mailto+"?subject="+url.QueryEscape("unsubscribe "+token)+">")
+	}
+	if u.URL != "" {
+		link, err := url.Parse(u.URL)
+		if err != nil {
+			return "", "", err
+		}
+		q := link.Query()
+		q.Set("token", token)
+		link.RawQuery = q.Encode()
+		uris = append(uris, "<"+link.String()+">")
+	}
+
+	var post string
+	if u.OneClick {
+		post = "List-Unsubscribe=One-Click"
+	}
+
+	return strings.Join(uris, ", "), post, nil
+}
+
+// Handler returns an http.Handler processing RFC 8058 one-click unsubscribe
+// requests sent to URL. It verifies the token and calls fn, responding with
+// 200 OK when fn succeeds.
+func (u *ListUnsubscribe) Handler(fn func(ctx context.Context, r *UnsubscribeRequest) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		if r.FormValue("List-Unsubscribe") != "One-Click" {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+
+		req, err := u.Verify(r.URL.Query().Get("token"))
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		if err := fn(r.Context(), req); err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+func (w *messageWriter) writeListUnsubscribe(m *Message) {
+	if m.unsubscribe == nil {
+		return
+	}
+	if _, ok := m.header["List-Unsubscribe"]; ok {
+		return
+	}
+
+	to, err := m.getTokenRecipient()
+	if err != nil {
+		w.err = err
+		return
+	}
+
+	list, post, err := m.unsubscribe.Header(to)
+	if err != nil {
+		w.err = err
+		return
+	}
+
+	w.writeHeader("List-Unsubscribe", list)
+	if _, ok := m.header["List-Unsubscribe-Post"]; !ok && post != "" {
+		w.writeHeader("List-Unsubscribe-Post", post)
+	}
+}
@@ -0,0 +1,181 @@
+package gomail
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func testListUnsubscribe() *ListUnsubscribe {
+	return &ListUnsubscribe{
+		List:     "news",
+		Mailto:   "unsubscribe@example.com",
+		URL:      "https://example.com/unsubscribe",
+		OneClick: true,
+		Keys: []TokenKey{
+			{ID: "k1", Secret: []byte("secret")},
+		},
+	}
+}
+
+func TestUnsubscribeToken(t *testing.T) {
+	u := testListUnsubscribe()
+	token, err := u.Token("to@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := u.Verify(token)
+	if err != nil {
+		t.Fatalf("Verify(): %v", err)
+	}
+	if r.Recipient != "to@example.com" || r.List != "news" || r.KeyID != "k1" {
+		t.Errorf("invalid request, got %+v", r)
+	}
+
+	if _, err := u.Verify(token[:len(token)-2]); !errors.Is(err, ErrInvalidUnsubscribeToken) {
+		t.Errorf("expected ErrInvalidUnsubscribeToken for a tampered token, got %v", err)
+	}
+
+	other := testListUnsubscribe()
+	other.List = "offers"
+	if _, err := other.Verify(token); !errors.Is(err, ErrInvalidUnsubscribeToken) {
+		t.Errorf("expected ErrInvalidUnsubscribeToken for another list, got %v", err)
+	}
+}
+
+func TestUnsubscribeKeyRotation(t *testing.T) {
+	old := testListUnsubscribe()
+	token, err := old.Token("to@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := testListUnsubscribe()
+	rotated.Keys = append([]TokenKey{{ID: "k2", Secret: []byte("new secret")}}, rotated.Keys...)
+	r, err := rotated.Verify(token)
+	if err != nil {
+		t.Fatalf("Verify(): %v", err)
+	}
+	if r.KeyID != "k1" {
+		t.Errorf("invalid key ID, got %q, want %q", r.KeyID, "k1")
+	}
+
+	newToken, err := rotated.Token("to@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := old.Verify(newToken); !errors.Is(err, ErrInvalidUnsubscribeToken) {
+		t.Errorf("expected ErrInvalidUnsubscribeToken for an unknown key, got %v", err)
+	}
+}
+
+func TestListUnsubscribeHeader(t *testing.T) {
+	u := testListUnsubscribe()
+	token, err := u.Token("to@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewMessage(SetListUnsubscribe(u))
+	m.SetHeader("From", "from@example.com")
+	m.SetHeader("To", "to@example.com")
+
+	want := &message{
+		from: "from@example.com",
+		to:   []string{"to@example.com"},
+		content: "From: from@example.com\r\n" +
+			"To: to@example.com\r\n" +
+			"List-Unsubscribe: <mailto:unsubscribe@example.com?subject=unsubscribe+" + token + ">,\r\n" +
+			" <https://example.com/unsubscribe?token=" + token + ">\r\n" +
+			"List-Unsubscribe-Post: List-Unsubscribe=One-Click\r\n",
+	}
+
+	testMessage(t, m, 0, want)
+}
+
+func TestListUnsubscribeOneClickWithoutURL(t *testing.T) {
+	u := testListUnsubscribe()
+	u.URL = ""
+
+	m := NewMessage(SetListUnsubscribe(u))
+	m.SetHeader("From", "from@example.com")
+	m.SetHeader("To", "to@example.com")
+
+	if _, err := m.WriteTo(new(strings.Builder)); !errors.Is(err, ErrOneClickURLAbsent) {
+		t.Errorf("expected ErrOneClickURLAbsent, got %v", err)
+	}
+}
+
+func TestListUnsubscribeRecipients(t *testing.T) {
+	tests := []struct {
+		name   string
+		header map[string][]string
+		want   error
+	}{
+		{"Cc", map[string][]string{"Cc": {"cc@example.com"}}, nil},
+		{"Several", map[string][]string{"To": {"to@example.com"}, "Cc": {"cc@example.com"}}, ErrSeveralRecipients},
+		{"Bcc", map[string][]string{"Bcc": {"bcc@example.com"}}, ErrBccRecipient},
+		{"None", nil, ErrInvalidMessageRecipientAbsent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMessage(SetListUnsubscribe(testListUnsubscribe()))
+			m.SetHeader("From", "from@example.com")
+			m.SetHeaders(tt.header)
+
+			var buf strings.Builder
+			if _, err := m.WriteTo(&buf); !errors.Is(err, tt.want) {
+				t.Fatalf("got error %v, want %v", err, tt.want)
+			}
+			if tt.want == nil && !strings.Contains(buf.String(), "List-Unsubscribe: ") {
+				t.Errorf("no List-Unsubscribe header:\n%s", buf.String())
+			}
+		})
+	}
+}
+
+func TestUnsubscribeHandler(t *testing.T) {
+	u := testListUnsubscribe()
+	token, err := u.Token("to@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got *UnsubscribeRequest
+	h := u.Handler(func(_ context.Context, r *UnsubscribeRequest) error {
+		got = r
+		return nil
+	})
+
+	tests := []struct {
+		method string
+		token  string
+		body   string
+		want   int
+	}{
+		{http.MethodGet, token, "", http.StatusMethodNotAllowed},
+		{http.MethodPost, token, "", http.StatusBadRequest},
+		{http.MethodPost, "invalid", "List-Unsubscribe=One-Click", http.StatusForbidden},
+		{http.MethodPost, token, "List-Unsubscribe=One-Click", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, "/unsubscribe?token="+url.QueryEscape(test.token), strings.NewReader(test.body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != test.want {
+			t.Errorf("%s %q: invalid status, got %d, want %d", test.method, test.body, w.Code, test.want)
+		}
+	}
+
+	if got == nil || got.Recipient != "to@example.com" {
+		t.Errorf("callback not called with the recipient, got %+v", got)
+	}
+}
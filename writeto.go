@@ -1,6 +1,7 @@
 package gomail
 
 import (
+	"bytes"
 	"encoding/base64"
 	"io"
 	"mime"
@@ -12,12 +13,84 @@ import (
 
 // WriteTo implements io.WriterTo. It dumps the whole message into w.
 func (m *Message) WriteTo(w io.Writer) (int64, error) {
-	mw := &messageWriter{w: w}
+	return m.writeTo(w, false)
+}
+
+// writeTo writes the message to w, with 7-bit transfer encodings only when
+// sevenBit is set.
+func (m *Message) writeTo(w io.Writer, sevenBit bool) (int64, error) {
+	if m.isSigned() {
+		return m.writeSigned(w, sevenBit)
+	}
+
+	mw := &messageWriter{w: w, sevenBit: sevenBit}
 	mw.writeMessage(m)
 	return mw.n, mw.err
 }
 
+// isSigned reports whether the message is signed or encrypted when it is
+// written.
+func (m *Message) isSigned() bool {
+	return m.dkim != nil || m.smimeSigner != nil || m.smimeEncrypter != nil ||
+		m.pgpSigner != nil || m.pgpEncrypter != nil
+}
+
+// writeSigned renders the message in memory so that it can be signed or
+// encrypted with S/MIME or PGP/MIME, and signed with DKIM, before it is written to w.
+func (m *Message) writeSigned(w io.Writer, sevenBit bool) (int64, error) {
+	var buf bytes.Buffer
+	mw := &messageWriter{w: &buf, sevenBit: sevenBit}
+	mw.writeMessage(m)
+	if mw.err != nil {
+		return 0, mw.err
+	}
+
+	msg := buf.Bytes()
+	var err error
+	if m.smimeSigner != nil {
+		if msg, err = m.smimeSigner.Sign(msg); err != nil {
+			return 0, err
+		}
+	}
+	if m.smimeEncrypter != nil {
+		to, err := m.getRecipients()
+		if err != nil {
+			return 0, err
+		}
+		if msg, err = m.smimeEncrypter.Encrypt(msg, to); err != nil {
+			return 0, err
+		}
+	}
+	if m.pgpSigner != nil {
+		if msg, err = m.pgpSigner.Sign(msg); err != nil {
+			return 0, err
+		}
+	}
+	if m.pgpEncrypter != nil {
+		to, err := m.getRecipients()
+		if err != nil {
+			return 0, err
+		}
+		if msg, err = m.pgpEncrypter.Encrypt(msg, to); err != nil {
+			return 0, err
+		}
+	}
+	if m.dkim != nil {
+		if msg, err = m.dkim.Sign(msg); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.Write(msg)
+	return int64(n), err
+}
+
 func (w *messageWriter) writeMessage(m *Message) {
+	if w.err = m.validateAMP(); w.err != nil {
+		return
+	}
+
+	w.writeResent(m)
 	if _, ok := m.header["MIME-Version"]; !ok {
 		w.writeString("MIME-Version: 1.0\r\n")
 	}
@@ -25,6 +98,20 @@ func (w *messageWriter) writeMessage(m *Message) {
 		w.writeHeader("Date", m.FormatDate(now()))
 	}
 	w.writeHeaders(m.header)
+	w.writeListUnsubscribe(m)
+	w.writeAutocrypt(m)
+	w.writeDispositionNotificationTo(m)
+	w.writeTLSRequired(m)
+	if w.err != nil {
+		return
+	}
+
+	var tr *tracker
+	if m.tracking != nil {
+		if tr, w.err = m.newTracker(); w.err != nil {
+			return
+		}
+	}
 
 	if m.hasMixedPart() {
 		w.openMultipart("mixed", m.boundary)
@@ -35,10 +122,10 @@ func (w *messageWriter) writeMessage(m *Message) {
 	}
 
 	if m.hasAlternativePart() {
-		w.openMultipart("alternative", m.boundary)
+		w.openMultipart(m.partsMultipart(), m.boundary)
 	}
-	for _, part := range m.parts {
-		w.writePart(part, m.charset)
+	for _, part := range m.orderedParts() {
+		w.writePart(part, tr)
 	}
 	if m.hasAlternativePart() {
 		w.closeMultipart()
@@ -67,6 +154,14 @@ func (m *Message) hasAlternativePart() bool {
 	return len(m.parts) > 1
 }
 
+// partsMultipart returns the multipart subtype grouping the parts.
+func (m *Message) partsMultipart() string {
+	if m.reportType != "" {
+		return "report; report-type=" + m.reportType
+	}
+	return "alternative"
+}
+
 type messageWriter struct {
 	w          io.Writer
 	n          int64
@@ -74,6 +169,11 @@ type messageWriter struct {
 	partWriter io.Writer
 	depth      uint8
 	err        error
+	// sizeOnly is set when the message is only written to compute its size.
+	sizeOnly bool
+	// sevenBit is set when the message is sent to a server not supporting
+	// 8BITMIME, in which case the Unencoded parts are downgraded.
+	sevenBit bool
 }
 
 func (w *messageWriter) openMultipart(mimeType, boundary string) {
@@ -106,17 +206,73 @@ func (w *messageWriter) closeMultipart() {
 	}
 }
 
-func (w *messageWriter) writePart(p *part, charset string) {
-	w.writeHeaders(map[string][]string{
-		"Content-Type":              {p.contentType + "; charset=" + charset},
-		"Content-Transfer-Encoding": {string(p.encoding)},
-	})
-	w.writeBody(p.copier, p.encoding)
+func (w *messageWriter) writePart(p *part, tr *tracker) {
+	contentType := p.contentType
+	if p.charset != "" {
+		contentType += "; charset=" + p.charset
+	}
+	for _, param := range p.params {
+		contentType += "; " + param[0] + "=" + quoteParamValue(param[1])
+	}
+	copier := p.copier
+	if tr != nil && tr.appliesTo(contentType) {
+		copier = tr.copier(copier)
+	}
+	if p.flowed != nil && p.flowed.appliesTo(contentType) {
+		contentType += p.flowed.contentType()
+		copier = p.flowed.copier(copier)
+	}
+
+	enc := p.encoding
+	if w.sevenBit && enc == Unencoded {
+		if enc, copier, w.err = downgradePart(p.mediaType(), copier); w.err != nil {
+			return
+		}
+	}
+
+	h := map[string][]string{
+		"Content-Type":              {contentType},
+		"Content-Transfer-Encoding": {string(enc)},
+	}
+	for k, v := range p.header {
+		h[k] = v
+	}
+	w.writeHeaders(h)
+	w.writeBody(copier, enc)
+}
+
+// quoteParamValue returns value as a MIME parameter value, quoted if it is
+// not a token (RFC 2045, section 5.1).
+func quoteParamValue(value string) string {
+	if value != "" && !strings.ContainsAny(value, " ()<>@,;:\\\"/[]?=") && isASCIIPrintable(value) {
+		return value
+	}
+
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(value); i++ {
+		if value[i] == '"' || value[i] == '\\' {
+			sb.WriteByte('\\')
+		}
+		sb.WriteByte(value[i])
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+func isASCIIPrintable(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] <= ' ' || s[i] >= 0x7f {
+			return false
+		}
+	}
+	return true
 }
 
 func (w *messageWriter) addFiles(files []*file, isAttachment bool) {
 	for _, f := range files {
-		if _, ok := f.Header["Content-Type"]; !ok {
+		_, hasType := f.Header["Content-Type"]
+		if !hasType && !f.detectType {
 			mediaType := mime.TypeByExtension(filepath.Ext(f.Name))
 			if mediaType == "" {
 				mediaType = "application/octet-stream"
@@ -125,7 +281,7 @@ func (w *messageWriter) addFiles(files []*file, isAttachment bool) {
 		}
 
 		if _, ok := f.Header["Content-Transfer-Encoding"]; !ok {
-			f.setHeader("Content-Transfer-Encoding", string(Base64))
+			f.setHeader("Content-Transfer-Encoding", string(f.contentEncoding()))
 		}
 
 		if _, ok := f.Header["Content-Disposition"]; !ok {
@@ -143,8 +299,19 @@ func (w *messageWriter) addFiles(files []*file, isAttachment bool) {
 				f.setHeader("Content-ID", "<"+f.Name+">")
 			}
 		}
-		w.writeHeaders(f.Header)
-		w.writeBody(f.CopyFunc, Base64)
+		enc := w.fileEncoding(f)
+		if !hasType && f.detectType {
+			w.writeDetectedFile(f, enc)
+			continue
+		}
+
+		copier, err := w.fileCopier(f, enc)
+		if err != nil {
+			w.err = err
+			return
+		}
+		w.writeHeaders(fileHeader(f, enc))
+		w.writeBody(copier, enc)
 	}
 }
 
@@ -257,28 +424,39 @@ func (w *messageWriter) writeHeaders(h map[string][]string) {
 }
 
 func (w *messageWriter) writeBody(f func(io.Writer) error, enc Encoding) {
+	wc := w.bodyWriter(enc)
+	w.err = f(wc)
+	wc.Close()
+}
+
+// bodyWriter returns the writer encoding the body of the current part.
+func (w *messageWriter) bodyWriter(enc Encoding) io.WriteCloser {
 	var subWriter io.Writer
 	if w.depth == 0 {
 		w.writeString("\r\n")
-		subWriter = w.w
+		subWriter = w
 	} else {
 		subWriter = w.partWriter
 	}
 
 	switch enc {
 	case Base64:
-		wc := base64.NewEncoder(base64.StdEncoding, newBase64LineWriter(subWriter))
-		w.err = f(wc)
-		wc.Close()
-	case Unencoded:
-		w.err = f(subWriter)
+		return base64.NewEncoder(base64.StdEncoding, newBase64LineWriter(subWriter))
+	case Unencoded, Binary, encoding7bit:
+		return nopCloser{subWriter}
 	default:
-		wc := newQPWriter(subWriter)
-		w.err = f(wc)
-		wc.Close()
+		return newQPWriter(subWriter)
 	}
 }
 
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
 // As required by RFC 2045, 6.7. (page 21) for quoted-printable, and
 // RFC 2045, 6.8. (page 25) for base64.
 const maxLineLen = 76